@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/nickg76/garage-backend/internal/db"
+)
+
+func runAppointments(ctx context.Context, q *db.Queries, args []string) error {
+	if len(args) == 0 || args[0] != "list" {
+		return errUsage
+	}
+	fs := flag.NewFlagSet("appointments list", flag.ContinueOnError)
+	date := fs.String("date", time.Now().Format(time.DateOnly), "day to list, YYYY-MM-DD")
+	if err := fs.Parse(args[1:]); err != nil || fs.NArg() > 0 {
+		return errUsage
+	}
+	start, end, err := dayRange(*date, time.Local)
+	if err != nil {
+		return fmt.Errorf("--date must be YYYY-MM-DD: %w", err)
+	}
+
+	appts, err := q.GetAppointmentsForDay(ctx, db.GetAppointmentsForDayParams{
+		DayStart: start,
+		DayEnd:   end,
+	})
+	if err != nil {
+		return err
+	}
+	if len(appts) == 0 {
+		fmt.Printf("no bookings on %s\n", *date)
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "TIME\tSTATUS\tTITLE\tCUSTOMER\tEMAIL\tPHONE\tID")
+	for _, a := range appts {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			a.Datetime.In(time.Local).Format("15:04"), a.Status, a.Title, a.UserName, a.UserEmail, a.UserPhone, a.ID)
+	}
+	return tw.Flush()
+}
+
+// dayRange is the start of the given day and of the next one in loc, the
+// same zone the --date default is taken from.
+func dayRange(date string, loc *time.Location) (time.Time, time.Time, error) {
+	day, err := time.ParseInLocation(time.DateOnly, date, loc)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	return day, day.AddDate(0, 0, 1), nil
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDayRangeUsesLocation(t *testing.T) {
+	loc := time.FixedZone("UTC+10", 10*3600)
+	start, end, err := dayRange("2026-03-14", loc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Date(2026, 3, 13, 14, 0, 0, 0, time.UTC); !start.Equal(want) {
+		t.Errorf("start = %v, want %v", start.UTC(), want)
+	}
+	if end.Sub(start) != 24*time.Hour {
+		t.Errorf("range is %v long", end.Sub(start))
+	}
+
+	if _, _, err := dayRange("14/03/2026", loc); err == nil {
+		t.Error("accepted a non ISO date")
+	}
+}
@@ -0,0 +1,83 @@
+// Command garagectl runs operational tasks against the garage database so
+// on-call doesn't need psql: managing accounts, listing bookings, applying
+// migrations, purging expired data and seeding development data.
+//
+// It reads the same settings as the server (see config.example.yaml) but only
+// needs DATABASE_URL.
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+
+	_ "github.com/lib/pq"
+
+	"github.com/nickg76/garage-backend/internal/config"
+	"github.com/nickg76/garage-backend/internal/db"
+)
+
+const usage = `usage: garagectl <command> [arguments]
+
+commands:
+  users promote <email>          make an account an admin
+  users demote <email>           remove admin rights
+  users disable <email>          block an account from logging in
+  users enable <email>           undo disable
+  users reset-mfa <email>        remove two-factor authentication, e.g. lost phone
+  users reset-password <email>   set a random password and clear any lockout
+  appointments list [--date D]   bookings on day D (YYYY-MM-DD, default today)
+  migrate up|down|status         apply, roll back or list migrations
+  migrate baseline <version>     mark migrations up to version as applied
+                                 on a database migrated by hand
+  purge                          delete expired tokens, challenges and keys
+  seed                           create demo accounts and bookings (DEV_MODE only)`
+
+// errUsage makes main print the usage text and exit with status 2.
+var errUsage = errors.New("usage")
+
+func main() {
+	if len(os.Args) < 2 || os.Args[1] == "help" || os.Args[1] == "-h" || os.Args[1] == "--help" {
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+	cfg, err := config.LoadDatabase()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "config: %v\n", err)
+		os.Exit(1)
+	}
+	conn, err := sql.Open("postgres", cfg.DatabaseURL)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "database: %v\n", err)
+		os.Exit(1)
+	}
+	defer conn.Close()
+
+	if err := run(context.Background(), cfg, conn, os.Args[1], os.Args[2:]); err != nil {
+		if errors.Is(err, errUsage) {
+			fmt.Fprintln(os.Stderr, usage)
+			os.Exit(2)
+		}
+		fmt.Fprintf(os.Stderr, "garagectl: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, cfg *config.Config, conn *sql.DB, cmd string, args []string) error {
+	q := db.New(conn)
+	switch cmd {
+	case "users":
+		return runUsers(ctx, q, args)
+	case "appointments":
+		return runAppointments(ctx, q, args)
+	case "migrate":
+		return runMigrate(ctx, conn, args)
+	case "purge":
+		return runPurge(ctx, q, args)
+	case "seed":
+		return runSeed(ctx, cfg, q, args)
+	}
+	return errUsage
+}
@@ -0,0 +1,18 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+
+	"github.com/nickg76/garage-backend/internal/migrate"
+)
+
+func runMigrate(ctx context.Context, conn *sql.DB, args []string) error {
+	err := migrate.RunCommand(ctx, conn, args, os.Stdout)
+	if errors.Is(err, migrate.ErrUsage) {
+		return errUsage
+	}
+	return err
+}
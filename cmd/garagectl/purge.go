@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/nickg76/garage-backend/internal/db"
+)
+
+// runPurge deletes rows that are only kept until they expire. The server
+// does the same once an hour; this is for instances that have been down, or
+// a database no server is running against.
+func runPurge(ctx context.Context, q *db.Queries, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+	now := time.Now().UTC()
+	for _, p := range []struct {
+		what  string
+		purge func() error
+	}{
+		{"idempotency keys", func() error { return q.DeleteExpiredIdempotencyKeys(ctx) }},
+		{"sign-in links", func() error { return q.DeleteExpiredMagicLinkTokens(ctx, now) }},
+		{"passkey challenges", func() error { return q.DeleteExpiredWebAuthnChallenges(ctx, now) }},
+		{"single sign-on states", func() error { return q.DeleteExpiredOIDCStates(ctx, now) }},
+		// the same age the postgres rate limiter keeps idle buckets for
+		{"rate limit buckets", func() error { return q.DeleteStaleRateLimitBuckets(ctx, now.Add(-24*time.Hour)) }},
+	} {
+		if err := p.purge(); err != nil {
+			return fmt.Errorf("purging %s: %w", p.what, err)
+		}
+		fmt.Printf("purged expired %s\n", p.what)
+	}
+	return nil
+}
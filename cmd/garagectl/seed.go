@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/nickg76/garage-backend/internal/config"
+	"github.com/nickg76/garage-backend/internal/db"
+)
+
+// runSeed fills an empty development database with an admin, a customer and
+// a few of the customer's bookings. Accounts that already exist are left
+// alone, so it is safe to run twice.
+func runSeed(ctx context.Context, cfg *config.Config, q *db.Queries, args []string) error {
+	fs := flag.NewFlagSet("seed", flag.ContinueOnError)
+	password := fs.String("password", "password123", "password for the seeded accounts")
+	if err := fs.Parse(args); err != nil || fs.NArg() > 0 {
+		return errUsage
+	}
+	if !cfg.DevMode {
+		return errors.New("seed only runs with DEV_MODE=true")
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(*password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	if _, _, err := seedUser(ctx, q, "Workshop Admin", "admin@example.com", string(hash), true); err != nil {
+		return err
+	}
+	customer, created, err := seedUser(ctx, q, "Demo Customer", "customer@example.com", string(hash), false)
+	if err != nil {
+		return err
+	}
+	if !created {
+		return nil
+	}
+
+	// wall-clock times in the workshop's zone, tomorrow and the day after
+	y, m, d := time.Now().Date()
+	for _, a := range []struct {
+		day, hour   int
+		title       string
+		description string
+	}{
+		{1, 9, "MOT", "Annual MOT test"},
+		{1, 11, "Full service", "Includes oil and filter change"},
+		{2, 14, "Brake check", "Squealing from the front left"},
+	} {
+		if _, err := q.CreateAppointment(ctx, db.CreateAppointmentParams{
+			ID:          uuid.New(),
+			UserID:      uuid.NullUUID{UUID: customer.ID, Valid: true},
+			Datetime:    time.Date(y, m, d+a.day, a.hour, 0, 0, 0, time.Local),
+			Title:       a.title,
+			Description: sql.NullString{String: a.description, Valid: true},
+		}); err != nil {
+			return err
+		}
+	}
+	fmt.Println("created 3 bookings for customer@example.com")
+	return nil
+}
+
+func seedUser(ctx context.Context, q *db.Queries, name, email, hash string, admin bool) (db.User, bool, error) {
+	u, err := q.GetUserByEmail(ctx, email)
+	if err == nil {
+		fmt.Printf("%s already exists\n", email)
+		return u, false, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return u, false, err
+	}
+	u, err = q.CreateUser(ctx, db.CreateUserParams{
+		ID:           uuid.New(),
+		Name:         name,
+		Email:        email,
+		PasswordHash: hash,
+		Phone:        "+44 20 7946 0000",
+		IsAdmin:      sql.NullBool{Bool: admin, Valid: true},
+	})
+	if err != nil {
+		return u, false, err
+	}
+	fmt.Printf("created %s\n", email)
+	return u, true, nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"fmt"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/nickg76/garage-backend/internal/db"
+)
+
+// runUsers changes flags on a single account, identified by email.
+func runUsers(ctx context.Context, q *db.Queries, args []string) error {
+	if len(args) != 2 {
+		return errUsage
+	}
+	email := args[1]
+
+	var n int64
+	var err error
+	var done string
+	switch args[0] {
+	case "promote":
+		n, err = q.SetAdmin(ctx, db.SetAdminParams{Email: email, IsAdmin: sql.NullBool{Bool: true, Valid: true}})
+		done = "promoted to admin"
+	case "demote":
+		n, err = q.SetAdmin(ctx, db.SetAdminParams{Email: email, IsAdmin: sql.NullBool{Bool: false, Valid: true}})
+		done = "admin rights removed"
+	case "disable":
+		n, err = q.SetUserDisabled(ctx, db.SetUserDisabledParams{Email: email, DisabledAt: sql.NullTime{Time: time.Now(), Valid: true}})
+		done = "disabled"
+	case "enable":
+		n, err = q.SetUserDisabled(ctx, db.SetUserDisabledParams{Email: email})
+		done = "enabled"
+	case "reset-mfa":
+		// recovery codes are replaced when the user enrolls again
+		n, err = q.DisableTOTP(ctx, email)
+		done = "two-factor authentication reset"
+	case "reset-password":
+		var password string
+		n, password, err = resetPassword(ctx, q, email)
+		done = "password reset, lockout cleared; new password " + password
+	default:
+		return errUsage
+	}
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("no account with email %s", email)
+	}
+	fmt.Printf("%s: %s\n", email, done)
+	return nil
+}
+
+// resetPassword gives the account a random password, to pass on to its
+// owner, and clears any lockout.
+func resetPassword(ctx context.Context, q *db.Queries, email string) (int64, string, error) {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return 0, "", err
+	}
+	password := base64.RawURLEncoding.EncodeToString(b)
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return 0, "", err
+	}
+	n, err := q.SetPasswordHash(ctx, db.SetPasswordHashParams{Email: email, PasswordHash: string(hash)})
+	return n, password, err
+}
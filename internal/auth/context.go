@@ -6,4 +6,9 @@ type contextKey string
 const (
 	UserIDKey	contextKey = "user_id"
 	AdminKey	contextKey = "is_admin"
+	RequestIDKey	contextKey = "request_id"
+	APIVersionKey	contextKey = "api_version"
+	LoggerKey	contextKey = "logger"
+	AccessInfoKey	contextKey = "access_info"
+	MFAPendingKey	contextKey = "mfa_pending"
 )
@@ -3,7 +3,6 @@ package auth
 
 import (
 	"errors"
-	"os"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -12,32 +11,110 @@ import (
 type Claims struct {
 	Sub   string `json:"sub"`
 	Admin bool   `json:"admin"`
+	// MFA is set when the session was established with a second factor.
+	MFA bool `json:"mfa,omitempty"`
+	// Purpose marks single-purpose tokens (such as the MFA challenge) that
+	// must not be accepted as a session.
+	Purpose string `json:"purpose,omitempty"`
 	jwt.RegisteredClaims
 }
 
-func jwtSecret() []byte {
-	secret := os.Getenv("JWT_SECRET")
-	return []byte(secret)
+const purposeMFAChallenge = "mfa_challenge"
+
+// MFAChallengeTTL is how long a user has to enter their code after the
+// password step.
+const MFAChallengeTTL = 5 * time.Minute
+
+func (k *Keys) GenerateJWT(userID string, isAdmin bool) (string, error) {
+	return k.sign(&Claims{Sub: userID, Admin: isAdmin}, 24*time.Hour)
+}
+
+// GenerateMFAJWT issues a session for a user who has also passed a second
+// factor.
+func (k *Keys) GenerateMFAJWT(userID string, isAdmin bool) (string, error) {
+	return k.sign(&Claims{Sub: userID, Admin: isAdmin, MFA: true}, 24*time.Hour)
+}
+
+// GenerateMFAChallenge issues the short-lived token returned by the password
+// step of a two-factor login. It only proves the password was right and is
+// rejected by ParseJWT.
+func (k *Keys) GenerateMFAChallenge(userID string) (string, error) {
+	return k.sign(&Claims{Sub: userID, Purpose: purposeMFAChallenge}, MFAChallengeTTL)
+}
+
+// ParseMFAChallenge validates a token from GenerateMFAChallenge and returns
+// the user ID it was issued for.
+func (k *Keys) ParseMFAChallenge(tokenStr string) (string, error) {
+	claims, err := k.parse(tokenStr)
+	if err != nil {
+		return "", err
+	}
+	if claims.Purpose != purposeMFAChallenge {
+		return "", errors.New("not an mfa challenge")
+	}
+	return claims.Sub, nil
+}
+
+func (k *Keys) sign(claims *Claims, ttl time.Duration) (string, error) {
+	now := time.Now()
+	claims.RegisteredClaims = jwt.RegisteredClaims{
+		Issuer:		k.Issuer,
+		ExpiresAt:	jwt.NewNumericDate(now.Add(ttl)),
+		IssuedAt:	jwt.NewNumericDate(now),
+	}
+	if k.Audience != "" {
+		claims.Audience = jwt.ClaimStrings{k.Audience}
+	}
+	if k.signing == nil {
+		return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(k.Secret)
+	}
+	token := jwt.NewWithClaims(k.signing.method, claims)
+	token.Header["kid"] = k.signing.id
+	return token.SignedString(k.signing.private)
 }
 
-func GenerateJWT(userID string, isAdmin bool) (string, error) {
-	claims := &Claims{
-		Sub:   userID,
-		Admin: isAdmin,
-		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt:	jwt.NewNumericDate(time.Now().Add(24 * time.Hour)),
-			IssuedAt:	jwt.NewNumericDate(time.Now()),
-		},
+// ParseJWT validates a session token.
+func (k *Keys) ParseJWT(tokenStr string) (*Claims, error) {
+	claims, err := k.parse(tokenStr)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Purpose != "" {
+		return nil, errors.New("not a session token")
 	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(jwtSecret())
+	return claims, nil
 }
 
-func ParseJWT(tokenStr string) (*Claims, error) {
+func (k *Keys) parse(tokenStr string) (*Claims, error) {
+	opts := []jwt.ParserOption{jwt.WithIssuedAt()}
+	if k.Issuer != "" {
+		opts = append(opts, jwt.WithIssuer(k.Issuer))
+	}
+	if k.Audience != "" {
+		opts = append(opts, jwt.WithAudience(k.Audience))
+	}
 	token, err := jwt.ParseWithClaims(tokenStr, &Claims{}, func(t *jwt.Token) (interface{}, error) {
-		return jwtSecret(), nil
-	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Name}))
+		if k.signing == nil {
+			if t.Method != jwt.SigningMethodHS256 {
+				return nil, errors.New("unexpected signing method")
+			}
+			return k.Secret, nil
+		}
+		// the key decides the algorithm, never the token
+		kid, _ := t.Header["kid"].(string)
+		key, ok := k.lookup(kid, time.Now())
+		if !ok {
+			return nil, errors.New("unknown or retired signing key")
+		}
+		if t.Method.Alg() != key.method.Alg() {
+			return nil, errors.New("unexpected signing method")
+		}
+		return key.private.Public(), nil
+	}, opts...)
 	if err != nil {
+		if claims, ok := k.parseLegacy(tokenStr); ok {
+			return claims, nil
+		}
 		return nil, err
 	}
 	claims, ok := token.Claims.(*Claims)
@@ -46,3 +123,22 @@ func ParseJWT(tokenStr string) (*Claims, error) {
 	}
 	return claims, nil
 }
+
+// parseLegacy accepts a token in the format used before signing keys and
+// iss/aud checks, while Keys.AcceptLegacyUntil allows it.
+func (k *Keys) parseLegacy(tokenStr string) (*Claims, bool) {
+	if k.legacyUntil.IsZero() || time.Now().After(k.legacyUntil) {
+		return nil, false
+	}
+	token, err := jwt.ParseWithClaims(tokenStr, &Claims{}, func(t *jwt.Token) (interface{}, error) {
+		return k.Secret, nil
+	}, jwt.WithIssuedAt(), jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+	if err != nil {
+		return nil, false
+	}
+	claims, ok := token.Claims.(*Claims)
+	if !ok || !token.Valid || claims.Issuer != "" || len(claims.Audience) > 0 {
+		return nil, false
+	}
+	return claims, true
+}
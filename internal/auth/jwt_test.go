@@ -0,0 +1,85 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// legacyToken is a session as issued before iss, aud and signing keys.
+func legacyToken(t *testing.T, secret string) string {
+	t.Helper()
+	now := time.Now()
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, &Claims{
+		Sub: "user-1",
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(now.Add(time.Hour)),
+			IssuedAt:  jwt.NewNumericDate(now),
+		},
+	}).SignedString([]byte(secret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func ed25519PEM(t *testing.T) []byte {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+}
+
+func TestLegacyTokensAcceptedUntilCutoff(t *testing.T) {
+	legacy := legacyToken(t, "test-secret")
+
+	hs256 := func() *Keys { return &Keys{Secret: []byte("test-secret"), Issuer: "garage", Audience: "garage"} }
+	signed := func() *Keys {
+		k := hs256()
+		if err := k.SetSigningKey(ed25519PEM(t)); err != nil {
+			t.Fatal(err)
+		}
+		return k
+	}
+	for name, keys := range map[string]func() *Keys{"hs256": hs256, "signing key": signed} {
+		t.Run(name, func(t *testing.T) {
+			k := keys()
+			if _, err := k.ParseJWT(legacy); err == nil {
+				t.Fatal("legacy token accepted without a cutoff")
+			}
+
+			k.AcceptLegacyUntil(time.Now().Add(time.Hour))
+			claims, err := k.ParseJWT(legacy)
+			if err != nil || claims.Sub != "user-1" {
+				t.Fatalf("before the cutoff: %+v, %v", claims, err)
+			}
+			if _, err := k.ParseJWT(legacyToken(t, "other-secret")); err == nil {
+				t.Fatal("legacy token signed with another secret accepted")
+			}
+			// new tokens carry iss and aud and verify as usual
+			token, err := k.GenerateJWT("user-2", false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if claims, err := k.ParseJWT(token); err != nil || claims.Sub != "user-2" {
+				t.Fatalf("current token: %+v, %v", claims, err)
+			}
+
+			k.AcceptLegacyUntil(time.Now().Add(-time.Minute))
+			if _, err := k.ParseJWT(legacy); err == nil {
+				t.Fatal("legacy token accepted after the cutoff")
+			}
+		})
+	}
+}
@@ -0,0 +1,180 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Keys is what session tokens and signed URLs are signed and verified with.
+// Without a signing key tokens are HS256 with Secret, which only this
+// service can verify. With one they are RS256 or EdDSA and other services
+// can check them against the JWKS.
+type Keys struct {
+	// Shared HMAC secret for signed URLs, and for tokens when there is no
+	// signing key.
+	Secret []byte
+	// Issuer and Audience go into every token and ParseJWT requires them.
+	// Empty values are neither set nor checked.
+	Issuer   string
+	Audience string
+
+	signing *signingKey
+	// every key tokens are accepted from, by kid, including signing
+	verify map[string]*signingKey
+	// until when HS256 tokens without iss and aud are still accepted
+	legacyUntil time.Time
+}
+
+type signingKey struct {
+	id      string
+	method  jwt.SigningMethod
+	private crypto.Signer
+	jwk     JSONWebKey
+	// zero for the current key, otherwise when a rotated-out key stops
+	// being accepted
+	until time.Time
+}
+
+// JSONWebKey is the public half of a signing key (RFC 7517).
+type JSONWebKey struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	// RSA
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// Ed25519
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+// SetSigningKey parses a PEM encoded RSA (2048 bits or more) or Ed25519
+// private key and signs new tokens with it.
+func (k *Keys) SetSigningKey(pemBytes []byte) error {
+	key, err := parseSigningKey(pemBytes)
+	if err != nil {
+		return err
+	}
+	k.signing = key
+	k.add(key)
+	return nil
+}
+
+// AddRetiredKey accepts tokens signed with a key that has been rotated out,
+// until the given time. Set it to at least the rotation time plus the
+// session lifetime so no one is signed out by the rotation.
+func (k *Keys) AddRetiredKey(pemBytes []byte, until time.Time) error {
+	key, err := parseSigningKey(pemBytes)
+	if err != nil {
+		return err
+	}
+	key.until = until
+	k.add(key)
+	return nil
+}
+
+// AcceptLegacyUntil keeps accepting tokens issued before signing keys,
+// issuer and audience were configured, HS256 with the shared secret and no
+// iss or aud, until the given time. Set it to at least the deploy time plus
+// the session lifetime so the upgrade doesn't sign everyone out.
+func (k *Keys) AcceptLegacyUntil(until time.Time) {
+	k.legacyUntil = until
+}
+
+func (k *Keys) add(key *signingKey) {
+	if k.verify == nil {
+		k.verify = map[string]*signingKey{}
+	}
+	k.verify[key.id] = key
+}
+
+// lookup returns the key a token names, if it is still accepted.
+func (k *Keys) lookup(kid string, now time.Time) (*signingKey, bool) {
+	key, ok := k.verify[kid]
+	if !ok || (!key.until.IsZero() && now.After(key.until)) {
+		return nil, false
+	}
+	return key, true
+}
+
+// JWKS returns the public keys tokens may currently be signed with, for
+// other services to verify them. It is empty in HS256 mode.
+func (k *Keys) JWKS() JSONWebKeySet {
+	now := time.Now()
+	set := JSONWebKeySet{Keys: []JSONWebKey{}}
+	// current key first, then retired ones
+	if k.signing != nil {
+		set.Keys = append(set.Keys, k.signing.jwk)
+	}
+	var retired []JSONWebKey
+	for _, key := range k.verify {
+		if _, ok := k.lookup(key.id, now); ok && key != k.signing {
+			retired = append(retired, key.jwk)
+		}
+	}
+	sort.Slice(retired, func(i, j int) bool { return retired[i].Kid < retired[j].Kid })
+	set.Keys = append(set.Keys, retired...)
+	return set
+}
+
+func parseSigningKey(pemBytes []byte) (*signingKey, error) {
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return nil, errors.New("jwt key: no PEM block found")
+	}
+	var parsed any
+	var err error
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("jwt key: unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("jwt key: %w", err)
+	}
+
+	b64 := base64.RawURLEncoding.EncodeToString
+	var key signingKey
+	// the thumbprint input (RFC 7638) is the required members in
+	// lexicographic order
+	var thumb string
+	switch p := parsed.(type) {
+	case *rsa.PrivateKey:
+		if p.N.BitLen() < 2048 {
+			return nil, errors.New("jwt key: RSA keys must be at least 2048 bits")
+		}
+		key.method, key.private = jwt.SigningMethodRS256, p
+		key.jwk = JSONWebKey{Kty: "RSA", N: b64(p.N.Bytes()), E: b64(big.NewInt(int64(p.E)).Bytes())}
+		thumb = fmt.Sprintf(`{"e":%q,"kty":"RSA","n":%q}`, key.jwk.E, key.jwk.N)
+	case ed25519.PrivateKey:
+		key.method, key.private = jwt.SigningMethodEdDSA, p
+		key.jwk = JSONWebKey{Kty: "OKP", Crv: "Ed25519", X: b64(p.Public().(ed25519.PublicKey))}
+		thumb = fmt.Sprintf(`{"crv":"Ed25519","kty":"OKP","x":%q}`, key.jwk.X)
+	default:
+		return nil, fmt.Errorf("jwt key: unsupported key type %T, use RSA or Ed25519", parsed)
+	}
+	sum := sha256.Sum256([]byte(thumb))
+	key.id = b64(sum[:])
+	key.jwk.Kid, key.jwk.Use, key.jwk.Alg = key.id, "sig", key.method.Alg()
+	return &key, nil
+}
@@ -0,0 +1,27 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+)
+
+// NewMagicLinkToken returns a random token for an emailed sign-in link and
+// the hash to store for it.
+func NewMagicLinkToken() (token, hash string, err error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", err
+	}
+	token = base64.RawURLEncoding.EncodeToString(buf)
+	return token, HashMagicLinkToken(token), nil
+}
+
+// HashMagicLinkToken is what gets stored for a sign-in link. Tokens carry 256
+// bits of randomness, so a plain SHA-256 is enough and lets the lookup happen
+// in SQL.
+func HashMagicLinkToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
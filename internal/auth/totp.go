@@ -0,0 +1,176 @@
+package auth
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// TOTP parameters (RFC 6238). These are the defaults every authenticator app
+// understands, so they're fixed rather than configurable.
+const (
+	totpDigits = 6
+	totpPeriod = 30
+	// totpSkew is how many periods either side of now are accepted, to allow
+	// for clock drift on the phone.
+	totpSkew = 1
+)
+
+var b32 = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// NewTOTPSecret returns a random 160-bit secret, base32 encoded as
+// authenticator apps expect.
+func NewTOTPSecret() (string, error) {
+	buf := make([]byte, 20)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return b32.EncodeToString(buf), nil
+}
+
+// sealedPrefix marks a secret encrypted by SealTOTPSecret and versions the
+// format.
+const sealedPrefix = "v1:"
+
+// TOTPKeySize is the length of the key secrets are sealed with (AES-256).
+const TOTPKeySize = 32
+
+// SealTOTPSecret encrypts secret for storage with AES-GCM. The user ID is
+// authenticated too, so a sealed secret can't be copied to another account.
+func SealTOTPSecret(key []byte, userID, secret string) (string, error) {
+	aead, err := totpAEAD(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(secret), []byte(userID))
+	return sealedPrefix + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// OpenTOTPSecret reverses SealTOTPSecret.
+func OpenTOTPSecret(key []byte, userID, stored string) (string, error) {
+	enc, ok := strings.CutPrefix(stored, sealedPrefix)
+	if !ok {
+		return "", errors.New("totp: secret is not sealed")
+	}
+	aead, err := totpAEAD(key)
+	if err != nil {
+		return "", err
+	}
+	sealed, err := base64.RawStdEncoding.DecodeString(enc)
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return "", errors.New("totp: malformed sealed secret")
+	}
+	n := aead.NonceSize()
+	secret, err := aead.Open(nil, sealed[:n], sealed[n:], []byte(userID))
+	if err != nil {
+		return "", fmt.Errorf("totp: opening secret: %w", err)
+	}
+	return string(secret), nil
+}
+
+// IsSealedTOTPSecret reports whether stored was encrypted by SealTOTPSecret.
+func IsSealedTOTPSecret(stored string) bool {
+	return strings.HasPrefix(stored, sealedPrefix)
+}
+
+func totpAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != TOTPKeySize {
+		return nil, fmt.Errorf("totp: key must be %d bytes", TOTPKeySize)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// TOTPProvisioningURI is the otpauth:// URI shown as a QR code during
+// enrollment.
+func TOTPProvisioningURI(issuer, account, secret string) string {
+	q := url.Values{}
+	q.Set("secret", secret)
+	q.Set("issuer", issuer)
+	q.Set("algorithm", "SHA1")
+	q.Set("digits", fmt.Sprint(totpDigits))
+	q.Set("period", fmt.Sprint(totpPeriod))
+	label := url.PathEscape(issuer + ":" + account)
+	return "otpauth://totp/" + label + "?" + q.Encode()
+}
+
+// VerifyTOTP checks code against secret at time t. On success it returns the
+// time step that matched so callers can refuse to accept it a second time.
+func VerifyTOTP(secret, code string, t time.Time) (int64, bool) {
+	key, err := b32.DecodeString(strings.ToUpper(strings.TrimSpace(secret)))
+	if err != nil || len(code) != totpDigits {
+		return 0, false
+	}
+	now := t.Unix() / totpPeriod
+	for step := now - totpSkew; step <= now+totpSkew; step++ {
+		if subtle.ConstantTimeCompare([]byte(totpCode(key, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// TOTPCode is the code an authenticator app shows for secret at time t.
+func TOTPCode(secret string, t time.Time) (string, error) {
+	key, err := b32.DecodeString(strings.ToUpper(strings.TrimSpace(secret)))
+	if err != nil {
+		return "", err
+	}
+	return totpCode(key, t.Unix()/totpPeriod), nil
+}
+
+// totpCode is the HOTP value (RFC 4226) for the given counter.
+func totpCode(key []byte, counter int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(counter))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	v := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, v%1_000_000)
+}
+
+// NewRecoveryCodes returns n single-use codes formatted as xxxxx-xxxxx.
+func NewRecoveryCodes(n int) ([]string, error) {
+	codes := make([]string, n)
+	for i := range codes {
+		buf := make([]byte, 5)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, err
+		}
+		s := hex.EncodeToString(buf)
+		codes[i] = s[:5] + "-" + s[5:]
+	}
+	return codes, nil
+}
+
+// HashRecoveryCode is what gets stored for a recovery code. Codes carry 40
+// bits of randomness and are single-use, so a plain SHA-256 is enough and
+// lets the lookup happen in SQL. Case and dashes are ignored so users can
+// type them however they were written down.
+func HashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,58 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTOTPCode(t *testing.T) {
+	// RFC 6238 appendix B, SHA-1, truncated to six digits
+	const secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+	for _, c := range []struct {
+		unix int64
+		want string
+	}{{59, "287082"}, {1111111109, "081804"}, {2000000000, "279037"}} {
+		at := time.Unix(c.unix, 0)
+		got, err := TOTPCode(secret, at)
+		if err != nil || got != c.want {
+			t.Errorf("TOTPCode at %d = %q, %v, want %q", c.unix, got, err, c.want)
+		}
+		if _, ok := VerifyTOTP(secret, got, at.Add(totpPeriod*time.Second)); !ok {
+			t.Errorf("code from %d not accepted one period later", c.unix)
+		}
+	}
+}
+
+func TestSealTOTPSecret(t *testing.T) {
+	key := make([]byte, TOTPKeySize)
+	for i := range key {
+		key[i] = byte(i)
+	}
+	secret, err := NewTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := SealTOTPSecret(key, "user-1", secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsSealedTOTPSecret(sealed) || strings.Contains(sealed, secret) {
+		t.Fatalf("sealed = %q, secret visible or prefix missing", sealed)
+	}
+	if got, err := OpenTOTPSecret(key, "user-1", sealed); err != nil || got != secret {
+		t.Fatalf("OpenTOTPSecret = %q, %v, want %q", got, err, secret)
+	}
+
+	if _, err := OpenTOTPSecret(key, "user-2", sealed); err == nil {
+		t.Error("secret opened for another user")
+	}
+	other := append([]byte(nil), key...)
+	other[0] ^= 1
+	if _, err := OpenTOTPSecret(other, "user-1", sealed); err == nil {
+		t.Error("secret opened with the wrong key")
+	}
+	if _, err := OpenTOTPSecret(key, "user-1", secret); err == nil {
+		t.Error("unsealed secret accepted")
+	}
+}
@@ -0,0 +1,668 @@
+// Package config loads every runtime setting once at startup.
+//
+// Settings are read, lowest precedence first, from built-in defaults, an
+// optional YAML file (CONFIG_FILE), a .env file and the process environment.
+// See config.example.yaml for a documented list.
+package config
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
+)
+
+type Config struct {
+	// Port the HTTP server listens on. Env: PORT.
+	Port string `yaml:"port"`
+	// Postgres connection string. Env: DATABASE_URL.
+	DatabaseURL string `yaml:"database_url"`
+	// HMAC key for signing download links, and JWTs unless jwt.signing_key_file
+	// is set. Env: JWT_SECRET.
+	JWTSecret string `yaml:"jwt_secret"`
+	// Enables development-only endpoints. Env: DEV_MODE.
+	DevMode bool `yaml:"dev_mode"`
+	// Apply pending migrations before serving. Env: AUTO_MIGRATE.
+	AutoMigrate bool `yaml:"auto_migrate"`
+
+	JWT     JWTConfig     `yaml:"jwt"`
+	Admin   AdminConfig   `yaml:"admin"`
+	Storage StorageConfig `yaml:"storage"`
+	HTTP    HTTPConfig    `yaml:"http"`
+	Log     LogConfig     `yaml:"log"`
+	Tracing TracingConfig `yaml:"tracing"`
+
+	RateLimit RateLimitConfig `yaml:"rate_limit"`
+	Lockout   LockoutConfig   `yaml:"lockout"`
+	MFA       MFAConfig       `yaml:"mfa"`
+	WebAuthn  WebAuthnConfig  `yaml:"webauthn"`
+	Mail      MailConfig      `yaml:"mail"`
+	MagicLink MagicLinkConfig `yaml:"magic_link"`
+	OIDC      OIDCConfig      `yaml:"oidc"`
+}
+
+// JWTConfig controls session tokens.
+type JWTConfig struct {
+	// iss and aud claims of every token, checked when verifying. Env:
+	// JWT_ISSUER, JWT_AUDIENCE.
+	Issuer   string `yaml:"issuer"`
+	Audience string `yaml:"audience"`
+	// PEM private key (RSA 2048+ or Ed25519) to sign with, so other services
+	// can verify tokens against /.well-known/jwks.json. Empty signs with
+	// HS256 and JWT_SECRET. Env: JWT_SIGNING_KEY_FILE.
+	SigningKeyFile string `yaml:"signing_key_file"`
+	// Keys rotated out of signing. Tokens they signed keep working, and the
+	// keys stay in the JWKS, until RetiredUntil. Env: JWT_RETIRED_KEY_FILES
+	// (comma separated).
+	RetiredKeyFiles []string `yaml:"retired_key_files"`
+	// End of the rotation grace period, at least the rotation time plus the
+	// 24h session lifetime. Env: JWT_RETIRED_UNTIL (RFC 3339).
+	RetiredUntil time.Time `yaml:"retired_until"`
+	// Until then, keep accepting sessions issued by versions without
+	// signing keys or iss/aud claims: HS256 with JWT_SECRET. Set it to the
+	// upgrade time plus 24h; left empty, upgrading signs everyone out.
+	// Env: JWT_LEGACY_UNTIL (RFC 3339).
+	LegacyUntil time.Time `yaml:"legacy_until"`
+}
+
+// RateLimitConfig throttles login and registration attempts.
+type RateLimitConfig struct {
+	// memory or postgres, postgres shares buckets between replicas.
+	// Env: RATE_LIMIT_BACKEND.
+	Backend string `yaml:"backend"`
+	// Sustained rate and burst per client IP, 0 for either turns the limit
+	// off. Env: RATE_LIMIT_IP_PER_MINUTE, RATE_LIMIT_IP_BURST.
+	IPPerMinute float64 `yaml:"ip_per_minute"`
+	IPBurst     int     `yaml:"ip_burst"`
+	// Sustained rate and burst per email address, 0 for either turns the
+	// limit off. Env: RATE_LIMIT_ACCOUNT_PER_MINUTE, RATE_LIMIT_ACCOUNT_BURST.
+	AccountPerMinute float64 `yaml:"account_per_minute"`
+	AccountBurst     int     `yaml:"account_burst"`
+	// Take the client IP from the last X-Forwarded-For entry. Only enable
+	// behind a proxy that sets it. Env: RATE_LIMIT_TRUST_PROXY.
+	TrustProxy bool `yaml:"trust_proxy"`
+}
+
+// LockoutConfig locks an account after repeated failed logins.
+type LockoutConfig struct {
+	// Failed logins in a row before the account is locked. Env: LOCKOUT_THRESHOLD.
+	Threshold int `yaml:"threshold"`
+	// First lock duration, doubled on every further failure up to Max.
+	// Env: LOCKOUT_BASE, LOCKOUT_MAX.
+	Base time.Duration `yaml:"base"`
+	Max  time.Duration `yaml:"max"`
+}
+
+// MFAConfig controls TOTP two-factor authentication.
+type MFAConfig struct {
+	// Admin endpoints refuse sessions that didn't pass a second factor.
+	// Env: MFA_REQUIRE_FOR_ADMINS.
+	RequireForAdmins bool `yaml:"require_for_admins"`
+	// Issuer shown in authenticator apps. Env: MFA_ISSUER.
+	Issuer string `yaml:"issuer"`
+	// 32 random bytes, base64 encoded, that TOTP secrets are encrypted with
+	// in the database. Changing it disables every enrolled authenticator.
+	// Env: MFA_SECRET_KEY.
+	SecretKey string `yaml:"secret_key"`
+}
+
+// Key is SecretKey decoded. Validate has already rejected anything that
+// isn't a valid key.
+func (c MFAConfig) Key() []byte {
+	key, _ := base64.StdEncoding.DecodeString(c.SecretKey)
+	return key
+}
+
+// WebAuthnConfig identifies this site to passkey authenticators. Passkeys
+// are disabled while RPID is empty.
+type WebAuthnConfig struct {
+	// Domain the passkeys are bound to, e.g. garage.example.com. It can't
+	// change later without invalidating every registered passkey.
+	// Env: WEBAUTHN_RP_ID.
+	RPID string `yaml:"rp_id"`
+	// Name shown by the browser. Env: WEBAUTHN_RP_NAME.
+	RPName string `yaml:"rp_name"`
+	// Origins the frontend is served from, e.g. https://garage.example.com.
+	// Env: WEBAUTHN_ORIGINS (comma separated).
+	Origins []string `yaml:"origins"`
+}
+
+// MailConfig picks how outgoing email is delivered. Features that send mail
+// are disabled while Backend is empty.
+type MailConfig struct {
+	// smtp, or log to print messages instead (DEV_MODE only). Env: MAIL_BACKEND.
+	Backend string `yaml:"backend"`
+	// Sender address. Env: MAIL_FROM.
+	From string     `yaml:"from"`
+	SMTP SMTPConfig `yaml:"smtp"`
+}
+
+type SMTPConfig struct {
+	Host     string `yaml:"host"`     // Env: SMTP_HOST
+	Port     string `yaml:"port"`     // Env: SMTP_PORT
+	Username string `yaml:"username"` // Env: SMTP_USERNAME
+	Password string `yaml:"password"` // Env: SMTP_PASSWORD
+}
+
+// MagicLinkConfig controls passwordless sign-in by email. It is disabled
+// while URL is empty and needs a mail backend.
+type MagicLinkConfig struct {
+	// Frontend page the emailed link opens. The token and email are added
+	// as query parameters for it to post to /api/login/magic/verify.
+	// Env: MAGIC_LINK_URL.
+	URL string `yaml:"url"`
+	// How long a link stays valid. Env: MAGIC_LINK_TTL.
+	TTL time.Duration `yaml:"ttl"`
+}
+
+// OIDCConfig configures single sign-on with OpenID Connect providers.
+// Accounts are matched to existing users by verified email on first use.
+type OIDCConfig struct {
+	// Frontend page providers send the user back to. It posts the code and
+	// state query parameters to /api/login/oidc/finish, with the cookie
+	// the begin call set, so it must be on the same site as the API.
+	// Register it with every provider. Env: OIDC_REDIRECT_URL.
+	RedirectURL string `yaml:"redirect_url"`
+	// Env: OIDC_PROVIDERS lists provider IDs (comma separated); each is then
+	// configured with OIDC_<ID>_ISSUER, OIDC_<ID>_CLIENT_ID and so on.
+	Providers []OIDCProvider `yaml:"providers"`
+}
+
+type OIDCProvider struct {
+	// Short name used in URLs, e.g. google.
+	ID string `yaml:"id"`
+	// Label for the sign-in button, defaults to ID. Env: OIDC_<ID>_NAME.
+	Name string `yaml:"name"`
+	// Env: OIDC_<ID>_ISSUER, OIDC_<ID>_CLIENT_ID, OIDC_<ID>_CLIENT_SECRET.
+	Issuer       string `yaml:"issuer"`
+	ClientID     string `yaml:"client_id"`
+	ClientSecret string `yaml:"client_secret"`
+	// Requested on top of openid, email and profile. Env: OIDC_<ID>_SCOPES
+	// (comma separated).
+	Scopes []string `yaml:"scopes"`
+	// Accept the email claim even without email_verified, for a company IdP
+	// that only issues addresses it owns. Env: OIDC_<ID>_TRUST_EMAIL.
+	TrustEmail bool `yaml:"trust_email"`
+	// Treat sign-ins as two-factor because the IdP enforces its own MFA.
+	// Env: OIDC_<ID>_MFA.
+	MFA bool `yaml:"mfa"`
+}
+
+type LogConfig struct {
+	// debug, info, warn or error. Env: LOG_LEVEL.
+	Level string `yaml:"level"`
+	// json or text. Env: LOG_FORMAT.
+	Format string `yaml:"format"`
+}
+
+// SlogLevel is Level parsed for log/slog. Validate has already rejected
+// anything unparseable.
+func (c LogConfig) SlogLevel() slog.Level {
+	var l slog.Level
+	_ = l.UnmarshalText([]byte(c.Level))
+	return l
+}
+
+type TracingConfig struct {
+	// none, stdout or otlp. Env: TRACING_EXPORTER.
+	Exporter string `yaml:"exporter"`
+	// OTLP/HTTP collector URL, e.g. http://localhost:4318. Empty uses the
+	// standard OTEL_EXPORTER_OTLP_* variables. Env: TRACING_OTLP_ENDPOINT.
+	OTLPEndpoint string `yaml:"otlp_endpoint"`
+	// Fraction of new traces to keep, 0 to 1. Env: TRACING_SAMPLE_RATIO.
+	SampleRatio float64 `yaml:"sample_ratio"`
+}
+
+type AdminConfig struct {
+	// Accounts promoted to admin at startup. Env: ADMIN_EMAILS (comma separated).
+	Emails []string `yaml:"emails"`
+	// Accounts demoted at startup. Env: ADMIN_REMOVE_EMAILS (comma separated).
+	RemoveEmails []string `yaml:"remove_emails"`
+	// Shared secret for the dev admin refresh endpoint. Env: ADMIN_UPDATE_SECRET.
+	UpdateSecret string `yaml:"update_secret"`
+}
+
+type StorageConfig struct {
+	// "local" or "s3". Env: STORAGE_BACKEND.
+	Backend string `yaml:"backend"`
+	// Root directory for the local backend. Env: STORAGE_DIR.
+	Dir string   `yaml:"dir"`
+	S3  S3Config `yaml:"s3"`
+}
+
+type S3Config struct {
+	Endpoint  string `yaml:"endpoint"`   // Env: S3_ENDPOINT
+	Region    string `yaml:"region"`     // Env: S3_REGION
+	Bucket    string `yaml:"bucket"`     // Env: S3_BUCKET
+	AccessKey string `yaml:"access_key"` // Env: S3_ACCESS_KEY
+	SecretKey string `yaml:"secret_key"` // Env: S3_SECRET_KEY
+}
+
+type HTTPConfig struct {
+	ReadHeaderTimeout time.Duration `yaml:"read_header_timeout"` // Env: HTTP_READ_HEADER_TIMEOUT
+	ReadTimeout       time.Duration `yaml:"read_timeout"`        // Env: HTTP_READ_TIMEOUT
+	WriteTimeout      time.Duration `yaml:"write_timeout"`       // Env: HTTP_WRITE_TIMEOUT
+	IdleTimeout       time.Duration `yaml:"idle_timeout"`        // Env: HTTP_IDLE_TIMEOUT
+	// How long in-flight requests get to finish on SIGTERM. Env: SHUTDOWN_TIMEOUT.
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+	// How long /readyz reports failure before the server stops accepting
+	// connections, giving load balancers time to notice. Env: SHUTDOWN_DELAY.
+	ShutdownDelay time.Duration `yaml:"shutdown_delay"`
+}
+
+// Default is the configuration before any file or environment variable is
+// applied. Load starts from it; tests building a Config by hand should too.
+func Default() *Config {
+	return &Config{
+		Port: "8080",
+		JWT: JWTConfig{
+			Issuer:   "garage",
+			Audience: "garage",
+		},
+		Storage: StorageConfig{
+			Backend: "local",
+			Dir:     "uploads",
+		},
+		HTTP: HTTPConfig{
+			ReadHeaderTimeout: 10 * time.Second,
+			ReadTimeout:       60 * time.Second,
+			WriteTimeout:      60 * time.Second,
+			IdleTimeout:       120 * time.Second,
+			ShutdownTimeout:   30 * time.Second,
+			ShutdownDelay:     5 * time.Second,
+		},
+		Log: LogConfig{
+			Level:  "info",
+			Format: "json",
+		},
+		Tracing: TracingConfig{
+			Exporter:    "none",
+			SampleRatio: 1,
+		},
+		RateLimit: RateLimitConfig{
+			Backend:          "memory",
+			IPPerMinute:      20,
+			IPBurst:          10,
+			AccountPerMinute: 5,
+			AccountBurst:     5,
+		},
+		Lockout: LockoutConfig{
+			Threshold: 5,
+			Base:      time.Minute,
+			Max:       time.Hour,
+		},
+		MFA: MFAConfig{
+			RequireForAdmins: true,
+			Issuer:           "Garage",
+		},
+		WebAuthn: WebAuthnConfig{
+			RPName: "Garage",
+		},
+		Mail: MailConfig{
+			SMTP: SMTPConfig{Port: "587"},
+		},
+		MagicLink: MagicLinkConfig{
+			TTL: 15 * time.Minute,
+		},
+	}
+}
+
+// Load builds the configuration and validates it. It is meant to be called
+// once from main; any error should stop the process.
+func Load() (*Config, error) {
+	cfg, err := read()
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// LoadDatabase is Load for commands that only use the database, such as
+// migrate and garagectl. It reads the same sources but requires nothing
+// except DATABASE_URL, so they run without the server's secrets.
+func LoadDatabase() (*Config, error) {
+	cfg, err := read()
+	if err != nil {
+		return nil, err
+	}
+	if cfg.DatabaseURL == "" {
+		return nil, errors.New("DATABASE_URL must be set")
+	}
+	return cfg, nil
+}
+
+// read layers the file and environment over Default without validating.
+func read() (*Config, error) {
+	cfg := Default()
+
+	// .env never overrides variables that are already set
+	if err := godotenv.Load(); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("reading .env: %w", err)
+	}
+
+	if path := os.Getenv("CONFIG_FILE"); path != "" {
+		if err := loadFile(cfg, path); err != nil {
+			return nil, err
+		}
+	}
+	if err := applyEnv(cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func loadFile(cfg *Config, path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading config file: %w", err)
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+	if err := dec.Decode(cfg); err != nil {
+		return fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return nil
+}
+
+func applyEnv(cfg *Config) error {
+	var errs []error
+	str := func(key string, dst *string) {
+		if v, ok := os.LookupEnv(key); ok {
+			*dst = v
+		}
+	}
+	list := func(key string, dst *[]string) {
+		if v, ok := os.LookupEnv(key); ok {
+			*dst = splitList(v)
+		}
+	}
+	boolean := func(key string, dst *bool) {
+		if v, ok := os.LookupEnv(key); ok && v != "" {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", key, err))
+				return
+			}
+			*dst = b
+		}
+	}
+	integer := func(key string, dst *int) {
+		if v, ok := os.LookupEnv(key); ok && v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", key, err))
+				return
+			}
+			*dst = n
+		}
+	}
+	float := func(key string, dst *float64) {
+		if v, ok := os.LookupEnv(key); ok && v != "" {
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", key, err))
+				return
+			}
+			*dst = f
+		}
+	}
+	duration := func(key string, dst *time.Duration) {
+		if v, ok := os.LookupEnv(key); ok && v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", key, err))
+				return
+			}
+			*dst = d
+		}
+	}
+
+	timestamp := func(key string, dst *time.Time) {
+		if v, ok := os.LookupEnv(key); ok && v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", key, err))
+				return
+			}
+			*dst = t
+		}
+	}
+
+	str("PORT", &cfg.Port)
+	str("DATABASE_URL", &cfg.DatabaseURL)
+	str("JWT_SECRET", &cfg.JWTSecret)
+	boolean("DEV_MODE", &cfg.DevMode)
+	boolean("AUTO_MIGRATE", &cfg.AutoMigrate)
+
+	str("JWT_ISSUER", &cfg.JWT.Issuer)
+	str("JWT_AUDIENCE", &cfg.JWT.Audience)
+	str("JWT_SIGNING_KEY_FILE", &cfg.JWT.SigningKeyFile)
+	list("JWT_RETIRED_KEY_FILES", &cfg.JWT.RetiredKeyFiles)
+	timestamp("JWT_RETIRED_UNTIL", &cfg.JWT.RetiredUntil)
+	timestamp("JWT_LEGACY_UNTIL", &cfg.JWT.LegacyUntil)
+
+	list("ADMIN_EMAILS", &cfg.Admin.Emails)
+	list("ADMIN_REMOVE_EMAILS", &cfg.Admin.RemoveEmails)
+	str("ADMIN_UPDATE_SECRET", &cfg.Admin.UpdateSecret)
+
+	str("STORAGE_BACKEND", &cfg.Storage.Backend)
+	str("STORAGE_DIR", &cfg.Storage.Dir)
+	str("S3_ENDPOINT", &cfg.Storage.S3.Endpoint)
+	str("S3_REGION", &cfg.Storage.S3.Region)
+	str("S3_BUCKET", &cfg.Storage.S3.Bucket)
+	str("S3_ACCESS_KEY", &cfg.Storage.S3.AccessKey)
+	str("S3_SECRET_KEY", &cfg.Storage.S3.SecretKey)
+
+	duration("HTTP_READ_HEADER_TIMEOUT", &cfg.HTTP.ReadHeaderTimeout)
+	duration("HTTP_READ_TIMEOUT", &cfg.HTTP.ReadTimeout)
+	duration("HTTP_WRITE_TIMEOUT", &cfg.HTTP.WriteTimeout)
+	duration("HTTP_IDLE_TIMEOUT", &cfg.HTTP.IdleTimeout)
+	duration("SHUTDOWN_TIMEOUT", &cfg.HTTP.ShutdownTimeout)
+	duration("SHUTDOWN_DELAY", &cfg.HTTP.ShutdownDelay)
+
+	str("LOG_LEVEL", &cfg.Log.Level)
+	str("LOG_FORMAT", &cfg.Log.Format)
+
+	str("TRACING_EXPORTER", &cfg.Tracing.Exporter)
+	str("TRACING_OTLP_ENDPOINT", &cfg.Tracing.OTLPEndpoint)
+	float("TRACING_SAMPLE_RATIO", &cfg.Tracing.SampleRatio)
+
+	str("RATE_LIMIT_BACKEND", &cfg.RateLimit.Backend)
+	float("RATE_LIMIT_IP_PER_MINUTE", &cfg.RateLimit.IPPerMinute)
+	integer("RATE_LIMIT_IP_BURST", &cfg.RateLimit.IPBurst)
+	float("RATE_LIMIT_ACCOUNT_PER_MINUTE", &cfg.RateLimit.AccountPerMinute)
+	integer("RATE_LIMIT_ACCOUNT_BURST", &cfg.RateLimit.AccountBurst)
+	boolean("RATE_LIMIT_TRUST_PROXY", &cfg.RateLimit.TrustProxy)
+
+	integer("LOCKOUT_THRESHOLD", &cfg.Lockout.Threshold)
+	duration("LOCKOUT_BASE", &cfg.Lockout.Base)
+	duration("LOCKOUT_MAX", &cfg.Lockout.Max)
+
+	boolean("MFA_REQUIRE_FOR_ADMINS", &cfg.MFA.RequireForAdmins)
+	str("MFA_ISSUER", &cfg.MFA.Issuer)
+	str("MFA_SECRET_KEY", &cfg.MFA.SecretKey)
+
+	str("WEBAUTHN_RP_ID", &cfg.WebAuthn.RPID)
+	str("WEBAUTHN_RP_NAME", &cfg.WebAuthn.RPName)
+	list("WEBAUTHN_ORIGINS", &cfg.WebAuthn.Origins)
+
+	str("MAIL_BACKEND", &cfg.Mail.Backend)
+	str("MAIL_FROM", &cfg.Mail.From)
+	str("SMTP_HOST", &cfg.Mail.SMTP.Host)
+	str("SMTP_PORT", &cfg.Mail.SMTP.Port)
+	str("SMTP_USERNAME", &cfg.Mail.SMTP.Username)
+	str("SMTP_PASSWORD", &cfg.Mail.SMTP.Password)
+
+	str("MAGIC_LINK_URL", &cfg.MagicLink.URL)
+	duration("MAGIC_LINK_TTL", &cfg.MagicLink.TTL)
+
+	str("OIDC_REDIRECT_URL", &cfg.OIDC.RedirectURL)
+	if v, ok := os.LookupEnv("OIDC_PROVIDERS"); ok {
+		cfg.OIDC.Providers = selectProviders(cfg.OIDC.Providers, splitList(v))
+	}
+	for i := range cfg.OIDC.Providers {
+		p := &cfg.OIDC.Providers[i]
+		prefix := "OIDC_" + strings.ToUpper(p.ID) + "_"
+		str(prefix+"NAME", &p.Name)
+		str(prefix+"ISSUER", &p.Issuer)
+		str(prefix+"CLIENT_ID", &p.ClientID)
+		str(prefix+"CLIENT_SECRET", &p.ClientSecret)
+		list(prefix+"SCOPES", &p.Scopes)
+		boolean(prefix+"TRUST_EMAIL", &p.TrustEmail)
+		boolean(prefix+"MFA", &p.MFA)
+	}
+
+	return errors.Join(errs...)
+}
+
+// Validate reports every invalid or missing setting at once.
+func (c *Config) Validate() error {
+	var errs []error
+	if strings.TrimSpace(c.JWTSecret) == "" {
+		errs = append(errs, errors.New("JWT_SECRET must be set"))
+	}
+	if len(c.JWT.RetiredKeyFiles) > 0 && (c.JWT.SigningKeyFile == "" || c.JWT.RetiredUntil.IsZero()) {
+		errs = append(errs, errors.New("JWT_RETIRED_KEY_FILES needs JWT_SIGNING_KEY_FILE and JWT_RETIRED_UNTIL"))
+	}
+	if c.DatabaseURL == "" {
+		errs = append(errs, errors.New("DATABASE_URL must be set"))
+	}
+	if n, err := strconv.Atoi(c.Port); err != nil || n < 1 || n > 65535 {
+		errs = append(errs, fmt.Errorf("PORT %q is not a valid port", c.Port))
+	}
+	switch c.Storage.Backend {
+	case "local":
+		if c.Storage.Dir == "" {
+			errs = append(errs, errors.New("STORAGE_DIR must be set for the local backend"))
+		}
+	case "s3":
+		if c.Storage.S3.Endpoint == "" || c.Storage.S3.Bucket == "" {
+			errs = append(errs, errors.New("S3_ENDPOINT and S3_BUCKET must be set for the s3 backend"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("STORAGE_BACKEND %q must be local or s3", c.Storage.Backend))
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.Log.Level)); err != nil {
+		errs = append(errs, fmt.Errorf("LOG_LEVEL %q must be debug, info, warn or error", c.Log.Level))
+	}
+	if c.Log.Format != "json" && c.Log.Format != "text" {
+		errs = append(errs, fmt.Errorf("LOG_FORMAT %q must be json or text", c.Log.Format))
+	}
+	switch c.Tracing.Exporter {
+	case "none", "stdout", "otlp":
+	default:
+		errs = append(errs, fmt.Errorf("TRACING_EXPORTER %q must be none, stdout or otlp", c.Tracing.Exporter))
+	}
+	if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+		errs = append(errs, fmt.Errorf("TRACING_SAMPLE_RATIO %v must be between 0 and 1", c.Tracing.SampleRatio))
+	}
+	if c.RateLimit.Backend != "memory" && c.RateLimit.Backend != "postgres" {
+		errs = append(errs, fmt.Errorf("RATE_LIMIT_BACKEND %q must be memory or postgres", c.RateLimit.Backend))
+	}
+	if c.RateLimit.IPPerMinute < 0 || c.RateLimit.IPBurst < 0 {
+		errs = append(errs, errors.New("RATE_LIMIT_IP_PER_MINUTE and RATE_LIMIT_IP_BURST must not be negative"))
+	}
+	if c.RateLimit.AccountPerMinute < 0 || c.RateLimit.AccountBurst < 0 {
+		errs = append(errs, errors.New("RATE_LIMIT_ACCOUNT_PER_MINUTE and RATE_LIMIT_ACCOUNT_BURST must not be negative"))
+	}
+	if c.Lockout.Threshold < 1 {
+		errs = append(errs, errors.New("LOCKOUT_THRESHOLD must be at least 1"))
+	}
+	if c.Lockout.Base <= 0 || c.Lockout.Max < c.Lockout.Base {
+		errs = append(errs, errors.New("LOCKOUT_BASE must be positive and no more than LOCKOUT_MAX"))
+	}
+	if c.MFA.Issuer == "" {
+		errs = append(errs, errors.New("MFA_ISSUER must not be empty"))
+	}
+	if key, err := base64.StdEncoding.DecodeString(c.MFA.SecretKey); err != nil || len(key) != 32 {
+		errs = append(errs, errors.New("MFA_SECRET_KEY must be 32 bytes, base64 encoded (openssl rand -base64 32)"))
+	}
+	if c.WebAuthn.RPID != "" && len(c.WebAuthn.Origins) == 0 {
+		errs = append(errs, errors.New("WEBAUTHN_ORIGINS must be set when WEBAUTHN_RP_ID is"))
+	}
+	switch c.Mail.Backend {
+	case "":
+	case "smtp":
+		if c.Mail.SMTP.Host == "" || c.Mail.From == "" {
+			errs = append(errs, errors.New("SMTP_HOST and MAIL_FROM must be set for the smtp mail backend"))
+		}
+	case "log":
+		if !c.DevMode {
+			errs = append(errs, errors.New("MAIL_BACKEND log is only allowed with DEV_MODE"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("MAIL_BACKEND %q must be smtp or log", c.Mail.Backend))
+	}
+	if c.MagicLink.URL != "" {
+		if u, err := url.Parse(c.MagicLink.URL); err != nil || u.Scheme == "" || u.Host == "" {
+			errs = append(errs, fmt.Errorf("MAGIC_LINK_URL %q must be an absolute URL", c.MagicLink.URL))
+		}
+		if c.Mail.Backend == "" {
+			errs = append(errs, errors.New("MAIL_BACKEND must be set when MAGIC_LINK_URL is"))
+		}
+	}
+	if c.MagicLink.TTL <= 0 {
+		errs = append(errs, errors.New("MAGIC_LINK_TTL must be positive"))
+	}
+	seen := map[string]bool{}
+	for _, p := range c.OIDC.Providers {
+		if !providerIDRe.MatchString(p.ID) || seen[p.ID] {
+			errs = append(errs, fmt.Errorf("OIDC provider ID %q must be unique, lowercase letters, digits and underscores", p.ID))
+		}
+		seen[p.ID] = true
+		if p.Issuer == "" || p.ClientID == "" {
+			errs = append(errs, fmt.Errorf("OIDC provider %q needs an issuer and client ID", p.ID))
+		}
+	}
+	if len(c.OIDC.Providers) > 0 {
+		if u, err := url.Parse(c.OIDC.RedirectURL); err != nil || u.Scheme == "" || u.Host == "" {
+			errs = append(errs, fmt.Errorf("OIDC_REDIRECT_URL %q must be an absolute URL", c.OIDC.RedirectURL))
+		}
+	}
+	if c.DevMode && c.Admin.UpdateSecret == "" {
+		errs = append(errs, errors.New("ADMIN_UPDATE_SECRET must be set when DEV_MODE is on"))
+	}
+	return errors.Join(errs...)
+}
+
+var providerIDRe = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+// selectProviders returns the providers named by ids, keeping any settings
+// the config file already gave them.
+func selectProviders(from []OIDCProvider, ids []string) []OIDCProvider {
+	out := make([]OIDCProvider, 0, len(ids))
+	for _, id := range ids {
+		p := OIDCProvider{ID: id}
+		for _, f := range from {
+			if f.ID == id {
+				p = f
+			}
+		}
+		out = append(out, p)
+	}
+	return out
+}
+
+func splitList(v string) []string {
+	var out []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
@@ -0,0 +1,57 @@
+package config
+
+import "testing"
+
+func TestDefaultNeedsOnlySecrets(t *testing.T) {
+	cfg := Default()
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("defaults validated without a database, JWT secret or MFA key")
+	}
+	cfg.DatabaseURL = "postgres://localhost/garage"
+	cfg.JWTSecret = "secret"
+	cfg.MFA.SecretKey = "AAECAwQFBgcICQoLDA0ODxAREhMUFRYXGBkaGxwdHh8="
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("defaults plus the required settings: %v", err)
+	}
+	if Default() == cfg {
+		t.Fatal("Default must return a fresh Config each time")
+	}
+}
+
+func TestMFASecretKey(t *testing.T) {
+	cfg := Default()
+	cfg.DatabaseURL = "postgres://localhost/garage"
+	cfg.JWTSecret = "secret"
+	for _, key := range []string{"", "not base64!", "c2hvcnQ="} {
+		cfg.MFA.SecretKey = key
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("MFA_SECRET_KEY %q accepted", key)
+		}
+	}
+	cfg.MFA.SecretKey = "AAECAwQFBgcICQoLDA0ODxAREhMUFRYXGBkaGxwdHh8="
+	if key := cfg.MFA.Key(); len(key) != 32 || key[31] != 31 {
+		t.Fatalf("Key() = %v", key)
+	}
+}
+
+func TestLoadDatabaseNeedsNoSecrets(t *testing.T) {
+	t.Setenv("CONFIG_FILE", "")
+	t.Setenv("JWT_SECRET", "")
+	t.Setenv("MFA_SECRET_KEY", "")
+	t.Setenv("DATABASE_URL", "")
+	if _, err := LoadDatabase(); err == nil {
+		t.Fatal("loaded without DATABASE_URL")
+	}
+	t.Setenv("DATABASE_URL", "postgres://localhost/garage")
+	t.Setenv("DEV_MODE", "true")
+	cfg, err := LoadDatabase()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.DatabaseURL != "postgres://localhost/garage" || !cfg.DevMode {
+		t.Fatalf("loaded %+v", cfg)
+	}
+	if _, err := Load(); err == nil {
+		t.Fatal("Load accepted a config without secrets")
+	}
+}
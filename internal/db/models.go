@@ -19,14 +19,115 @@ type Appointment struct {
 	Description sql.NullString
 	Status      string
 	CreatedAt   time.Time
+	Version     int32
 }
 
-type User struct {
-	ID           uuid.UUID
-	Name         string
-	Email        string
-	PasswordHash string
-	Phone        string
-	IsAdmin      sql.NullBool
+type Attachment struct {
+	ID            uuid.UUID
+	AppointmentID uuid.UUID
+	UploaderID    uuid.NullUUID
+	Filename      string
+	ContentType   string
+	SizeBytes     int64
+	StorageKey    string
+	ThumbnailKey  sql.NullString
+	CreatedAt     time.Time
+}
+
+type IdempotencyKey struct {
+	Key          string
+	UserID       string
+	RequestHash  string
+	StatusCode   sql.NullInt32
+	ContentType  sql.NullString
+	ResponseBody []byte
 	CreatedAt    time.Time
 }
+
+type MagicLinkToken struct {
+	TokenHash string
+	UserID    uuid.UUID
+	Email     string
+	ExpiresAt time.Time
+	UsedAt    sql.NullTime
+	CreatedAt time.Time
+}
+
+type MfaRecoveryCode struct {
+	ID        uuid.UUID
+	UserID    uuid.UUID
+	CodeHash  string
+	UsedAt    sql.NullTime
+	CreatedAt time.Time
+}
+
+type Message struct {
+	ID            uuid.UUID
+	AppointmentID uuid.UUID
+	SenderID      uuid.NullUUID
+	SenderIsStaff bool
+	Body          string
+	ReadAt        sql.NullTime
+	CreatedAt     time.Time
+}
+
+type OidcState struct {
+	State        string
+	Provider     string
+	Nonce        string
+	CodeVerifier string
+	ExpiresAt    time.Time
+}
+
+type RateLimitBucket struct {
+	Key       string
+	Tokens    float64
+	UpdatedAt time.Time
+}
+
+type User struct {
+	ID            uuid.UUID
+	Name          string
+	Email         string
+	PasswordHash  string
+	Phone         string
+	IsAdmin       sql.NullBool
+	CreatedAt     time.Time
+	DisabledAt    sql.NullTime
+	FailedLogins  int32
+	LockedUntil   sql.NullTime
+	TotpSecret    sql.NullString
+	TotpEnabledAt sql.NullTime
+	TotpLastStep  sql.NullInt64
+}
+
+type UserIdentity struct {
+	Provider    string
+	Subject     string
+	UserID      uuid.UUID
+	Email       string
+	CreatedAt   time.Time
+	LastLoginAt sql.NullTime
+}
+
+type WebauthnChallenge struct {
+	Challenge string
+	UserID    uuid.NullUUID
+	Session   []byte
+	ExpiresAt time.Time
+}
+
+type WebauthnCredential struct {
+	ID              uuid.UUID
+	UserID          uuid.UUID
+	CredentialID    []byte
+	PublicKey       []byte
+	AttestationType string
+	Transports      string
+	Aaguid          []byte
+	SignCount       int64
+	BackupEligible  bool
+	BackupState     bool
+	CreatedAt       time.Time
+	LastUsedAt      sql.NullTime
+}
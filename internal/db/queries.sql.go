@@ -13,10 +13,88 @@ import (
 	"github.com/google/uuid"
 )
 
+const claimIdempotencyKey = `-- name: ClaimIdempotencyKey :execrows
+INSERT INTO idempotency_keys (key, user_id, request_hash)
+VALUES ($1, $2, $3)
+ON CONFLICT (key, user_id) DO UPDATE
+SET request_hash = EXCLUDED.request_hash, status_code = NULL, content_type = NULL, response_body = NULL, created_at = now()
+WHERE idempotency_keys.created_at <= now() - interval '24 hours'
+`
+
+type ClaimIdempotencyKeyParams struct {
+	Key         string
+	UserID      string
+	RequestHash string
+}
+
+func (q *Queries) ClaimIdempotencyKey(ctx context.Context, arg ClaimIdempotencyKeyParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, claimIdempotencyKey, arg.Key, arg.UserID, arg.RequestHash)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const completeIdempotencyKey = `-- name: CompleteIdempotencyKey :exec
+UPDATE idempotency_keys SET status_code = $3, content_type = $4, response_body = $5
+WHERE key = $1 AND user_id = $2
+`
+
+type CompleteIdempotencyKeyParams struct {
+	Key          string
+	UserID       string
+	StatusCode   sql.NullInt32
+	ContentType  sql.NullString
+	ResponseBody []byte
+}
+
+func (q *Queries) CompleteIdempotencyKey(ctx context.Context, arg CompleteIdempotencyKeyParams) error {
+	_, err := q.db.ExecContext(ctx, completeIdempotencyKey,
+		arg.Key,
+		arg.UserID,
+		arg.StatusCode,
+		arg.ContentType,
+		arg.ResponseBody,
+	)
+	return err
+}
+
+const countAppointmentsByStatus = `-- name: CountAppointmentsByStatus :many
+SELECT status, COUNT(*) AS count FROM appointments GROUP BY status
+`
+
+type CountAppointmentsByStatusRow struct {
+	Status string
+	Count  int64
+}
+
+func (q *Queries) CountAppointmentsByStatus(ctx context.Context) ([]CountAppointmentsByStatusRow, error) {
+	rows, err := q.db.QueryContext(ctx, countAppointmentsByStatus)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []CountAppointmentsByStatusRow
+	for rows.Next() {
+		var i CountAppointmentsByStatusRow
+		if err := rows.Scan(&i.Status, &i.Count); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const createAppointment = `-- name: CreateAppointment :one
 INSERT INTO appointments (id, user_id, datetime, title, description)
 VALUES ($1, $2, $3, $5, $4)
-RETURNING id, user_id, datetime, title, description, status, created_at
+RETURNING id, user_id, datetime, title, description, status, created_at, version
 `
 
 type CreateAppointmentParams struct {
@@ -44,14 +122,152 @@ func (q *Queries) CreateAppointment(ctx context.Context, arg CreateAppointmentPa
 		&i.Description,
 		&i.Status,
 		&i.CreatedAt,
+		&i.Version,
+	)
+	return i, err
+}
+
+const createAttachment = `-- name: CreateAttachment :one
+INSERT INTO attachments (id, appointment_id, uploader_id, filename, content_type, size_bytes, storage_key, thumbnail_key)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+RETURNING id, appointment_id, uploader_id, filename, content_type, size_bytes, storage_key, thumbnail_key, created_at
+`
+
+type CreateAttachmentParams struct {
+	ID            uuid.UUID
+	AppointmentID uuid.UUID
+	UploaderID    uuid.NullUUID
+	Filename      string
+	ContentType   string
+	SizeBytes     int64
+	StorageKey    string
+	ThumbnailKey  sql.NullString
+}
+
+func (q *Queries) CreateAttachment(ctx context.Context, arg CreateAttachmentParams) (Attachment, error) {
+	row := q.db.QueryRowContext(ctx, createAttachment,
+		arg.ID,
+		arg.AppointmentID,
+		arg.UploaderID,
+		arg.Filename,
+		arg.ContentType,
+		arg.SizeBytes,
+		arg.StorageKey,
+		arg.ThumbnailKey,
+	)
+	var i Attachment
+	err := row.Scan(
+		&i.ID,
+		&i.AppointmentID,
+		&i.UploaderID,
+		&i.Filename,
+		&i.ContentType,
+		&i.SizeBytes,
+		&i.StorageKey,
+		&i.ThumbnailKey,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const createMagicLinkToken = `-- name: CreateMagicLinkToken :exec
+INSERT INTO magic_link_tokens (token_hash, user_id, email, expires_at) VALUES ($1, $2, $3, $4)
+`
+
+type CreateMagicLinkTokenParams struct {
+	TokenHash string
+	UserID    uuid.UUID
+	Email     string
+	ExpiresAt time.Time
+}
+
+func (q *Queries) CreateMagicLinkToken(ctx context.Context, arg CreateMagicLinkTokenParams) error {
+	_, err := q.db.ExecContext(ctx, createMagicLinkToken,
+		arg.TokenHash,
+		arg.UserID,
+		arg.Email,
+		arg.ExpiresAt,
+	)
+	return err
+}
+
+const createMessage = `-- name: CreateMessage :one
+INSERT INTO messages (id, appointment_id, sender_id, sender_is_staff, body)
+VALUES ($1, $2, $3, $4, $5)
+RETURNING id, appointment_id, sender_id, sender_is_staff, body, read_at, created_at
+`
+
+type CreateMessageParams struct {
+	ID            uuid.UUID
+	AppointmentID uuid.UUID
+	SenderID      uuid.NullUUID
+	SenderIsStaff bool
+	Body          string
+}
+
+func (q *Queries) CreateMessage(ctx context.Context, arg CreateMessageParams) (Message, error) {
+	row := q.db.QueryRowContext(ctx, createMessage,
+		arg.ID,
+		arg.AppointmentID,
+		arg.SenderID,
+		arg.SenderIsStaff,
+		arg.Body,
+	)
+	var i Message
+	err := row.Scan(
+		&i.ID,
+		&i.AppointmentID,
+		&i.SenderID,
+		&i.SenderIsStaff,
+		&i.Body,
+		&i.ReadAt,
+		&i.CreatedAt,
 	)
 	return i, err
 }
 
+const createOIDCState = `-- name: CreateOIDCState :exec
+INSERT INTO oidc_states (state, provider, nonce, code_verifier, expires_at) VALUES ($1, $2, $3, $4, $5)
+`
+
+type CreateOIDCStateParams struct {
+	State        string
+	Provider     string
+	Nonce        string
+	CodeVerifier string
+	ExpiresAt    time.Time
+}
+
+func (q *Queries) CreateOIDCState(ctx context.Context, arg CreateOIDCStateParams) error {
+	_, err := q.db.ExecContext(ctx, createOIDCState,
+		arg.State,
+		arg.Provider,
+		arg.Nonce,
+		arg.CodeVerifier,
+		arg.ExpiresAt,
+	)
+	return err
+}
+
+const createRecoveryCode = `-- name: CreateRecoveryCode :exec
+INSERT INTO mfa_recovery_codes (id, user_id, code_hash) VALUES ($1, $2, $3)
+`
+
+type CreateRecoveryCodeParams struct {
+	ID       uuid.UUID
+	UserID   uuid.UUID
+	CodeHash string
+}
+
+func (q *Queries) CreateRecoveryCode(ctx context.Context, arg CreateRecoveryCodeParams) error {
+	_, err := q.db.ExecContext(ctx, createRecoveryCode, arg.ID, arg.UserID, arg.CodeHash)
+	return err
+}
+
 const createUser = `-- name: CreateUser :one
 INSERT INTO users (id, name, email, password_hash, phone, is_admin)
 VALUES ($1, $2, $3, $4, $5, $6)
-RETURNING id, name, email, password_hash, phone, is_admin, created_at
+RETURNING id, name, email, password_hash, phone, is_admin, created_at, disabled_at, failed_logins, locked_until, totp_secret, totp_enabled_at, totp_last_step
 `
 
 type CreateUserParams struct {
@@ -81,6 +297,105 @@ func (q *Queries) CreateUser(ctx context.Context, arg CreateUserParams) (User, e
 		&i.Phone,
 		&i.IsAdmin,
 		&i.CreatedAt,
+		&i.DisabledAt,
+		&i.FailedLogins,
+		&i.LockedUntil,
+		&i.TotpSecret,
+		&i.TotpEnabledAt,
+		&i.TotpLastStep,
+	)
+	return i, err
+}
+
+const createUserIdentity = `-- name: CreateUserIdentity :exec
+INSERT INTO user_identities (provider, subject, user_id, email) VALUES ($1, $2, $3, $4)
+ON CONFLICT (provider, subject) DO NOTHING
+`
+
+type CreateUserIdentityParams struct {
+	Provider string
+	Subject  string
+	UserID   uuid.UUID
+	Email    string
+}
+
+func (q *Queries) CreateUserIdentity(ctx context.Context, arg CreateUserIdentityParams) error {
+	_, err := q.db.ExecContext(ctx, createUserIdentity,
+		arg.Provider,
+		arg.Subject,
+		arg.UserID,
+		arg.Email,
+	)
+	return err
+}
+
+const createWebAuthnChallenge = `-- name: CreateWebAuthnChallenge :exec
+INSERT INTO webauthn_challenges (challenge, user_id, session, expires_at) VALUES ($1, $2, $3, $4)
+`
+
+type CreateWebAuthnChallengeParams struct {
+	Challenge string
+	UserID    uuid.NullUUID
+	Session   []byte
+	ExpiresAt time.Time
+}
+
+func (q *Queries) CreateWebAuthnChallenge(ctx context.Context, arg CreateWebAuthnChallengeParams) error {
+	_, err := q.db.ExecContext(ctx, createWebAuthnChallenge,
+		arg.Challenge,
+		arg.UserID,
+		arg.Session,
+		arg.ExpiresAt,
+	)
+	return err
+}
+
+const createWebAuthnCredential = `-- name: CreateWebAuthnCredential :one
+INSERT INTO webauthn_credentials (id, user_id, credential_id, public_key, attestation_type, transports, aaguid, sign_count, backup_eligible, backup_state)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+RETURNING id, user_id, credential_id, public_key, attestation_type, transports, aaguid, sign_count, backup_eligible, backup_state, created_at, last_used_at
+`
+
+type CreateWebAuthnCredentialParams struct {
+	ID              uuid.UUID
+	UserID          uuid.UUID
+	CredentialID    []byte
+	PublicKey       []byte
+	AttestationType string
+	Transports      string
+	Aaguid          []byte
+	SignCount       int64
+	BackupEligible  bool
+	BackupState     bool
+}
+
+func (q *Queries) CreateWebAuthnCredential(ctx context.Context, arg CreateWebAuthnCredentialParams) (WebauthnCredential, error) {
+	row := q.db.QueryRowContext(ctx, createWebAuthnCredential,
+		arg.ID,
+		arg.UserID,
+		arg.CredentialID,
+		arg.PublicKey,
+		arg.AttestationType,
+		arg.Transports,
+		arg.Aaguid,
+		arg.SignCount,
+		arg.BackupEligible,
+		arg.BackupState,
+	)
+	var i WebauthnCredential
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.CredentialID,
+		&i.PublicKey,
+		&i.AttestationType,
+		&i.Transports,
+		&i.Aaguid,
+		&i.SignCount,
+		&i.BackupEligible,
+		&i.BackupState,
+		&i.CreatedAt,
+		&i.LastUsedAt,
 	)
 	return i, err
 }
@@ -94,6 +409,161 @@ func (q *Queries) DeleteAppointment(ctx context.Context, id uuid.UUID) error {
 	return err
 }
 
+const deleteExpiredIdempotencyKeys = `-- name: DeleteExpiredIdempotencyKeys :exec
+DELETE FROM idempotency_keys WHERE created_at <= now() - interval '24 hours'
+`
+
+func (q *Queries) DeleteExpiredIdempotencyKeys(ctx context.Context) error {
+	_, err := q.db.ExecContext(ctx, deleteExpiredIdempotencyKeys)
+	return err
+}
+
+const deleteExpiredMagicLinkTokens = `-- name: DeleteExpiredMagicLinkTokens :exec
+DELETE FROM magic_link_tokens WHERE expires_at < $1
+`
+
+func (q *Queries) DeleteExpiredMagicLinkTokens(ctx context.Context, expiresAt time.Time) error {
+	_, err := q.db.ExecContext(ctx, deleteExpiredMagicLinkTokens, expiresAt)
+	return err
+}
+
+const deleteExpiredOIDCStates = `-- name: DeleteExpiredOIDCStates :exec
+DELETE FROM oidc_states WHERE expires_at < $1
+`
+
+func (q *Queries) DeleteExpiredOIDCStates(ctx context.Context, expiresAt time.Time) error {
+	_, err := q.db.ExecContext(ctx, deleteExpiredOIDCStates, expiresAt)
+	return err
+}
+
+const deleteExpiredWebAuthnChallenges = `-- name: DeleteExpiredWebAuthnChallenges :exec
+DELETE FROM webauthn_challenges WHERE expires_at < $1
+`
+
+func (q *Queries) DeleteExpiredWebAuthnChallenges(ctx context.Context, expiresAt time.Time) error {
+	_, err := q.db.ExecContext(ctx, deleteExpiredWebAuthnChallenges, expiresAt)
+	return err
+}
+
+const deleteIdempotencyKey = `-- name: DeleteIdempotencyKey :exec
+DELETE FROM idempotency_keys WHERE key = $1 AND user_id = $2
+`
+
+type DeleteIdempotencyKeyParams struct {
+	Key    string
+	UserID string
+}
+
+func (q *Queries) DeleteIdempotencyKey(ctx context.Context, arg DeleteIdempotencyKeyParams) error {
+	_, err := q.db.ExecContext(ctx, deleteIdempotencyKey, arg.Key, arg.UserID)
+	return err
+}
+
+const deleteRecoveryCodes = `-- name: DeleteRecoveryCodes :exec
+DELETE FROM mfa_recovery_codes WHERE user_id = $1
+`
+
+func (q *Queries) DeleteRecoveryCodes(ctx context.Context, userID uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, deleteRecoveryCodes, userID)
+	return err
+}
+
+const deleteStaleRateLimitBuckets = `-- name: DeleteStaleRateLimitBuckets :exec
+DELETE FROM rate_limit_buckets WHERE updated_at < $1
+`
+
+func (q *Queries) DeleteStaleRateLimitBuckets(ctx context.Context, updatedAt time.Time) error {
+	_, err := q.db.ExecContext(ctx, deleteStaleRateLimitBuckets, updatedAt)
+	return err
+}
+
+const deleteWebAuthnCredential = `-- name: DeleteWebAuthnCredential :execrows
+DELETE FROM webauthn_credentials WHERE id = $1 AND user_id = $2
+`
+
+type DeleteWebAuthnCredentialParams struct {
+	ID     uuid.UUID
+	UserID uuid.UUID
+}
+
+func (q *Queries) DeleteWebAuthnCredential(ctx context.Context, arg DeleteWebAuthnCredentialParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteWebAuthnCredential, arg.ID, arg.UserID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const disableTOTP = `-- name: DisableTOTP :execrows
+UPDATE users SET totp_secret = NULL, totp_enabled_at = NULL, totp_last_step = NULL
+WHERE email = $1
+`
+
+func (q *Queries) DisableTOTP(ctx context.Context, email string) (int64, error) {
+	result, err := q.db.ExecContext(ctx, disableTOTP, email)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const enableTOTP = `-- name: EnableTOTP :exec
+UPDATE users SET totp_enabled_at = now(), totp_last_step = $2 WHERE id = $1
+`
+
+type EnableTOTPParams struct {
+	ID           uuid.UUID
+	TotpLastStep sql.NullInt64
+}
+
+func (q *Queries) EnableTOTP(ctx context.Context, arg EnableTOTPParams) error {
+	_, err := q.db.ExecContext(ctx, enableTOTP, arg.ID, arg.TotpLastStep)
+	return err
+}
+
+const ensureRateLimitBucket = `-- name: EnsureRateLimitBucket :exec
+INSERT INTO rate_limit_buckets (key, tokens, updated_at) VALUES ($1, $2, $3)
+ON CONFLICT (key) DO NOTHING
+`
+
+type EnsureRateLimitBucketParams struct {
+	Key       string
+	Tokens    float64
+	UpdatedAt time.Time
+}
+
+func (q *Queries) EnsureRateLimitBucket(ctx context.Context, arg EnsureRateLimitBucketParams) error {
+	_, err := q.db.ExecContext(ctx, ensureRateLimitBucket, arg.Key, arg.Tokens, arg.UpdatedAt)
+	return err
+}
+
+const getAdminUserIDs = `-- name: GetAdminUserIDs :many
+SELECT id FROM users WHERE is_admin = TRUE
+`
+
+func (q *Queries) GetAdminUserIDs(ctx context.Context) ([]uuid.UUID, error) {
+	rows, err := q.db.QueryContext(ctx, getAdminUserIDs)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		items = append(items, id)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const getAllAppointments = `-- name: GetAllAppointments :many
 SELECT
   a.id,
@@ -103,6 +573,7 @@ SELECT
   a.description,
   a.status,
   a.created_at,
+  a.version,
   u.name AS user_name,
   u.email AS user_email,
   u.phone AS user_phone
@@ -119,6 +590,7 @@ type GetAllAppointmentsRow struct {
 	Description sql.NullString
 	Status      string
 	CreatedAt   time.Time
+	Version     int32
 	UserName    string
 	UserEmail   string
 	UserPhone   string
@@ -141,6 +613,7 @@ func (q *Queries) GetAllAppointments(ctx context.Context) ([]GetAllAppointmentsR
 			&i.Description,
 			&i.Status,
 			&i.CreatedAt,
+			&i.Version,
 			&i.UserName,
 			&i.UserEmail,
 			&i.UserPhone,
@@ -159,7 +632,7 @@ func (q *Queries) GetAllAppointments(ctx context.Context) ([]GetAllAppointmentsR
 }
 
 const getAppointmentsByID = `-- name: GetAppointmentsByID :one
-SELECT id, user_id, datetime, title, description, status, created_at
+SELECT id, user_id, datetime, title, description, status, created_at, version
 FROM appointments
 WHERE id = $1
 `
@@ -175,23 +648,58 @@ func (q *Queries) GetAppointmentsByID(ctx context.Context, id uuid.UUID) (Appoin
 		&i.Description,
 		&i.Status,
 		&i.CreatedAt,
+		&i.Version,
 	)
 	return i, err
 }
 
-const getAppointmentsForUser = `-- name: GetAppointmentsForUser :many
-SELECT id, user_id, datetime, title, description, status, created_at FROM appointments WHERE user_id = $1 ORDER BY created_at DESC
+const getAppointmentsForDay = `-- name: GetAppointmentsForDay :many
+SELECT
+  a.id,
+  a.user_id,
+  a.datetime,
+  a.title,
+  a.description,
+  a.status,
+  a.created_at,
+  a.version,
+  u.name AS user_name,
+  u.email AS user_email,
+  u.phone AS user_phone
+FROM appointments a
+JOIN users u ON a.user_id = u.id
+WHERE a.datetime >= $1 AND a.datetime < $2
+ORDER BY a.datetime ASC
 `
 
-func (q *Queries) GetAppointmentsForUser(ctx context.Context, userID uuid.NullUUID) ([]Appointment, error) {
-	rows, err := q.db.QueryContext(ctx, getAppointmentsForUser, userID)
+type GetAppointmentsForDayParams struct {
+	DayStart time.Time
+	DayEnd   time.Time
+}
+
+type GetAppointmentsForDayRow struct {
+	ID          uuid.UUID
+	UserID      uuid.NullUUID
+	Datetime    time.Time
+	Title       string
+	Description sql.NullString
+	Status      string
+	CreatedAt   time.Time
+	Version     int32
+	UserName    string
+	UserEmail   string
+	UserPhone   string
+}
+
+func (q *Queries) GetAppointmentsForDay(ctx context.Context, arg GetAppointmentsForDayParams) ([]GetAppointmentsForDayRow, error) {
+	rows, err := q.db.QueryContext(ctx, getAppointmentsForDay, arg.DayStart, arg.DayEnd)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	var items []Appointment
+	var items []GetAppointmentsForDayRow
 	for rows.Next() {
-		var i Appointment
+		var i GetAppointmentsForDayRow
 		if err := rows.Scan(
 			&i.ID,
 			&i.UserID,
@@ -200,6 +708,10 @@ func (q *Queries) GetAppointmentsForUser(ctx context.Context, userID uuid.NullUU
 			&i.Description,
 			&i.Status,
 			&i.CreatedAt,
+			&i.Version,
+			&i.UserName,
+			&i.UserEmail,
+			&i.UserPhone,
 		); err != nil {
 			return nil, err
 		}
@@ -214,27 +726,245 @@ func (q *Queries) GetAppointmentsForUser(ctx context.Context, userID uuid.NullUU
 	return items, nil
 }
 
-const getUserByEmail = `-- name: GetUserByEmail :one
-SELECT id, name, email, password_hash, phone, is_admin, created_at FROM users WHERE email = $1
+const getAppointmentsForUser = `-- name: GetAppointmentsForUser :many
+SELECT id, user_id, datetime, title, description, status, created_at, version FROM appointments WHERE user_id = $1 ORDER BY created_at DESC
 `
 
-func (q *Queries) GetUserByEmail(ctx context.Context, email string) (User, error) {
-	row := q.db.QueryRowContext(ctx, getUserByEmail, email)
-	var i User
-	err := row.Scan(
-		&i.ID,
-		&i.Name,
-		&i.Email,
-		&i.PasswordHash,
+func (q *Queries) GetAppointmentsForUser(ctx context.Context, userID uuid.NullUUID) ([]Appointment, error) {
+	rows, err := q.db.QueryContext(ctx, getAppointmentsForUser, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Appointment
+	for rows.Next() {
+		var i Appointment
+		if err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.Datetime,
+			&i.Title,
+			&i.Description,
+			&i.Status,
+			&i.CreatedAt,
+			&i.Version,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getAttachmentByID = `-- name: GetAttachmentByID :one
+SELECT id, appointment_id, uploader_id, filename, content_type, size_bytes, storage_key, thumbnail_key, created_at FROM attachments WHERE id = $1
+`
+
+func (q *Queries) GetAttachmentByID(ctx context.Context, id uuid.UUID) (Attachment, error) {
+	row := q.db.QueryRowContext(ctx, getAttachmentByID, id)
+	var i Attachment
+	err := row.Scan(
+		&i.ID,
+		&i.AppointmentID,
+		&i.UploaderID,
+		&i.Filename,
+		&i.ContentType,
+		&i.SizeBytes,
+		&i.StorageKey,
+		&i.ThumbnailKey,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const getAttachmentsForAppointment = `-- name: GetAttachmentsForAppointment :many
+SELECT id, appointment_id, uploader_id, filename, content_type, size_bytes, storage_key, thumbnail_key, created_at FROM attachments WHERE appointment_id = $1 ORDER BY created_at ASC
+`
+
+func (q *Queries) GetAttachmentsForAppointment(ctx context.Context, appointmentID uuid.UUID) ([]Attachment, error) {
+	rows, err := q.db.QueryContext(ctx, getAttachmentsForAppointment, appointmentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Attachment
+	for rows.Next() {
+		var i Attachment
+		if err := rows.Scan(
+			&i.ID,
+			&i.AppointmentID,
+			&i.UploaderID,
+			&i.Filename,
+			&i.ContentType,
+			&i.SizeBytes,
+			&i.StorageKey,
+			&i.ThumbnailKey,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getIdempotencyKey = `-- name: GetIdempotencyKey :one
+SELECT key, user_id, request_hash, status_code, content_type, response_body, created_at FROM idempotency_keys
+WHERE key = $1 AND user_id = $2 AND created_at > now() - interval '24 hours'
+`
+
+type GetIdempotencyKeyParams struct {
+	Key    string
+	UserID string
+}
+
+func (q *Queries) GetIdempotencyKey(ctx context.Context, arg GetIdempotencyKeyParams) (IdempotencyKey, error) {
+	row := q.db.QueryRowContext(ctx, getIdempotencyKey, arg.Key, arg.UserID)
+	var i IdempotencyKey
+	err := row.Scan(
+		&i.Key,
+		&i.UserID,
+		&i.RequestHash,
+		&i.StatusCode,
+		&i.ContentType,
+		&i.ResponseBody,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const getLockedUsers = `-- name: GetLockedUsers :many
+SELECT id, name, email, failed_logins, locked_until FROM users
+WHERE locked_until > $1
+ORDER BY locked_until DESC
+`
+
+type GetLockedUsersRow struct {
+	ID           uuid.UUID
+	Name         string
+	Email        string
+	FailedLogins int32
+	LockedUntil  sql.NullTime
+}
+
+func (q *Queries) GetLockedUsers(ctx context.Context, lockedUntil sql.NullTime) ([]GetLockedUsersRow, error) {
+	rows, err := q.db.QueryContext(ctx, getLockedUsers, lockedUntil)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetLockedUsersRow
+	for rows.Next() {
+		var i GetLockedUsersRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.Email,
+			&i.FailedLogins,
+			&i.LockedUntil,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getMessagesForAppointment = `-- name: GetMessagesForAppointment :many
+SELECT id, appointment_id, sender_id, sender_is_staff, body, read_at, created_at FROM messages WHERE appointment_id = $1 ORDER BY created_at ASC
+`
+
+func (q *Queries) GetMessagesForAppointment(ctx context.Context, appointmentID uuid.UUID) ([]Message, error) {
+	rows, err := q.db.QueryContext(ctx, getMessagesForAppointment, appointmentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Message
+	for rows.Next() {
+		var i Message
+		if err := rows.Scan(
+			&i.ID,
+			&i.AppointmentID,
+			&i.SenderID,
+			&i.SenderIsStaff,
+			&i.Body,
+			&i.ReadAt,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getRateLimitBucketForUpdate = `-- name: GetRateLimitBucketForUpdate :one
+SELECT key, tokens, updated_at FROM rate_limit_buckets WHERE key = $1 FOR UPDATE
+`
+
+func (q *Queries) GetRateLimitBucketForUpdate(ctx context.Context, key string) (RateLimitBucket, error) {
+	row := q.db.QueryRowContext(ctx, getRateLimitBucketForUpdate, key)
+	var i RateLimitBucket
+	err := row.Scan(
+		&i.Key,
+		&i.Tokens,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const getUserByEmail = `-- name: GetUserByEmail :one
+SELECT id, name, email, password_hash, phone, is_admin, created_at, disabled_at, failed_logins, locked_until, totp_secret, totp_enabled_at, totp_last_step FROM users WHERE email = $1
+`
+
+func (q *Queries) GetUserByEmail(ctx context.Context, email string) (User, error) {
+	row := q.db.QueryRowContext(ctx, getUserByEmail, email)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Email,
+		&i.PasswordHash,
 		&i.Phone,
 		&i.IsAdmin,
 		&i.CreatedAt,
+		&i.DisabledAt,
+		&i.FailedLogins,
+		&i.LockedUntil,
+		&i.TotpSecret,
+		&i.TotpEnabledAt,
+		&i.TotpLastStep,
 	)
 	return i, err
 }
 
 const getUserByID = `-- name: GetUserByID :one
-SELECT id, name, email, password_hash, phone, is_admin, created_at FROM users WHERE id = $1
+SELECT id, name, email, password_hash, phone, is_admin, created_at, disabled_at, failed_logins, locked_until, totp_secret, totp_enabled_at, totp_last_step FROM users WHERE id = $1
 `
 
 func (q *Queries) GetUserByID(ctx context.Context, id uuid.UUID) (User, error) {
@@ -248,11 +978,133 @@ func (q *Queries) GetUserByID(ctx context.Context, id uuid.UUID) (User, error) {
 		&i.Phone,
 		&i.IsAdmin,
 		&i.CreatedAt,
+		&i.DisabledAt,
+		&i.FailedLogins,
+		&i.LockedUntil,
+		&i.TotpSecret,
+		&i.TotpEnabledAt,
+		&i.TotpLastStep,
+	)
+	return i, err
+}
+
+const getUserIdentity = `-- name: GetUserIdentity :one
+SELECT provider, subject, user_id, email, created_at, last_login_at FROM user_identities WHERE provider = $1 AND subject = $2
+`
+
+type GetUserIdentityParams struct {
+	Provider string
+	Subject  string
+}
+
+func (q *Queries) GetUserIdentity(ctx context.Context, arg GetUserIdentityParams) (UserIdentity, error) {
+	row := q.db.QueryRowContext(ctx, getUserIdentity, arg.Provider, arg.Subject)
+	var i UserIdentity
+	err := row.Scan(
+		&i.Provider,
+		&i.Subject,
+		&i.UserID,
+		&i.Email,
+		&i.CreatedAt,
+		&i.LastLoginAt,
 	)
 	return i, err
 }
 
-const setAdmin = `-- name: SetAdmin :exec
+const getWebAuthnCredentialsForUser = `-- name: GetWebAuthnCredentialsForUser :many
+SELECT id, user_id, credential_id, public_key, attestation_type, transports, aaguid, sign_count, backup_eligible, backup_state, created_at, last_used_at FROM webauthn_credentials WHERE user_id = $1 ORDER BY created_at
+`
+
+func (q *Queries) GetWebAuthnCredentialsForUser(ctx context.Context, userID uuid.UUID) ([]WebauthnCredential, error) {
+	rows, err := q.db.QueryContext(ctx, getWebAuthnCredentialsForUser, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []WebauthnCredential
+	for rows.Next() {
+		var i WebauthnCredential
+		if err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.CredentialID,
+			&i.PublicKey,
+			&i.AttestationType,
+			&i.Transports,
+			&i.Aaguid,
+			&i.SignCount,
+			&i.BackupEligible,
+			&i.BackupState,
+			&i.CreatedAt,
+			&i.LastUsedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const lockUser = `-- name: LockUser :exec
+UPDATE users SET locked_until = $2 WHERE id = $1
+`
+
+type LockUserParams struct {
+	ID          uuid.UUID
+	LockedUntil sql.NullTime
+}
+
+func (q *Queries) LockUser(ctx context.Context, arg LockUserParams) error {
+	_, err := q.db.ExecContext(ctx, lockUser, arg.ID, arg.LockedUntil)
+	return err
+}
+
+const markMessagesRead = `-- name: MarkMessagesRead :execrows
+UPDATE messages SET read_at = now()
+WHERE appointment_id = $1 AND sender_is_staff = $2 AND read_at IS NULL
+`
+
+type MarkMessagesReadParams struct {
+	AppointmentID uuid.UUID
+	SenderIsStaff bool
+}
+
+func (q *Queries) MarkMessagesRead(ctx context.Context, arg MarkMessagesReadParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, markMessagesRead, arg.AppointmentID, arg.SenderIsStaff)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const recordFailedLogin = `-- name: RecordFailedLogin :one
+UPDATE users SET failed_logins = failed_logins + 1 WHERE id = $1
+RETURNING failed_logins
+`
+
+func (q *Queries) RecordFailedLogin(ctx context.Context, id uuid.UUID) (int32, error) {
+	row := q.db.QueryRowContext(ctx, recordFailedLogin, id)
+	var failed_logins int32
+	err := row.Scan(&failed_logins)
+	return failed_logins, err
+}
+
+const resetFailedLogins = `-- name: ResetFailedLogins :exec
+UPDATE users SET failed_logins = 0, locked_until = NULL WHERE id = $1
+`
+
+func (q *Queries) ResetFailedLogins(ctx context.Context, id uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, resetFailedLogins, id)
+	return err
+}
+
+const setAdmin = `-- name: SetAdmin :execrows
 UPDATE users SET is_admin = $2 WHERE email = $1
 `
 
@@ -261,27 +1113,218 @@ type SetAdminParams struct {
 	IsAdmin sql.NullBool
 }
 
-func (q *Queries) SetAdmin(ctx context.Context, arg SetAdminParams) error {
-	_, err := q.db.ExecContext(ctx, setAdmin, arg.Email, arg.IsAdmin)
+func (q *Queries) SetAdmin(ctx context.Context, arg SetAdminParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, setAdmin, arg.Email, arg.IsAdmin)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const setPasswordHash = `-- name: SetPasswordHash :execrows
+UPDATE users SET password_hash = $2, failed_logins = 0, locked_until = NULL WHERE email = $1
+`
+
+type SetPasswordHashParams struct {
+	Email        string
+	PasswordHash string
+}
+
+func (q *Queries) SetPasswordHash(ctx context.Context, arg SetPasswordHashParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, setPasswordHash, arg.Email, arg.PasswordHash)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const setTOTPSecret = `-- name: SetTOTPSecret :exec
+UPDATE users SET totp_secret = $2, totp_last_step = NULL WHERE id = $1
+`
+
+type SetTOTPSecretParams struct {
+	ID         uuid.UUID
+	TotpSecret sql.NullString
+}
+
+func (q *Queries) SetTOTPSecret(ctx context.Context, arg SetTOTPSecretParams) error {
+	_, err := q.db.ExecContext(ctx, setTOTPSecret, arg.ID, arg.TotpSecret)
 	return err
 }
 
-const updateAppointmentStatus = `-- name: UpdateAppointmentStatus :exec
-UPDATE appointments SET status = $2 WHERE id = $1
+const setUserDisabled = `-- name: SetUserDisabled :execrows
+UPDATE users SET disabled_at = $2 WHERE email = $1
+`
+
+type SetUserDisabledParams struct {
+	Email      string
+	DisabledAt sql.NullTime
+}
+
+func (q *Queries) SetUserDisabled(ctx context.Context, arg SetUserDisabledParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, setUserDisabled, arg.Email, arg.DisabledAt)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const takeOIDCState = `-- name: TakeOIDCState :one
+DELETE FROM oidc_states WHERE state = $1
+RETURNING state, provider, nonce, code_verifier, expires_at
+`
+
+func (q *Queries) TakeOIDCState(ctx context.Context, state string) (OidcState, error) {
+	row := q.db.QueryRowContext(ctx, takeOIDCState, state)
+	var i OidcState
+	err := row.Scan(
+		&i.State,
+		&i.Provider,
+		&i.Nonce,
+		&i.CodeVerifier,
+		&i.ExpiresAt,
+	)
+	return i, err
+}
+
+const takeWebAuthnChallenge = `-- name: TakeWebAuthnChallenge :one
+DELETE FROM webauthn_challenges WHERE challenge = $1
+RETURNING challenge, user_id, session, expires_at
+`
+
+func (q *Queries) TakeWebAuthnChallenge(ctx context.Context, challenge string) (WebauthnChallenge, error) {
+	row := q.db.QueryRowContext(ctx, takeWebAuthnChallenge, challenge)
+	var i WebauthnChallenge
+	err := row.Scan(
+		&i.Challenge,
+		&i.UserID,
+		&i.Session,
+		&i.ExpiresAt,
+	)
+	return i, err
+}
+
+const touchUserIdentity = `-- name: TouchUserIdentity :exec
+UPDATE user_identities SET last_login_at = now() WHERE provider = $1 AND subject = $2
+`
+
+type TouchUserIdentityParams struct {
+	Provider string
+	Subject  string
+}
+
+func (q *Queries) TouchUserIdentity(ctx context.Context, arg TouchUserIdentityParams) error {
+	_, err := q.db.ExecContext(ctx, touchUserIdentity, arg.Provider, arg.Subject)
+	return err
+}
+
+const updateAppointmentStatus = `-- name: UpdateAppointmentStatus :execrows
+UPDATE appointments SET status = $2, version = version + 1 WHERE id = $1 AND version = $3
 `
 
 type UpdateAppointmentStatusParams struct {
-	ID     uuid.UUID
-	Status string
+	ID      uuid.UUID
+	Status  string
+	Version int32
+}
+
+func (q *Queries) UpdateAppointmentStatus(ctx context.Context, arg UpdateAppointmentStatusParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, updateAppointmentStatus, arg.ID, arg.Status, arg.Version)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
 }
 
-func (q *Queries) UpdateAppointmentStatus(ctx context.Context, arg UpdateAppointmentStatusParams) error {
-	_, err := q.db.ExecContext(ctx, updateAppointmentStatus, arg.ID, arg.Status)
+const updateRateLimitBucket = `-- name: UpdateRateLimitBucket :exec
+UPDATE rate_limit_buckets SET tokens = $2, updated_at = $3 WHERE key = $1
+`
+
+type UpdateRateLimitBucketParams struct {
+	Key       string
+	Tokens    float64
+	UpdatedAt time.Time
+}
+
+func (q *Queries) UpdateRateLimitBucket(ctx context.Context, arg UpdateRateLimitBucketParams) error {
+	_, err := q.db.ExecContext(ctx, updateRateLimitBucket, arg.Key, arg.Tokens, arg.UpdatedAt)
 	return err
 }
 
-const userUpdateAppointment = `-- name: UserUpdateAppointment :exec
-UPDATE appointments SET datetime = $2, title = $3, description = $4 WHERE user_id = $5 AND id = $1
+const updateWebAuthnCredentialUse = `-- name: UpdateWebAuthnCredentialUse :exec
+UPDATE webauthn_credentials SET sign_count = $2, backup_state = $3, last_used_at = now()
+WHERE credential_id = $1
+`
+
+type UpdateWebAuthnCredentialUseParams struct {
+	CredentialID []byte
+	SignCount    int64
+	BackupState  bool
+}
+
+func (q *Queries) UpdateWebAuthnCredentialUse(ctx context.Context, arg UpdateWebAuthnCredentialUseParams) error {
+	_, err := q.db.ExecContext(ctx, updateWebAuthnCredentialUse, arg.CredentialID, arg.SignCount, arg.BackupState)
+	return err
+}
+
+const useMagicLinkToken = `-- name: UseMagicLinkToken :one
+UPDATE magic_link_tokens SET used_at = now()
+WHERE token_hash = $1 AND email = $2 AND used_at IS NULL AND expires_at > $3
+RETURNING user_id
+`
+
+type UseMagicLinkTokenParams struct {
+	TokenHash string
+	Email     string
+	ExpiresAt time.Time
+}
+
+func (q *Queries) UseMagicLinkToken(ctx context.Context, arg UseMagicLinkTokenParams) (uuid.UUID, error) {
+	row := q.db.QueryRowContext(ctx, useMagicLinkToken, arg.TokenHash, arg.Email, arg.ExpiresAt)
+	var user_id uuid.UUID
+	err := row.Scan(&user_id)
+	return user_id, err
+}
+
+const useRecoveryCode = `-- name: UseRecoveryCode :execrows
+UPDATE mfa_recovery_codes SET used_at = now()
+WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL
+`
+
+type UseRecoveryCodeParams struct {
+	UserID   uuid.UUID
+	CodeHash string
+}
+
+func (q *Queries) UseRecoveryCode(ctx context.Context, arg UseRecoveryCodeParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, useRecoveryCode, arg.UserID, arg.CodeHash)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const useTOTPStep = `-- name: UseTOTPStep :execrows
+UPDATE users SET totp_last_step = $2
+WHERE id = $1 AND (totp_last_step IS NULL OR totp_last_step < $2)
+`
+
+type UseTOTPStepParams struct {
+	ID           uuid.UUID
+	TotpLastStep sql.NullInt64
+}
+
+func (q *Queries) UseTOTPStep(ctx context.Context, arg UseTOTPStepParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, useTOTPStep, arg.ID, arg.TotpLastStep)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const userUpdateAppointment = `-- name: UserUpdateAppointment :execrows
+UPDATE appointments SET datetime = $2, title = $3, description = $4, version = version + 1
+WHERE user_id = $5 AND id = $1 AND version = $6
 `
 
 type UserUpdateAppointmentParams struct {
@@ -290,15 +1333,20 @@ type UserUpdateAppointmentParams struct {
 	Title       string
 	Description sql.NullString
 	UserID      uuid.NullUUID
+	Version     int32
 }
 
-func (q *Queries) UserUpdateAppointment(ctx context.Context, arg UserUpdateAppointmentParams) error {
-	_, err := q.db.ExecContext(ctx, userUpdateAppointment,
+func (q *Queries) UserUpdateAppointment(ctx context.Context, arg UserUpdateAppointmentParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, userUpdateAppointment,
 		arg.ID,
 		arg.Datetime,
 		arg.Title,
 		arg.Description,
 		arg.UserID,
+		arg.Version,
 	)
-	return err
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
 }
@@ -4,18 +4,18 @@ package handlers
 import (
 	"context"
 	"database/sql"
-	"log"
+	"log/slog"
 	"net/http"
-	"os"
 	"strings"
 
 	"github.com/nickg76/garage-backend/internal/db"
 )
 
-// SetAdminAccountsFromEnv updates admins based on env vars
+// SetAdminAccountsFromEnv updates admins based on the ADMIN_EMAILS and
+// ADMIN_REMOVE_EMAILS settings
 func (s *Server) SetAdminAccountsFromEnv() {
-	adminsToAdd := strings.Split(os.Getenv("ADMIN_EMAILS"), ",")
-	adminsToRemove := strings.Split(os.Getenv("ADMIN_REMOVE_EMAILS"), ",")
+	adminsToAdd := s.cfg.Admin.Emails
+	adminsToRemove := s.cfg.Admin.RemoveEmails
 
 	for _, email := range adminsToAdd {
 		email = strings.TrimSpace(email)
@@ -35,30 +35,33 @@ func (s *Server) SetAdminAccountsFromEnv() {
 }
 
 func updateAdmin(s *Server, email string, isAdmin bool) {
-	log.Printf("Setting %s admin=%v", email, isAdmin)
-	err := s.queries.SetAdmin(context.Background(), db.SetAdminParams{
+	n, err := s.store.SetAdmin(context.Background(), db.SetAdminParams{
 		Email:   email,
 		IsAdmin: sql.NullBool{Bool: isAdmin, Valid: true},
 	})
 	if err != nil {
-		log.Printf("❌ Failed to update %s: %v", email, err)
-	} else {
-		log.Printf("✅ Updated %s successfully", email)
+		slog.Error("updating admin flag", "email", email, "admin", isAdmin, "err", err)
+		return
+	}
+	if n == 0 {
+		slog.Warn("updating admin flag: no such account", "email", email)
+		return
 	}
+	slog.Info("updated admin flag", "email", email, "admin", isAdmin)
 }
 
 // UpdateAdminAccountsHandler triggers SetAdminAccountsFromEnv via HTTP
 func (s *Server) UpdateAdminAccountsHandler(w http.ResponseWriter, r *http.Request) {
 	// Optional: disable this route in production unless DEV_MODE=true
-	if os.Getenv("DEV_MODE") != "true" {
-		http.Error(w, "not available in production", http.StatusForbidden)
+	if !s.cfg.DevMode {
+		writeError(w, r, http.StatusForbidden, "not_available", "not available in production")
 		return
 	}
 
 	// Check for extra shared secret
 	secret := r.URL.Query().Get("secret")
-	if secret != os.Getenv("ADMIN_UPDATE_SECRET") {
-		http.Error(w, "forbidden", http.StatusForbidden)
+	if secret != s.cfg.Admin.UpdateSecret {
+		writeError(w, r, http.StatusForbidden, "forbidden", "forbidden")
 		return
 	}
 
@@ -4,7 +4,9 @@ package handlers
 import (
     "database/sql"
     "encoding/json"
+    "fmt"
     "net/http"
+    "sort"
     "strings"
     "time"
 
@@ -22,27 +24,22 @@ type createApptReq struct {
 func (s *Server) CreateAppointment(w http.ResponseWriter, r *http.Request) {
     userID, _ := GetUser(r.Context())
     if userID == "" {
-        http.Error(w, "unauthorized", http.StatusUnauthorized)
+        writeError(w, r, http.StatusUnauthorized, "unauthorized", "unauthorized")
         return
     }
 
     var req createApptReq
-    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-        http.Error(w, "invalid body", http.StatusBadRequest)
-        return
-    }
-    t, err := time.Parse(time.RFC3339, req.Datetime)
-    if err != nil {
-        http.Error(w, "invalid datetime", http.StatusBadRequest)
+    if !decodeValid(w, r, &req) {
         return
     }
+    t, _ := time.Parse(time.RFC3339, req.Datetime)
     nu, err := toNullUUID(userID)
     if err != nil {
-        http.Error(w, "invalid user id", http.StatusBadRequest)
+        writeError(w, r, http.StatusBadRequest, "invalid_user_id", "invalid user id")
         return
     }
 
-    appt, err := s.queries.CreateAppointment(r.Context(), db.CreateAppointmentParams{
+    appt, err := s.store.CreateAppointment(r.Context(), db.CreateAppointmentParams{
         ID:          uuid.New(),
         UserID:      nu,
         Datetime:    t,
@@ -50,7 +47,46 @@ func (s *Server) CreateAppointment(w http.ResponseWriter, r *http.Request) {
         Description: sql.NullString{String: req.Description, Valid: req.Description != ""},
     })
     if err != nil {
-        http.Error(w, "db error", http.StatusInternalServerError)
+        writeError(w, r, http.StatusInternalServerError, "internal_error", "db error")
+        return
+    }
+    w.Header().Set("Content-Type", "application/json")
+    w.Header().Set("ETag", apptETag(appt))
+    json.NewEncoder(w).Encode(toApptDTO(appt))
+}
+
+func (s *Server) GetAppointment(w http.ResponseWriter, r *http.Request) {
+    userID, isAdmin := GetUser(r.Context())
+    if userID == "" {
+        writeError(w, r, http.StatusUnauthorized, "unauthorized", "unauthorized")
+        return
+    }
+    // Expected path: /api/appointments/{id}
+    parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+    if len(parts) != 3 || parts[0] != "api" || parts[1] != "appointments" {
+        writeError(w, r, http.StatusBadRequest, "bad_path", "bad path")
+        return
+    }
+    uid, err := uuid.Parse(parts[2])
+    if err != nil {
+        writeError(w, r, http.StatusBadRequest, "invalid_id", "invalid id")
+        return
+    }
+
+    appt, err := s.store.GetAppointmentsByID(r.Context(), uid)
+    if err != nil {
+        writeError(w, r, http.StatusNotFound, "appointment_not_found", "appointment not found")
+        return
+    }
+    if !isAdmin && (!appt.UserID.Valid || appt.UserID.UUID.String() != userID) {
+        writeError(w, r, http.StatusForbidden, "forbidden", "forbidden")
+        return
+    }
+
+    etag := apptETag(appt)
+    w.Header().Set("ETag", etag)
+    if r.Header.Get("If-None-Match") == etag {
+        w.WriteHeader(http.StatusNotModified)
         return
     }
     w.Header().Set("Content-Type", "application/json")
@@ -61,12 +97,12 @@ func (s *Server) GetMyAppointments(w http.ResponseWriter, r *http.Request) {
     userID, _ := GetUser(r.Context())
     nu, err := toNullUUID(userID)
     if err != nil {
-        http.Error(w, "invalid user id", http.StatusBadRequest)
+        writeError(w, r, http.StatusBadRequest, "invalid_user_id", "invalid user id")
         return
     }
-    items, err := s.queries.GetAppointmentsForUser(r.Context(), nu)
+    items, err := s.store.GetAppointmentsForUser(r.Context(), nu)
     if err != nil {
-        http.Error(w, "db error", http.StatusInternalServerError)
+        writeError(w, r, http.StatusInternalServerError, "internal_error", "db error")
         return
     }
     w.Header().Set("Content-Type", "application/json")
@@ -74,9 +110,9 @@ func (s *Server) GetMyAppointments(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) AdminListAppointments(w http.ResponseWriter, r *http.Request) {
-    items, err := s.queries.GetAllAppointments(r.Context())
+    items, err := s.store.GetAllAppointments(r.Context())
     if err != nil {
-        http.Error(w, "db error", http.StatusInternalServerError)
+        writeError(w, r, http.StatusInternalServerError, "internal_error", "db error")
         return
     }
     w.Header().Set("Content-Type", "application/json")
@@ -93,38 +129,49 @@ func (s *Server) AdminUpdateStatus(w http.ResponseWriter, r *http.Request) {
     parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
     // Validate the exact shape of the path
     if len(parts) != 5 || parts[0] != "api" || parts[1] != "admin" || parts[2] != "appointments" || parts[4] != "status" {
-        http.Error(w, "bad path", http.StatusBadRequest)
+        writeError(w, r, http.StatusBadRequest, "bad_path", "bad path")
         return
     }
 
     idStr := parts[3]
     uid, err := uuid.Parse(idStr)
     if err != nil {
-        http.Error(w, "invalid id", http.StatusBadRequest)
+        writeError(w, r, http.StatusBadRequest, "invalid_id", "invalid id")
         return
     }
 
     var req updateStatusReq
-    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-        http.Error(w, "invalid body", http.StatusBadRequest)
+    if !decodeValid(w, r, &req) {
+        return
+    }
+
+    current, err := s.store.GetAppointmentsByID(r.Context(), uid)
+    if err != nil {
+        writeError(w, r, http.StatusNotFound, "appointment_not_found", "appointment not found")
         return
     }
-    req.Status = strings.ToLower(strings.TrimSpace(req.Status))
-    if req.Status != "accepted" && req.Status != "rejected" && req.Status != "pending" {
-        http.Error(w, "invalid status", http.StatusBadRequest)
+    if !checkIfMatch(w, r, current) {
         return
     }
 
-    if err := s.queries.UpdateAppointmentStatus(r.Context(), db.UpdateAppointmentStatusParams{
-        ID:     uid,
-        Status: req.Status,
-    }); err != nil {
-        http.Error(w, "db error", http.StatusInternalServerError)
+    n, err := s.store.UpdateAppointmentStatus(r.Context(), db.UpdateAppointmentStatusParams{
+        ID:      uid,
+        Status:  req.Status,
+        Version: current.Version,
+    })
+    if err != nil {
+        writeError(w, r, http.StatusInternalServerError, "internal_error", "db error")
+        return
+    }
+    if n == 0 {
+        // someone else changed it between our read and the update
+        writeError(w, r, http.StatusPreconditionFailed, "version_mismatch", "appointment was modified")
         return
     }
 
-	appt, err := s.queries.GetAppointmentsByID(r.Context(), uid)
+	appt, err := s.store.GetAppointmentsByID(r.Context(), uid)
 	if err == nil {
+		w.Header().Set("ETag", apptETag(appt))
 		// notify the appointment's user if present
 		if appt.UserID.Valid {
 			s.publishToUser(r.Context(), appt.UserID.UUID.String(), Event{
@@ -142,45 +189,53 @@ func (s *Server) AdminUpdateStatus(w http.ResponseWriter, r *http.Request) {
 func (s *Server) UserDeleteAppointment(w http.ResponseWriter, r *http.Request) {
 	userID, _ := GetUser(r.Context())
 	if userID == "" {
-		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		writeError(w, r, http.StatusUnauthorized, "unauthorized", "unauthorized")
 		return
 	}
 	// Expected path: /api/appointments/{id}
 	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
 	// Validate the exact shape of the path
 	if len(parts) != 3 || parts[0] != "api" || parts[1] != "appointments" {
-		http.Error(w, "bad path", http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, "bad_path", "bad path")
 		return
 	}
 
 	idStr := parts[2]
 	uid, err := uuid.Parse(idStr)
 	if err != nil {
-		http.Error(w, "invalid id", http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, "invalid_id", "invalid id")
 		return
 	}
 
 	nu, err := toNullUUID(userID)
 	if err != nil {
-		http.Error(w, "invalid user id", http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, "invalid_user_id", "invalid user id")
 		return
 	}
 
 	// Ensure the appointment belongs to the user
-	appt, err := s.queries.GetAppointmentsByID(r.Context(), uid)
+	appt, err := s.store.GetAppointmentsByID(r.Context(), uid)
 	if err != nil {
-		http.Error(w, "appointment not found", http.StatusNotFound)
+		writeError(w, r, http.StatusNotFound, "appointment_not_found", "appointment not found")
 		return
 	}
 	if !appt.UserID.Valid || appt.UserID.UUID.String() != nu.UUID.String() {
-		http.Error(w, "forbidden", http.StatusForbidden)
+		writeError(w, r, http.StatusForbidden, "forbidden", "forbidden")
+		return
+	}
+
+	// the rows cascade with the appointment, the blobs have to go by hand
+	atts, err := s.store.GetAttachmentsForAppointment(r.Context(), uid)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "db error")
 		return
 	}
 
-	if err := s.queries.DeleteAppointment(r.Context(), uid); err != nil {
-		http.Error(w, "db error", http.StatusInternalServerError)
+	if err := s.store.DeleteAppointment(r.Context(), uid); err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "db error")
 		return
 	}
+	s.deleteAttachmentFiles(r.Context(), atts)
 	w.WriteHeader(http.StatusNoContent)
 	
 }
@@ -189,78 +244,179 @@ func (s *Server) UserDeleteAppointment(w http.ResponseWriter, r *http.Request) {
 
 // ... existing code ...
 
+// editApptReq is the PUT body, the same fields as a new booking.
+type editApptReq createApptReq
+
+// UserEditAppointment handles PUT, a full replacement of the editable fields.
+// Omitting description clears it.
 func (s *Server) UserEditAppointment(w http.ResponseWriter, r *http.Request) {
-	userID, _ := GetUser(r.Context())
-	if userID == "" {
-		http.Error(w, "unauthorized", http.StatusUnauthorized)
+	appt, nu, ok := s.ownedAppointmentForEdit(w, r)
+	if !ok {
 		return
 	}
-	// Expected path: /api/appointments/{id}
-	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
-	if len(parts) != 3 || parts[0] != "api" || parts[1] != "appointments" {
-		http.Error(w, "bad path", http.StatusBadRequest)
+
+	var req editApptReq
+	if !decodeValid(w, r, &req) {
+		return
+	}
+	t, msg := rescheduledDatetime(appt.Datetime, req.Datetime)
+	if msg != "" {
+		writeValidationProblem(w, r, []fieldError{{Field: "datetime", Message: msg}})
 		return
 	}
 
-	idStr := parts[2]
-	uid, err := uuid.Parse(idStr)
-	if err != nil {
-		http.Error(w, "invalid id", http.StatusBadRequest)
+	s.saveAppointmentEdit(w, r, appt, nu, t, req.Title, toNullString(req.Description))
+}
+
+// UserPatchAppointment handles PATCH as a JSON Merge Patch (RFC 7396): only
+// the fields present are changed, and description may be set to null to clear it.
+func (s *Server) UserPatchAppointment(w http.ResponseWriter, r *http.Request) {
+	ct := r.Header.Get("Content-Type")
+	if ct != "" && !strings.HasPrefix(ct, "application/merge-patch+json") && !strings.HasPrefix(ct, "application/json") {
+		writeError(w, r, http.StatusUnsupportedMediaType, "unsupported_media_type", "unsupported content type")
 		return
 	}
 
-	var req createApptReq
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "invalid body", http.StatusBadRequest)
+	appt, nu, ok := s.ownedAppointmentForEdit(w, r)
+	if !ok {
 		return
 	}
-	t, err := time.Parse(time.RFC3339, req.Datetime)
-	if err != nil {
-		http.Error(w, "invalid datetime", http.StatusBadRequest)
+
+	var patch map[string]json.RawMessage
+	if !decodeJSON(w, r, &patch) {
+		return
+	}
+
+	t, title, desc := appt.Datetime, appt.Title, appt.Description
+	var errs []fieldError
+	for field, raw := range patch {
+		isNull := string(raw) == "null"
+		switch field {
+		case "datetime":
+			var v string
+			if isNull || json.Unmarshal(raw, &v) != nil {
+				errs = append(errs, fieldError{Field: field, Message: "must be an RFC 3339 timestamp"})
+				continue
+			}
+			nt, msg := rescheduledDatetime(appt.Datetime, v)
+			if msg != "" {
+				errs = append(errs, fieldError{Field: field, Message: msg})
+				continue
+			}
+			t = nt
+		case "title":
+			var v string
+			if isNull || json.Unmarshal(raw, &v) != nil {
+				errs = append(errs, fieldError{Field: field, Message: "must be a string"})
+				continue
+			}
+			v = strings.TrimSpace(v)
+			if msg := checkTitle(v); msg != "" {
+				errs = append(errs, fieldError{Field: field, Message: msg})
+				continue
+			}
+			title = v
+		case "description":
+			if isNull {
+				desc = sql.NullString{}
+				continue
+			}
+			var v string
+			if json.Unmarshal(raw, &v) != nil {
+				errs = append(errs, fieldError{Field: field, Message: "must be a string or null"})
+				continue
+			}
+			if msg := checkMaxLen(v, maxDescriptionLen); msg != "" {
+				errs = append(errs, fieldError{Field: field, Message: msg})
+				continue
+			}
+			desc = toNullString(v)
+		default:
+			errs = append(errs, fieldError{Field: field, Message: "cannot be changed"})
+		}
+	}
+	if len(errs) > 0 {
+		sort.Slice(errs, func(i, j int) bool { return errs[i].Field < errs[j].Field })
+		writeValidationProblem(w, r, errs)
 		return
 	}
+
+	s.saveAppointmentEdit(w, r, appt, nu, t, title, desc)
+}
+
+// ownedAppointmentForEdit resolves /api/appointments/{id}, checks the caller
+// owns it and that their If-Match is current.
+func (s *Server) ownedAppointmentForEdit(w http.ResponseWriter, r *http.Request) (db.Appointment, uuid.NullUUID, bool) {
+	userID, _ := GetUser(r.Context())
+	if userID == "" {
+		writeError(w, r, http.StatusUnauthorized, "unauthorized", "unauthorized")
+		return db.Appointment{}, uuid.NullUUID{}, false
+	}
+	// Expected path: /api/appointments/{id}
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) != 3 || parts[0] != "api" || parts[1] != "appointments" {
+		writeError(w, r, http.StatusBadRequest, "bad_path", "bad path")
+		return db.Appointment{}, uuid.NullUUID{}, false
+	}
+
+	idStr := parts[2]
+	uid, err := uuid.Parse(idStr)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_id", "invalid id")
+		return db.Appointment{}, uuid.NullUUID{}, false
+	}
 	nu, err := toNullUUID(userID)
 	if err != nil {
-		http.Error(w, "invalid user id", http.StatusBadRequest)
-		return
+		writeError(w, r, http.StatusBadRequest, "invalid_user_id", "invalid user id")
+		return db.Appointment{}, uuid.NullUUID{}, false
 	}
 
 	// Ensure the appointment belongs to the user
-	appt, err := s.queries.GetAppointmentsByID(r.Context(), uid)
+	appt, err := s.store.GetAppointmentsByID(r.Context(), uid)
 	if err != nil {
-		http.Error(w, "appointment not found", http.StatusNotFound)
-		return
+		writeError(w, r, http.StatusNotFound, "appointment_not_found", "appointment not found")
+		return db.Appointment{}, uuid.NullUUID{}, false
 	}
 	if !appt.UserID.Valid || appt.UserID.UUID.String() != nu.UUID.String() {
-		http.Error(w, "forbidden", http.StatusForbidden)
-		return
+		writeError(w, r, http.StatusForbidden, "forbidden", "forbidden")
+		return db.Appointment{}, uuid.NullUUID{}, false
 	}
+	if !checkIfMatch(w, r, appt) {
+		return db.Appointment{}, uuid.NullUUID{}, false
+	}
+	return appt, nu, true
+}
 
-    // --- Start of Fix ---
-
-	// First, execute the update. This function only returns an error.
-	err = s.queries.UserUpdateAppointment(r.Context(), db.UserUpdateAppointmentParams{
-		ID:          uid,
+// saveAppointmentEdit writes the new field values, guarded by the version the
+// client saw, and responds with the updated appointment.
+func (s *Server) saveAppointmentEdit(w http.ResponseWriter, r *http.Request, appt db.Appointment, nu uuid.NullUUID, t time.Time, title string, desc sql.NullString) {
+	n, err := s.store.UserUpdateAppointment(r.Context(), db.UserUpdateAppointmentParams{
+		ID:          appt.ID,
 		Datetime:    t,
-		Title:       req.Title,
-		Description: sql.NullString{String: req.Description, Valid: req.Description != ""},
+		Title:       title,
+		Description: desc,
 		UserID:      nu,
+		Version:     appt.Version,
 	})
 	if err != nil {
-		http.Error(w, "db error on update", http.StatusInternalServerError)
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "db error on update")
+		return
+	}
+	if n == 0 {
+		// someone else changed it between our read and the update
+		writeError(w, r, http.StatusPreconditionFailed, "version_mismatch", "appointment was modified")
 		return
 	}
 
 	// Then, fetch the newly updated appointment to return it.
-	updatedAppt, err := s.queries.GetAppointmentsByID(r.Context(), uid)
+	updatedAppt, err := s.store.GetAppointmentsByID(r.Context(), appt.ID)
 	if err != nil {
-		http.Error(w, "db error on fetch after update", http.StatusInternalServerError)
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "db error on fetch after update")
 		return
 	}
 
-    // --- End of Fix ---
-
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", apptETag(updatedAppt))
 	json.NewEncoder(w).Encode(toApptDTO(updatedAppt))
 }
 
@@ -281,6 +437,33 @@ func nullUUID(u uuid.NullUUID) string {
     return ""
 }
 
+// apptETag is the entity tag for an appointment, bumped on every write.
+func apptETag(a db.Appointment) string {
+    return fmt.Sprintf(`"%d"`, a.Version)
+}
+
+// checkIfMatch enforces optimistic concurrency on writes: the client must send
+// the ETag it last saw in If-Match. Writes 428/412 and returns false otherwise.
+func checkIfMatch(w http.ResponseWriter, r *http.Request, a db.Appointment) bool {
+    h := strings.TrimSpace(r.Header.Get("If-Match"))
+    if h == "" {
+        writeError(w, r, http.StatusPreconditionRequired, "if_match_required", "If-Match header required")
+        return false
+    }
+    if h == "*" {
+        return true
+    }
+    current := apptETag(a)
+    for _, tag := range strings.Split(h, ",") {
+        if strings.TrimSpace(tag) == current {
+            return true
+        }
+    }
+    w.Header().Set("ETag", current)
+    writeError(w, r, http.StatusPreconditionFailed, "version_mismatch", "appointment was modified")
+    return false
+}
+
 // --- DTOs ---
 
 type appointmentDTO struct {
@@ -291,6 +474,7 @@ type appointmentDTO struct {
     Description string `json:"description"`
     Status      string `json:"status"`
     CreatedAt   string `json:"created_at"`
+    Version     int32  `json:"version"`
     UserName    string `json:"user_name"`
     UserEmail   string `json:"user_email"`
     UserPhone   string `json:"user_phone"`
@@ -306,6 +490,7 @@ func toApptDTO(a db.Appointment) appointmentDTO {
         Description: nullStr(a.Description),
         Status:      a.Status,
         CreatedAt:   a.CreatedAt.Format(time.RFC3339),
+        Version:     a.Version,
         // No joined fields here
         UserName:  "",
         UserEmail: "",
@@ -331,6 +516,7 @@ func toApptDTOAdmin(a db.GetAllAppointmentsRow) appointmentDTO {
         Description: nullStr(a.Description),
         Status:      a.Status,
         CreatedAt:   a.CreatedAt.Format(time.RFC3339),
+        Version:     a.Version,
         UserName:    a.UserName,
         UserEmail:   a.UserEmail,
         UserPhone:   a.UserPhone,
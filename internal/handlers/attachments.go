@@ -0,0 +1,306 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/nickg76/garage-backend/internal/db"
+	"github.com/nickg76/garage-backend/internal/storage"
+)
+
+const (
+	maxAttachmentSize = 10 << 20 // 10 MiB
+	thumbnailSize     = 256
+	downloadURLTTL    = 15 * time.Minute
+)
+
+// Content types we accept, sniffed from the file rather than trusting the client.
+var allowedAttachmentTypes = map[string]bool{
+	"image/jpeg":      true,
+	"image/png":       true,
+	"image/gif":       true,
+	"image/webp":      true,
+	"application/pdf": true,
+}
+
+// Types the stdlib can decode to build a thumbnail.
+var thumbnailTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+}
+
+func (s *Server) UploadAttachment(w http.ResponseWriter, r *http.Request) {
+	appt, ok := s.appointmentForSubresource(w, r, "attachments")
+	if !ok {
+		return
+	}
+	userID, _ := GetUser(r.Context())
+
+	// leave some headroom for the multipart framing around the file
+	r.Body = http.MaxBytesReader(w, r.Body, maxAttachmentSize+1<<20)
+	if err := r.ParseMultipartForm(maxAttachmentSize); err != nil {
+		writeError(w, r, http.StatusRequestEntityTooLarge, "file_too_large", "file too large or invalid form")
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "missing_file", "missing file")
+		return
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, maxAttachmentSize+1))
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_file", "invalid file")
+		return
+	}
+	if len(data) == 0 {
+		writeError(w, r, http.StatusBadRequest, "empty_file", "empty file")
+		return
+	}
+	if len(data) > maxAttachmentSize {
+		writeError(w, r, http.StatusRequestEntityTooLarge, "file_too_large", "file too large")
+		return
+	}
+
+	contentType := http.DetectContentType(data)
+	if i := strings.Index(contentType, ";"); i >= 0 {
+		contentType = contentType[:i]
+	}
+	if !allowedAttachmentTypes[contentType] {
+		writeError(w, r, http.StatusUnsupportedMediaType, "unsupported_file_type", "unsupported file type")
+		return
+	}
+
+	nu, err := toNullUUID(userID)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_user_id", "invalid user id")
+		return
+	}
+
+	id := uuid.New()
+	key := "attachments/" + appt.ID.String() + "/" + id.String()
+	if err := s.files.Put(r.Context(), key, bytes.NewReader(data), contentType); err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "storage error")
+		return
+	}
+
+	var thumbKey sql.NullString
+	if thumbnailTypes[contentType] {
+		// a broken thumbnail shouldn't fail the upload itself
+		if thumb, err := storage.Thumbnail(data, thumbnailSize); err == nil {
+			k := key + "_thumb.jpg"
+			if err := s.files.Put(r.Context(), k, bytes.NewReader(thumb), "image/jpeg"); err == nil {
+				thumbKey = sql.NullString{String: k, Valid: true}
+			}
+		}
+	}
+
+	att, err := s.store.CreateAttachment(r.Context(), db.CreateAttachmentParams{
+		ID:            id,
+		AppointmentID: appt.ID,
+		UploaderID:    nu,
+		Filename:      path.Base(header.Filename),
+		ContentType:   contentType,
+		SizeBytes:     int64(len(data)),
+		StorageKey:    key,
+		ThumbnailKey:  thumbKey,
+	})
+	if err != nil {
+		s.files.Delete(r.Context(), key)
+		if thumbKey.Valid {
+			s.files.Delete(r.Context(), thumbKey.String)
+		}
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "db error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(s.toAttachmentDTO(att))
+}
+
+func (s *Server) ListAttachments(w http.ResponseWriter, r *http.Request) {
+	appt, ok := s.appointmentForSubresource(w, r, "attachments")
+	if !ok {
+		return
+	}
+	items, err := s.store.GetAttachmentsForAppointment(r.Context(), appt.ID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "db error")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.toAttachmentSliceDTO(items))
+}
+
+// DownloadAttachment serves a file using a signed URL from the attachment
+// listing, so it can be used directly in <img> tags and links.
+func (s *Server) DownloadAttachment(w http.ResponseWriter, r *http.Request) {
+	// Expected path: /api/attachments/{id}
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) != 3 || parts[0] != "api" || parts[1] != "attachments" {
+		writeError(w, r, http.StatusBadRequest, "bad_path", "bad path")
+		return
+	}
+	uid, err := uuid.Parse(parts[2])
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_id", "invalid id")
+		return
+	}
+
+	q := r.URL.Query()
+	thumb := q.Get("thumb") == "1"
+	expires, err := strconv.ParseInt(q.Get("expires"), 10, 64)
+	if err != nil || !s.keys.VerifyResource(attachmentResource(uid, thumb), expires, q.Get("sig")) {
+		writeError(w, r, http.StatusForbidden, "forbidden", "forbidden")
+		return
+	}
+
+	att, err := s.store.GetAttachmentByID(r.Context(), uid)
+	if err != nil {
+		writeError(w, r, http.StatusNotFound, "attachment_not_found", "attachment not found")
+		return
+	}
+	key, contentType := att.StorageKey, att.ContentType
+	if thumb {
+		if !att.ThumbnailKey.Valid {
+			writeError(w, r, http.StatusNotFound, "thumbnail_not_found", "no thumbnail")
+			return
+		}
+		key, contentType = att.ThumbnailKey.String, "image/jpeg"
+	}
+
+	rc, err := s.files.Get(r.Context(), key)
+	if errors.Is(err, storage.ErrNotFound) {
+		writeError(w, r, http.StatusNotFound, "attachment_not_found", "attachment not found")
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "storage error")
+		return
+	}
+	defer rc.Close()
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Cache-Control", "private, max-age=900")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if !thumb {
+		// names that aren't plain ASCII are encoded as in RFC 2231
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("inline", map[string]string{"filename": att.Filename}))
+	}
+	io.Copy(w, rc)
+}
+
+// deleteAttachmentFiles removes the stored blobs of attachments whose rows are
+// gone. Failures only leave orphaned objects behind, so they are logged.
+func (s *Server) deleteAttachmentFiles(ctx context.Context, atts []db.Attachment) {
+	ctx = context.WithoutCancel(ctx)
+	for _, a := range atts {
+		keys := []string{a.StorageKey}
+		if a.ThumbnailKey.Valid {
+			keys = append(keys, a.ThumbnailKey.String)
+		}
+		for _, k := range keys {
+			if err := s.files.Delete(ctx, k); err != nil {
+				Logger(ctx).Error("attachments: deleting file", "key", k, "err", err)
+			}
+		}
+	}
+}
+
+// resignAttachment refreshes the download URLs in a stored upload response.
+// They are only valid for downloadURLTTL, an idempotent replay a day later
+// would otherwise hand out links that have long expired.
+func (s *Server) resignAttachment(body []byte) ([]byte, error) {
+	var dto attachmentDTO
+	if err := json.Unmarshal(body, &dto); err != nil {
+		return nil, err
+	}
+	id, err := uuid.Parse(dto.ID)
+	if err != nil {
+		return nil, err
+	}
+	dto.URL = s.signedAttachmentURL(id, false)
+	if dto.ThumbnailURL != "" {
+		dto.ThumbnailURL = s.signedAttachmentURL(id, true)
+	}
+	var out bytes.Buffer
+	if err := json.NewEncoder(&out).Encode(dto); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+func attachmentResource(id uuid.UUID, thumb bool) string {
+	if thumb {
+		return "attachment:" + id.String() + ":thumb"
+	}
+	return "attachment:" + id.String()
+}
+
+func (s *Server) signedAttachmentURL(id uuid.UUID, thumb bool) string {
+	expires := time.Now().Add(downloadURLTTL)
+	q := url.Values{}
+	q.Set("expires", strconv.FormatInt(expires.Unix(), 10))
+	q.Set("sig", s.keys.SignResource(attachmentResource(id, thumb), expires))
+	if thumb {
+		q.Set("thumb", "1")
+	}
+	return "/api/attachments/" + id.String() + "?" + q.Encode()
+}
+
+// --- DTOs ---
+
+type attachmentDTO struct {
+	ID            string `json:"id"`
+	AppointmentID string `json:"appointment_id"`
+	UploaderID    string `json:"uploader_id"`
+	Filename      string `json:"filename"`
+	ContentType   string `json:"content_type"`
+	SizeBytes     int64  `json:"size_bytes"`
+	URL           string `json:"url"`
+	ThumbnailURL  string `json:"thumbnail_url,omitempty"`
+	CreatedAt     string `json:"created_at"`
+}
+
+func (s *Server) toAttachmentDTO(a db.Attachment) attachmentDTO {
+	dto := attachmentDTO{
+		ID:            a.ID.String(),
+		AppointmentID: a.AppointmentID.String(),
+		UploaderID:    nullUUID(a.UploaderID),
+		Filename:      a.Filename,
+		ContentType:   a.ContentType,
+		SizeBytes:     a.SizeBytes,
+		URL:           s.signedAttachmentURL(a.ID, false),
+		CreatedAt:     a.CreatedAt.Format(time.RFC3339),
+	}
+	if a.ThumbnailKey.Valid {
+		dto.ThumbnailURL = s.signedAttachmentURL(a.ID, true)
+	}
+	return dto
+}
+
+func (s *Server) toAttachmentSliceDTO(in []db.Attachment) []attachmentDTO {
+	out := make([]attachmentDTO, 0, len(in))
+	for _, a := range in {
+		out = append(out, s.toAttachmentDTO(a))
+	}
+	return out
+}
@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/nickg76/garage-backend/internal/auth"
+)
+
+func TestResignAttachmentRefreshesURLs(t *testing.T) {
+	s := &Server{keys: &auth.Keys{Secret: []byte("test-secret")}}
+	id := uuid.New()
+	stale := "/api/attachments/" + id.String() + "?expires=1&sig=deadbeef"
+	body, _ := json.Marshal(attachmentDTO{
+		ID:           id.String(),
+		Filename:     "brake.jpg",
+		URL:          stale,
+		ThumbnailURL: stale + "&thumb=1",
+	})
+
+	out, err := s.resignAttachment(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dto attachmentDTO
+	if err := json.Unmarshal(out, &dto); err != nil {
+		t.Fatal(err)
+	}
+	if dto.Filename != "brake.jpg" {
+		t.Fatalf("filename = %q, other fields must be kept", dto.Filename)
+	}
+	for _, c := range []struct {
+		link  string
+		thumb bool
+	}{{dto.URL, false}, {dto.ThumbnailURL, true}} {
+		u, err := url.Parse(c.link)
+		if err != nil || !strings.HasSuffix(u.Path, id.String()) {
+			t.Fatalf("bad url %q", c.link)
+		}
+		q := u.Query()
+		expires, _ := strconv.ParseInt(q.Get("expires"), 10, 64)
+		if time.Until(time.Unix(expires, 0)) < downloadURLTTL-time.Minute {
+			t.Fatalf("url %q was not refreshed", c.link)
+		}
+		if !s.keys.VerifyResource(attachmentResource(id, c.thumb), expires, q.Get("sig")) {
+			t.Fatalf("url %q does not verify", c.link)
+		}
+	}
+}
+
+func TestResignAttachmentWithoutThumbnail(t *testing.T) {
+	s := &Server{keys: &auth.Keys{Secret: []byte("test-secret")}}
+	body, _ := json.Marshal(attachmentDTO{ID: uuid.NewString(), URL: "/stale"})
+	out, err := s.resignAttachment(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dto attachmentDTO
+	json.Unmarshal(out, &dto)
+	if dto.URL == "/stale" || dto.ThumbnailURL != "" {
+		t.Fatalf("got url %q thumbnail %q", dto.URL, dto.ThumbnailURL)
+	}
+}
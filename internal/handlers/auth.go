@@ -5,12 +5,13 @@ import (
     "database/sql"
     "encoding/json"
     "net/http"
+    "time"
 
     "github.com/google/uuid"
     "golang.org/x/crypto/bcrypt"
 
-    "github.com/nickg76/garage-backend/internal/auth"
     "github.com/nickg76/garage-backend/internal/db"
+    "github.com/nickg76/garage-backend/internal/metrics"
 )
 
 type registerReq struct {
@@ -22,20 +23,15 @@ type registerReq struct {
 
 func (s *Server) Register(w http.ResponseWriter, r *http.Request) {
     var req registerReq
-    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-        http.Error(w, "invalid body", http.StatusBadRequest)
-        return
-    }
-    if req.Email == "" || req.Password == "" || req.Name == "" {
-        http.Error(w, "missing fields", http.StatusBadRequest)
+    if !decodeValid(w, r, &req) {
         return
     }
     hash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
     if err != nil {
-        http.Error(w, "failed to hash", http.StatusInternalServerError)
+        writeError(w, r, http.StatusInternalServerError, "internal_error", "failed to hash")
         return
     }
-    u, err := s.queries.CreateUser(r.Context(), db.CreateUserParams{
+    u, err := s.store.CreateUser(r.Context(), db.CreateUserParams{
         ID:           uuid.New(),
         Name:         req.Name,
         Email:        req.Email,
@@ -44,7 +40,7 @@ func (s *Server) Register(w http.ResponseWriter, r *http.Request) {
         IsAdmin:      sql.NullBool{Bool: false, Valid: true},
     })
     if err != nil {
-        http.Error(w, "email may already exist", http.StatusConflict)
+        writeError(w, r, http.StatusConflict, "email_taken", "email may already exist")
         return
     }
     json.NewEncoder(w).Encode(map[string]any{
@@ -62,47 +58,84 @@ type loginReq struct {
 
 func (s *Server) Login(w http.ResponseWriter, r *http.Request) {
     var req loginReq
-    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-        http.Error(w, "invalid body", http.StatusBadRequest)
+    if !decodeValid(w, r, &req) {
         return
     }
-    user, err := s.queries.GetUserByEmail(r.Context(), req.Email)
+    user, err := s.store.GetUserByEmail(r.Context(), req.Email)
     if err != nil {
-        http.Error(w, "invalid credentials", http.StatusUnauthorized)
+        writeError(w, r, http.StatusUnauthorized, "invalid_credentials", "invalid credentials")
+        return
+    }
+    // answered like an unknown email, so a lockout doesn't confirm that
+    // the address is registered
+    if until := user.LockedUntil; until.Valid && time.Now().Before(until.Time) {
+        metrics.RateLimited.WithLabelValues("lockout").Inc()
+        writeError(w, r, http.StatusUnauthorized, "invalid_credentials", "invalid credentials")
         return
     }
     if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.Password)); err != nil {
-        http.Error(w, "invalid credentials", http.StatusUnauthorized)
+        s.recordFailedLogin(r.Context(), user)
+        writeError(w, r, http.StatusUnauthorized, "invalid_credentials", "invalid credentials")
         return
     }
-    isAdmin := false
-    if user.IsAdmin.Valid {
-        isAdmin = user.IsAdmin.Bool
+    if user.DisabledAt.Valid {
+        writeError(w, r, http.StatusForbidden, "account_disabled", "account disabled")
+        return
     }
-    token, err := auth.GenerateJWT(user.ID.String(), isAdmin)
-    if err != nil {
-        http.Error(w, "token error", http.StatusInternalServerError)
+    if user.TotpEnabledAt.Valid {
+        // Failed logins are only reset once the second factor passes, so a
+        // known password doesn't buy unlimited code guesses.
+        s.writeMFAChallenge(w, r, user)
         return
     }
-	type u struct {
-		Name  string `json:"name"`
-		Email string `json:"email"`
-		Phone string `json:"phone"`
-		IsAdmin sql.NullBool `json:"is_admin"`
-	}
-	type userDetails struct {
-		User u
-	}
+    s.startSession(w, r, user, false)
+}
 
-	returnedUser := u {
-		Name: 	user.Name,
-		Email:   user.Email,
-		Phone:   user.Phone,
-		IsAdmin: user.IsAdmin,
-	}
+type loginUser struct {
+	Name  string `json:"name"`
+	Email string `json:"email"`
+	Phone string `json:"phone"`
+	IsAdmin sql.NullBool `json:"is_admin"`
+}
 
-	json.NewEncoder(w).Encode(map[string]any{"token": token, "user": returnedUser })
+type loginResp struct {
+	Token string    `json:"token"`
+	User  loginUser `json:"user"`
+	// Set for staff who must enroll in two-factor authentication before
+	// admin endpoints will accept their session.
+	MFAEnrollmentRequired bool `json:"mfa_enrollment_required,omitempty"`
+}
 
+// startSession completes a successful sign-in: it clears the failed login
+// count and returns a session token. mfa records that a second factor, or a
+// user-verified passkey, was used.
+func (s *Server) startSession(w http.ResponseWriter, r *http.Request, user db.User, mfa bool) {
+	if user.FailedLogins > 0 || user.LockedUntil.Valid {
+		if err := s.store.ResetFailedLogins(r.Context(), user.ID); err != nil {
+			Logger(r.Context()).Error("resetting failed logins", "err", err)
+		}
+	}
+	isAdmin := user.IsAdmin.Valid && user.IsAdmin.Bool
+	generate := s.keys.GenerateJWT
+	if mfa {
+		generate = s.keys.GenerateMFAJWT
+	}
+	token, err := generate(user.ID.String(), isAdmin)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "token error")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(loginResp{
+		Token: token,
+		User: loginUser{
+			Name:    user.Name,
+			Email:   user.Email,
+			Phone:   user.Phone,
+			IsAdmin: user.IsAdmin,
+		},
+		MFAEnrollmentRequired: isAdmin && !mfa && !user.TotpEnabledAt.Valid && s.cfg.MFA.RequireForAdmins,
+	})
 }
 
 type meResp struct {
@@ -116,18 +149,18 @@ type meResp struct {
 func (s *Server) Me(w http.ResponseWriter, r *http.Request) {
 	userID, isAdmin := GetUser(r.Context())
 	if userID == "" {
-		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		writeError(w, r, http.StatusUnauthorized, "unauthorized", "unauthorized")
 		return
 	}
 	userIDParsed, err := uuid.Parse(userID)
 	if err != nil {
-		http.Error(w, "invalid user id", http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, "invalid_user_id", "invalid user id")
 		return
 	}
 
-	user, err := s.queries.GetUserByID(r.Context(), userIDParsed)
+	user, err := s.store.GetUserByID(r.Context(), userIDParsed)
 	if err != nil {
-		http.Error(w, "user not found", http.StatusNotFound)
+		writeError(w, r, http.StatusNotFound, "user_not_found", "user not found")
 		return
 	}
 	type u struct {
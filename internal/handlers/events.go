@@ -8,7 +8,12 @@ import (
 	"sync"
 	"time"
 
-	"github.com/nickg76/garage-backend/internal/auth"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/nickg76/garage-backend/internal/metrics"
+	"github.com/nickg76/garage-backend/internal/tracing"
 )
 
 type Event struct {
@@ -16,19 +21,34 @@ type Event struct {
 	Appointment     string `json:"appointment_id,omitempty"`
 	Status          string `json:"status,omitempty"`
 	Message         string `json:"message,omitempty"`
+	MessageID       string `json:"message_id,omitempty"`
 }
 
 type EventHub struct {
 	mu 			sync.RWMutex
 	subs        map[string]map[chan []byte]struct{}
+	done        chan struct{}
+	closeOnce   sync.Once
 }
 
 func NewEventHub() *EventHub {
 	return &EventHub{
 		subs: make(map[string]map[chan []byte]struct{}),
+		done: make(chan struct{}),
 	}
 }
 
+// Close tells every open stream to ask its client to reconnect and end, so
+// in-flight SSE requests don't hold up a graceful shutdown.
+func (h *EventHub) Close() {
+	h.closeOnce.Do(func() { close(h.done) })
+}
+
+// Done is closed once the hub is shutting down.
+func (h *EventHub) Done() <-chan struct{} {
+	return h.done
+}
+
 func (h *EventHub) Subscribe(userID string) (chan []byte, func()) {
 	ch := make(chan []byte, 8)
 	h.mu.Lock()
@@ -37,6 +57,7 @@ func (h *EventHub) Subscribe(userID string) (chan []byte, func()) {
 		h.subs[userID] = make(map[chan []byte]struct{})
 	}
 	h.subs[userID][ch] = struct{}{}
+	metrics.SSESubscribers.Inc()
 	unsub := func() {
 		h.mu.Lock()
 		defer h.mu.Unlock()
@@ -46,6 +67,7 @@ func (h *EventHub) Subscribe(userID string) (chan []byte, func()) {
 				delete(h.subs, userID)
 			}
 		}
+		metrics.SSESubscribers.Dec()
 		close(ch)
 	}
 	return ch, unsub
@@ -57,7 +79,9 @@ func (h *EventHub) Publish(userID string, payload []byte) {
 	for ch := range h.subs[userID] {
 		select {
 		case ch <-payload:
+			metrics.EventsPublished.Inc()
 		default:
+			metrics.EventsDropped.Inc()
 		}
 	}
 }
@@ -66,19 +90,29 @@ func (h *EventHub) Publish(userID string, payload []byte) {
 func (s *Server) Events(w http.ResponseWriter, r *http.Request) {
 	token := r.URL.Query().Get("token")
 	if token == "" {
-		http.Error(w, "missing token", http.StatusUnauthorized)
+		writeError(w, r, http.StatusUnauthorized, "missing_token", "missing token")
 		return
 	}
-	claims, err := auth.ParseJWT(token)
+	claims, err := s.keys.ParseJWT(token)
 	if err != nil {
-		http.Error(w, "invalid token", http.StatusUnauthorized)
+		writeError(w, r, http.StatusUnauthorized, "invalid_token", "invalid token")
 		return
 	}
 	userID := claims.Sub
 	if userID == "" {
-		http.Error(w, "invalid user", http.StatusUnauthorized)
+		writeError(w, r, http.StatusUnauthorized, "invalid_token", "invalid user")
+		return
+	}
+	// staff events are delivered to the admin's own stream, so it needs the
+	// same second factor as the admin endpoints
+	if claims.Admin && !s.adminSession(claims) {
+		writeError(w, r, http.StatusForbidden, "mfa_required", "admin access requires two-factor authentication")
+		return
+	}
+	if !s.accountActive(w, r, userID) {
 		return
 	}
+	r = r.WithContext(WithUser(r.Context(), userID, claims.Admin))
 
 	// SSE headers
 	w.Header().Set("Content-Type", "text/event-stream")
@@ -87,9 +121,11 @@ func (s *Server) Events(w http.ResponseWriter, r *http.Request) {
 
 	flusher, ok := w.(http.Flusher)
 	if !ok {
-		http.Error(w, "stream unsupported", http.StatusInternalServerError)
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "stream unsupported")
 		return
 	}
+	// streams outlive the server's write timeout
+	_ = http.NewResponseController(w).SetWriteDeadline(time.Time{})
 
 	ch, unsubscribe := s.hub.Subscribe(userID)
 	defer unsubscribe()
@@ -107,6 +143,11 @@ func (s *Server) Events(w http.ResponseWriter, r *http.Request) {
 		select {
 		case <-ctx.Done():
 			return
+		case <-s.hub.Done():
+			// server is going away, have the browser reconnect to another instance
+			_, _ = w.Write([]byte("retry: 2000\nevent: reconnect\ndata: {}\n\n"))
+			flusher.Flush()
+			return
 		case <-ticker.C:
 			_, _ = w.Write([]byte(": ping\n\n"))
 			flusher.Flush()
@@ -125,6 +166,30 @@ func (s *Server) Events(w http.ResponseWriter, r *http.Request) {
 
 // Helper to publish typed events
 func (s *Server) publishToUser(ctx context.Context, userID string, ev Event) {
+	_, span := tracing.Tracer().Start(ctx, "events publish user", trace.WithAttributes(
+		attribute.String("event.type", ev.Type),
+	))
+	defer span.End()
 	b, _ := json.Marshal(ev)
 	s.hub.Publish(userID, b)
 }
+
+// Helper to publish typed events to every admin account
+func (s *Server) publishToStaff(ctx context.Context, ev Event) {
+	ctx, span := tracing.Tracer().Start(ctx, "events publish staff", trace.WithAttributes(
+		attribute.String("event.type", ev.Type),
+	))
+	defer span.End()
+	ids, err := s.store.GetAdminUserIDs(ctx)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		Logger(ctx).Error("events: loading staff recipients", "event_type", ev.Type, "err", err)
+		return
+	}
+	span.SetAttributes(attribute.Int("event.recipients", len(ids)))
+	b, _ := json.Marshal(ev)
+	for _, id := range ids {
+		s.hub.Publish(id.String(), b)
+	}
+}
@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestEventHubConcurrency subscribes, publishes and unsubscribes from many
+// goroutines at once. Run with -race; a publish racing an unsubscribe must
+// never send on a closed channel.
+func TestEventHubConcurrency(t *testing.T) {
+	h := NewEventHub()
+	const users, rounds = 8, 200
+
+	var wg sync.WaitGroup
+	for u := range users {
+		user := fmt.Sprint("user-", u)
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for range rounds {
+				h.Publish(user, []byte("{}"))
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for range rounds {
+				ch, unsubscribe := h.Subscribe(user)
+				select {
+				case <-ch:
+				default:
+				}
+				unsubscribe()
+			}
+		}()
+	}
+	wg.Wait()
+
+	h.mu.RLock()
+	left := len(h.subs)
+	h.mu.RUnlock()
+	if left != 0 {
+		t.Fatalf("%d users still have subscriptions after every stream unsubscribed", left)
+	}
+}
+
+func TestEventHubDelivery(t *testing.T) {
+	h := NewEventHub()
+	a1, unsubA1 := h.Subscribe("a")
+	defer unsubA1()
+	a2, unsubA2 := h.Subscribe("a")
+	defer unsubA2()
+	b, unsubB := h.Subscribe("b")
+	defer unsubB()
+
+	h.Publish("a", []byte("hello"))
+	for i, ch := range []chan []byte{a1, a2} {
+		select {
+		case msg := <-ch:
+			if string(msg) != "hello" {
+				t.Fatalf("stream %d got %q", i, msg)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("stream %d got nothing", i)
+		}
+	}
+	select {
+	case msg := <-b:
+		t.Fatalf("other user got %q", msg)
+	default:
+	}
+
+	// a stream that stops reading loses events instead of blocking others
+	for range cap(a1) + 4 {
+		h.Publish("a", []byte("x"))
+	}
+	if len(a1) != cap(a1) {
+		t.Fatalf("buffer holds %d of %d", len(a1), cap(a1))
+	}
+
+	h.Close()
+	select {
+	case <-h.Done():
+	default:
+		t.Fatal("Done not closed by Close")
+	}
+	h.Close() // twice is fine
+}
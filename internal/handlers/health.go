@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/nickg76/garage-backend/internal/buildinfo"
+	"github.com/nickg76/garage-backend/internal/migrate"
+)
+
+// Healthz reports that the process is up. It never touches dependencies so a
+// slow database doesn't get the pod restarted.
+func (s *Server) Healthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
+
+type readyResp struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks"`
+}
+
+// Readyz reports whether this instance should receive traffic: the database
+// answers, its schema is at least what this build expects, the event hub is
+// running and we're not shutting down. The endpoint is public, so failures
+// only name the check; the underlying errors go to the log.
+func (s *Server) Readyz(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+	defer cancel()
+
+	resp := readyResp{Status: "ok", Checks: map[string]string{}}
+	fail := func(check, msg string) {
+		resp.Status = "unavailable"
+		resp.Checks[check] = msg
+	}
+
+	if s.draining.Load() {
+		fail("shutdown", "draining")
+	}
+
+	if s.db == nil {
+		// in-memory store, nothing to check
+		resp.Checks["database"] = "none"
+	} else if err := s.db.PingContext(ctx); err != nil {
+		Logger(r.Context()).Warn("readyz: database ping failed", "err", err)
+		fail("database", "unreachable")
+	} else {
+		resp.Checks["database"] = "ok"
+		switch pending, err := s.pendingMigrations(ctx); {
+		case err != nil:
+			Logger(r.Context()).Warn("readyz: checking schema version", "err", err)
+			fail("migrations", "unknown")
+		case pending:
+			fail("migrations", "pending migrations")
+		default:
+			resp.Checks["migrations"] = "ok"
+		}
+	}
+
+	select {
+	case <-s.hub.Done():
+		fail("events", "stopped")
+	default:
+		resp.Checks["events"] = "ok"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if resp.Status != "ok" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+type versionResp struct {
+	buildinfo.Info
+	SchemaVersion         int64 `json:"schema_version"`
+	ExpectedSchemaVersion int64 `json:"expected_schema_version"`
+}
+
+func (s *Server) Version(w http.ResponseWriter, r *http.Request) {
+	resp := versionResp{
+		Info:                  buildinfo.Get(),
+		SchemaVersion:         -1,
+		ExpectedSchemaVersion: -1,
+	}
+	if v, err := expectedSchemaVersion(); err == nil {
+		resp.ExpectedSchemaVersion = v
+	}
+	if v, err := s.schemaVersion(r.Context()); err == nil {
+		resp.SchemaVersion = v
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// expectedSchemaVersion is the newest migration embedded in this build.
+var expectedSchemaVersion = sync.OnceValues(func() (int64, error) {
+	m, err := migrate.New(nil)
+	if err != nil {
+		return 0, err
+	}
+	return m.Latest(), nil
+})
+
+// pendingMigrations reports whether the database is behind this build.
+func (s *Server) pendingMigrations(ctx context.Context) (bool, error) {
+	v, err := s.schemaVersion(ctx)
+	if err != nil {
+		return false, err
+	}
+	latest, err := expectedSchemaVersion()
+	if err != nil {
+		return false, err
+	}
+	return v < latest, nil
+}
+
+// schemaVersion is the latest migration applied to the database.
+func (s *Server) schemaVersion(ctx context.Context) (int64, error) {
+	if s.db == nil {
+		return 0, errors.New("no database")
+	}
+	return migrate.Version(ctx, s.db.DB)
+}
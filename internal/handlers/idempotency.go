@@ -0,0 +1,182 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/nickg76/garage-backend/internal/db"
+	"github.com/nickg76/garage-backend/internal/response"
+)
+
+const (
+	maxIdempotencyKeyLen  = 255
+	maxIdempotentBodySize = maxAttachmentSize + 2<<20
+)
+
+// Idempotent makes a mutating endpoint safe to retry. When the client sends an
+// Idempotency-Key header the first response is stored and replayed for any
+// retry with the same key and body; reusing a key with a different body is a
+// 409. Keys are scoped per user, or per client IP for anonymous requests, and
+// expire after 24h.
+func (s *Server) Idempotent(next http.Handler) http.Handler {
+	return s.idempotent(next, nil)
+}
+
+// IdempotentUpload is Idempotent for attachment uploads: replays get freshly
+// signed download URLs instead of the ones stored with the response.
+func (s *Server) IdempotentUpload(next http.Handler) http.Handler {
+	return s.idempotent(next, s.resignAttachment)
+}
+
+// idempotent implements Idempotent. refresh, when set, rewrites a stored
+// successful response body before it is replayed.
+func (s *Server) idempotent(next http.Handler, refresh func([]byte) ([]byte, error)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLen {
+			writeError(w, r, http.StatusBadRequest, "idempotency_key_too_long", "idempotency key too long")
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxIdempotentBodySize))
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, "invalid_body", "invalid body")
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		scope := s.idempotencyScope(r)
+		hash := requestHash(r, body)
+
+		n, err := s.store.ClaimIdempotencyKey(r.Context(), db.ClaimIdempotencyKeyParams{
+			Key:         key,
+			UserID:      scope,
+			RequestHash: hash,
+		})
+		if err != nil {
+			writeError(w, r, http.StatusInternalServerError, "internal_error", "db error")
+			return
+		}
+		if n == 0 {
+			// the key is already taken, replay or reject
+			s.replayIdempotent(w, r, key, scope, hash, refresh)
+			return
+		}
+
+		// use a fresh context, the request one may already be cancelled
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		release := func() {
+			if err := s.store.DeleteIdempotencyKey(ctx, db.DeleteIdempotencyKeyParams{Key: key, UserID: scope}); err != nil {
+				Logger(r.Context()).Error("idempotency: releasing key", "err", err)
+			}
+		}
+		// a panicking handler must not leave the key "in progress" for a day
+		defer func() {
+			if p := recover(); p != nil {
+				release()
+				panic(p)
+			}
+		}()
+
+		rec := response.Wrap(w)
+		rec.Capture()
+		next.ServeHTTP(rec, r)
+
+		if rec.Status() >= 500 {
+			// server errors are not stored so the client can retry
+			release()
+			return
+		}
+		if err := s.store.CompleteIdempotencyKey(ctx, db.CompleteIdempotencyKeyParams{
+			Key:          key,
+			UserID:       scope,
+			StatusCode:   sql.NullInt32{Int32: int32(rec.Status()), Valid: true},
+			ContentType:  toNullString(rec.Header().Get("Content-Type")),
+			ResponseBody: rec.Body(),
+		}); err != nil {
+			Logger(r.Context()).Error("idempotency: storing response", "err", err)
+		}
+	})
+}
+
+func (s *Server) replayIdempotent(w http.ResponseWriter, r *http.Request, key, scope, hash string, refresh func([]byte) ([]byte, error)) {
+	stored, err := s.store.GetIdempotencyKey(r.Context(), db.GetIdempotencyKeyParams{Key: key, UserID: scope})
+	if errors.Is(err, sql.ErrNoRows) {
+		// expired or released between the claim and this lookup
+		writeError(w, r, http.StatusConflict, "idempotency_key_in_progress", "idempotency key in use, retry")
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "db error")
+		return
+	}
+	if stored.RequestHash != hash {
+		writeError(w, r, http.StatusConflict, "idempotency_key_reused", "idempotency key reused with a different request")
+		return
+	}
+	if !stored.StatusCode.Valid {
+		writeError(w, r, http.StatusConflict, "idempotency_key_in_progress", "request with this idempotency key is still in progress")
+		return
+	}
+	body := stored.ResponseBody
+	if refresh != nil && stored.StatusCode.Int32/100 == 2 {
+		if body, err = refresh(body); err != nil {
+			Logger(r.Context()).Error("idempotency: refreshing stored response", "err", err)
+			writeError(w, r, http.StatusInternalServerError, "internal_error", "stored response unreadable")
+			return
+		}
+	}
+	if stored.ContentType.Valid {
+		w.Header().Set("Content-Type", stored.ContentType.String)
+	}
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(int(stored.StatusCode.Int32))
+	w.Write(body)
+}
+
+// idempotencyScope is the namespace a key lives in. Anonymous clients (such as
+// /api/register) would otherwise all share one namespace and could replay each
+// other's responses by guessing keys.
+func (s *Server) idempotencyScope(r *http.Request) string {
+	if userID, _ := GetUser(r.Context()); userID != "" {
+		return userID
+	}
+	return "ip:" + s.clientIP(r)
+}
+
+// requestHash fingerprints a request so a reused key can be told apart from a retry.
+func requestHash(r *http.Request, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(r.Method + "\n" + r.URL.Path + "\n"))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// purgeIdempotencyKeys drops expired keys once an hour.
+func (s *Server) purgeIdempotencyKeys(ctx context.Context) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := s.store.DeleteExpiredIdempotencyKeys(ctx); err != nil {
+			slog.Error("idempotency: purging expired keys", "err", err)
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package handlers
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/nickg76/garage-backend/internal/auth"
+	"github.com/nickg76/garage-backend/internal/config"
+)
+
+func TestIdempotencyScope(t *testing.T) {
+	s := &Server{cfg: config.Default()}
+
+	anon := func(addr string) string {
+		r := httptest.NewRequest("POST", "/api/register", nil)
+		r.RemoteAddr = addr
+		return s.idempotencyScope(r)
+	}
+	if a, b := anon("10.0.0.1:1234"), anon("10.0.0.2:1234"); a == b {
+		t.Fatalf("anonymous clients share scope %q", a)
+	}
+	if a, b := anon("10.0.0.1:1234"), anon("10.0.0.1:5678"); a != b {
+		t.Fatalf("same client got scopes %q and %q", a, b)
+	}
+
+	r := httptest.NewRequest("POST", "/api/appointments", nil)
+	r.RemoteAddr = "10.0.0.1:1234"
+	r = r.WithContext(context.WithValue(r.Context(), auth.UserIDKey, "user-1"))
+	if got := s.idempotencyScope(r); got != "user-1" {
+		t.Fatalf("signed-in scope = %q, want the user id", got)
+	}
+}
@@ -0,0 +1,15 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// JWKS publishes the public keys session tokens are signed with so other
+// services can verify them. Verifiers should refetch when they see an
+// unknown kid, which is how they pick up a rotated key.
+func (s *Server) JWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/jwk-set+json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	json.NewEncoder(w).Encode(s.keys.JWKS())
+}
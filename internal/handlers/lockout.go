@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/nickg76/garage-backend/internal/db"
+)
+
+// recordFailedLogin counts a wrong password and, once the account reaches the
+// lockout threshold, locks it. Every failure past the threshold doubles the
+// lock, up to the configured maximum.
+func (s *Server) recordFailedLogin(ctx context.Context, user db.User) {
+	n, err := s.store.RecordFailedLogin(ctx, user.ID)
+	if err != nil {
+		Logger(ctx).Error("recording failed login", "err", err)
+		return
+	}
+	lc := s.cfg.Lockout
+	if int(n) < lc.Threshold {
+		return
+	}
+	d := lc.Base
+	for i := lc.Threshold; i < int(n) && d < lc.Max; i++ {
+		d *= 2
+	}
+	d = min(d, lc.Max)
+
+	until := time.Now().Add(d).UTC()
+	if err := s.store.LockUser(ctx, db.LockUserParams{
+		ID:          user.ID,
+		LockedUntil: sql.NullTime{Time: until, Valid: true},
+	}); err != nil {
+		Logger(ctx).Error("locking account", "err", err)
+		return
+	}
+	Logger(ctx).Warn("account locked", "locked_user_id", user.ID, "failed_logins", n, "duration", d)
+}
+
+type lockedAccountDTO struct {
+	ID           string    `json:"id"`
+	Name         string    `json:"name"`
+	Email        string    `json:"email"`
+	FailedLogins int32     `json:"failed_logins"`
+	LockedUntil  time.Time `json:"locked_until"`
+}
+
+// AdminListLockedAccounts lists accounts currently locked out after failed
+// logins.
+func (s *Server) AdminListLockedAccounts(w http.ResponseWriter, r *http.Request) {
+	rows, err := s.store.GetLockedUsers(r.Context(), sql.NullTime{Time: time.Now().UTC(), Valid: true})
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "db error")
+		return
+	}
+	out := make([]lockedAccountDTO, 0, len(rows))
+	for _, u := range rows {
+		out = append(out, lockedAccountDTO{
+			ID:           u.ID.String(),
+			Name:         u.Name,
+			Email:        u.Email,
+			FailedLogins: u.FailedLogins,
+			LockedUntil:  u.LockedUntil.Time,
+		})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
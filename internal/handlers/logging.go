@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/nickg76/garage-backend/internal/response"
+)
+
+// accessInfo is filled in by inner handlers so the access log, written after
+// they return, can see who made the request.
+type accessInfo struct {
+	userID string
+}
+
+// AccessLog writes one line per request with its outcome and latency. It must
+// run inside RequestID so the line carries the request ID.
+func (s *Server) AccessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		info := &accessInfo{}
+		rw := response.Wrap(w)
+		next.ServeHTTP(rw, r.WithContext(withAccessInfo(r.Context(), info)))
+
+		level := slog.LevelInfo
+		if rw.Status() >= 500 {
+			level = slog.LevelError
+		}
+		attrs := []slog.Attr{
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.Int("status", rw.Status()),
+			slog.Int64("bytes", rw.Bytes()),
+			slog.Duration("latency", time.Since(start)),
+			slog.String("remote_addr", r.RemoteAddr),
+		}
+		if info.userID != "" {
+			attrs = append(attrs, slog.String("user_id", info.userID))
+		}
+		Logger(r.Context()).LogAttrs(r.Context(), level, "request", attrs...)
+	})
+}
@@ -0,0 +1,38 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAccessLogRecordsOutcome(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, nil))
+	s := &Server{}
+	h := s.AccessLog(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("try later"))
+		w.(http.Flusher).Flush()
+	}))
+
+	r := httptest.NewRequest("GET", "/api/me", nil)
+	r = r.WithContext(WithLogger(r.Context(), logger))
+	h.ServeHTTP(httptest.NewRecorder(), r)
+
+	var line struct {
+		Level  string `json:"level"`
+		Status int    `json:"status"`
+		Bytes  int64  `json:"bytes"`
+		Path   string `json:"path"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatalf("%v: %s", err, buf.Bytes())
+	}
+	if line.Level != "ERROR" || line.Status != 503 || line.Bytes != 9 || line.Path != "/api/me" {
+		t.Fatalf("logged %+v", line)
+	}
+}
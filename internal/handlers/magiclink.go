@@ -0,0 +1,166 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/nickg76/garage-backend/internal/auth"
+	"github.com/nickg76/garage-backend/internal/db"
+	"github.com/nickg76/garage-backend/internal/mail"
+)
+
+// magicLinkSendTimeout bounds delivery, which happens after the response.
+const magicLinkSendTimeout = 30 * time.Second
+
+// magicLinksEnabled reports whether sign-in links can be sent.
+func (s *Server) magicLinksEnabled() bool {
+	return s.mail != nil && s.cfg.MagicLink.URL != ""
+}
+
+// purgeMagicLinks drops expired sign-in links once an hour.
+func (s *Server) purgeMagicLinks(ctx context.Context) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := s.store.DeleteExpiredMagicLinkTokens(ctx, time.Now().UTC()); err != nil {
+			slog.Error("magic links: purging expired tokens", "err", err)
+		}
+	}
+}
+
+type magicLinkReq struct {
+	Email string `json:"email"`
+}
+
+// RequestMagicLink emails a single-use sign-in link. It answers 202 whether
+// or not the address belongs to an account so it can't be used to find out
+// who is registered.
+func (s *Server) RequestMagicLink(w http.ResponseWriter, r *http.Request) {
+	if !s.magicLinksEnabled() {
+		writeError(w, r, http.StatusNotFound, "magic_links_disabled", "sign-in links are not enabled")
+		return
+	}
+	var req magicLinkReq
+	if !decodeValid(w, r, &req) {
+		return
+	}
+	ctx := r.Context()
+	user, err := s.store.GetUserByEmail(ctx, req.Email)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "db error")
+		return
+	}
+	if err == nil && !user.DisabledAt.Valid {
+		if err := s.sendMagicLink(ctx, user); err != nil {
+			writeError(w, r, http.StatusInternalServerError, "internal_error", "token error")
+			return
+		}
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// sendMagicLink stores a new token for user and mails the link in the
+// background, so response time doesn't reveal whether the account exists.
+func (s *Server) sendMagicLink(ctx context.Context, user db.User) error {
+	token, hash, err := auth.NewMagicLinkToken()
+	if err != nil {
+		return err
+	}
+	ttl := s.cfg.MagicLink.TTL
+	if err := s.store.CreateMagicLinkToken(ctx, db.CreateMagicLinkTokenParams{
+		TokenHash: hash,
+		UserID:    user.ID,
+		Email:     user.Email,
+		ExpiresAt: time.Now().UTC().Add(ttl),
+	}); err != nil {
+		return err
+	}
+
+	link, _ := url.Parse(s.cfg.MagicLink.URL)
+	q := link.Query()
+	q.Set("token", token)
+	q.Set("email", user.Email)
+	link.RawQuery = q.Encode()
+	msg := mail.Message{
+		To:      user.Email,
+		Subject: "Your sign-in link",
+		Body: strings.Join([]string{
+			"Hi " + user.Name + ",",
+			"",
+			"Use this link to sign in. It works once and expires in " + ttl.String() + ".",
+			"",
+			link.String(),
+			"",
+			"If you didn't ask for it you can ignore this email.",
+		}, "\n"),
+	}
+
+	logger := Logger(ctx)
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), magicLinkSendTimeout)
+	go func() {
+		defer cancel()
+		if err := s.mail.Send(ctx, msg); err != nil {
+			logger.Error("sending sign-in link", "err", err)
+		}
+	}()
+	return nil
+}
+
+type verifyMagicLinkReq struct {
+	Email string `json:"email"`
+	Token string `json:"token"`
+}
+
+// VerifyMagicLink exchanges the token from an emailed link for a session.
+// Like a password, the link is one factor: accounts with TOTP enabled get the
+// usual second step.
+func (s *Server) VerifyMagicLink(w http.ResponseWriter, r *http.Request) {
+	if !s.magicLinksEnabled() {
+		writeError(w, r, http.StatusNotFound, "magic_links_disabled", "sign-in links are not enabled")
+		return
+	}
+	var req verifyMagicLinkReq
+	if !decodeValid(w, r, &req) {
+		return
+	}
+	ctx := r.Context()
+	userID, err := s.store.UseMagicLinkToken(ctx, db.UseMagicLinkTokenParams{
+		TokenHash: auth.HashMagicLinkToken(req.Token),
+		Email:     req.Email,
+		ExpiresAt: time.Now().UTC(),
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		writeError(w, r, http.StatusUnauthorized, "invalid_magic_link", "sign-in link is invalid or has expired")
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "db error")
+		return
+	}
+	user, err := s.store.GetUserByID(ctx, userID)
+	// the link was sent to an address the account no longer uses
+	if err != nil || user.Email != req.Email {
+		writeError(w, r, http.StatusUnauthorized, "invalid_magic_link", "sign-in link is invalid or has expired")
+		return
+	}
+	if user.DisabledAt.Valid {
+		writeError(w, r, http.StatusForbidden, "account_disabled", "account disabled")
+		return
+	}
+	if user.TotpEnabledAt.Valid {
+		s.writeMFAChallenge(w, r, user)
+		return
+	}
+	s.startSession(w, r, user, false)
+}
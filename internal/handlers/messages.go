@@ -0,0 +1,179 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/nickg76/garage-backend/internal/db"
+)
+
+type createMessageReq struct {
+	Body string `json:"body"`
+}
+
+// appointmentForSubresource resolves /api/appointments/{id}/{sub} and checks
+// that the caller owns the appointment or is staff.
+func (s *Server) appointmentForSubresource(w http.ResponseWriter, r *http.Request, sub string) (db.Appointment, bool) {
+	userID, isAdmin := GetUser(r.Context())
+	if userID == "" {
+		writeError(w, r, http.StatusUnauthorized, "unauthorized", "unauthorized")
+		return db.Appointment{}, false
+	}
+	// Expected path: /api/appointments/{id}/{sub}
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) != 4 || parts[0] != "api" || parts[1] != "appointments" || parts[3] != sub {
+		writeError(w, r, http.StatusBadRequest, "bad_path", "bad path")
+		return db.Appointment{}, false
+	}
+
+	uid, err := uuid.Parse(parts[2])
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_id", "invalid id")
+		return db.Appointment{}, false
+	}
+
+	nu, err := toNullUUID(userID)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_user_id", "invalid user id")
+		return db.Appointment{}, false
+	}
+
+	// Ensure the appointment belongs to the user, staff can see every appointment
+	appt, err := s.store.GetAppointmentsByID(r.Context(), uid)
+	if err != nil {
+		writeError(w, r, http.StatusNotFound, "appointment_not_found", "appointment not found")
+		return db.Appointment{}, false
+	}
+	if !isAdmin && (!appt.UserID.Valid || appt.UserID.UUID.String() != nu.UUID.String()) {
+		writeError(w, r, http.StatusForbidden, "forbidden", "forbidden")
+		return db.Appointment{}, false
+	}
+	return appt, true
+}
+
+func (s *Server) GetAppointmentMessages(w http.ResponseWriter, r *http.Request) {
+	appt, ok := s.appointmentForSubresource(w, r, "messages")
+	if !ok {
+		return
+	}
+	_, isAdmin := GetUser(r.Context())
+
+	// Reading the thread marks everything the other side sent as read
+	marked, err := s.store.MarkMessagesRead(r.Context(), db.MarkMessagesReadParams{
+		AppointmentID: appt.ID,
+		SenderIsStaff: !isAdmin,
+	})
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "db error")
+		return
+	}
+
+	items, err := s.store.GetMessagesForAppointment(r.Context(), appt.ID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "db error")
+		return
+	}
+
+	// only tell the other side when something was actually unread
+	if marked > 0 {
+		ev := Event{
+			Type:        "messages_read",
+			Appointment: appt.ID.String(),
+		}
+		if isAdmin {
+			if appt.UserID.Valid {
+				s.publishToUser(r.Context(), appt.UserID.UUID.String(), ev)
+			}
+		} else {
+			s.publishToStaff(r.Context(), ev)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(toMessageSliceDTO(items))
+}
+
+func (s *Server) CreateAppointmentMessage(w http.ResponseWriter, r *http.Request) {
+	appt, ok := s.appointmentForSubresource(w, r, "messages")
+	if !ok {
+		return
+	}
+	userID, isAdmin := GetUser(r.Context())
+
+	var req createMessageReq
+	if !decodeValid(w, r, &req) {
+		return
+	}
+	nu, err := toNullUUID(userID)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_user_id", "invalid user id")
+		return
+	}
+
+	msg, err := s.store.CreateMessage(r.Context(), db.CreateMessageParams{
+		ID:            uuid.New(),
+		AppointmentID: appt.ID,
+		SenderID:      nu,
+		SenderIsStaff: isAdmin,
+		Body:          req.Body,
+	})
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "db error")
+		return
+	}
+
+	// deliver to both the customer and staff so every open tab stays in sync
+	ev := Event{
+		Type:        "message",
+		Appointment: appt.ID.String(),
+		MessageID:   msg.ID.String(),
+		Message:     msg.Body,
+	}
+	if appt.UserID.Valid {
+		s.publishToUser(r.Context(), appt.UserID.UUID.String(), ev)
+	}
+	s.publishToStaff(r.Context(), ev)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(toMessageDTO(msg))
+}
+
+// --- DTOs ---
+
+type messageDTO struct {
+	ID            string `json:"id"`
+	AppointmentID string `json:"appointment_id"`
+	SenderID      string `json:"sender_id"`
+	SenderIsStaff bool   `json:"sender_is_staff"`
+	Body          string `json:"body"`
+	ReadAt        string `json:"read_at,omitempty"`
+	CreatedAt     string `json:"created_at"`
+}
+
+func toMessageDTO(m db.Message) messageDTO {
+	dto := messageDTO{
+		ID:            m.ID.String(),
+		AppointmentID: m.AppointmentID.String(),
+		SenderID:      nullUUID(m.SenderID),
+		SenderIsStaff: m.SenderIsStaff,
+		Body:          m.Body,
+		CreatedAt:     m.CreatedAt.Format(time.RFC3339),
+	}
+	if m.ReadAt.Valid {
+		dto.ReadAt = m.ReadAt.Time.Format(time.RFC3339)
+	}
+	return dto
+}
+
+func toMessageSliceDTO(in []db.Message) []messageDTO {
+	out := make([]messageDTO, 0, len(in))
+	for _, m := range in {
+		out = append(out, toMessageDTO(m))
+	}
+	return out
+}
@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var appointmentsDesc = prometheus.NewDesc(
+	"garage_appointments",
+	"Appointments by status.",
+	[]string{"status"}, nil,
+)
+
+// registerMetrics sets up the connection pool stats and business gauges that
+// need the server's database. They live in a registry of the server's own, so
+// a second Server in the same process (as in tests) reports its own store
+// rather than colliding with the first one's collectors.
+func (s *Server) registerMetrics() {
+	s.registry = prometheus.NewRegistry()
+	s.registry.MustRegister(appointmentsCollector{s})
+	if s.db != nil {
+		s.registry.MustRegister(collectors.NewDBStatsCollector(s.db.DB, "garage"))
+	}
+}
+
+// Metrics serves /metrics: the process-wide collectors (HTTP, events, Go
+// runtime) followed by this server's.
+func (s *Server) Metrics() http.Handler {
+	gatherers := prometheus.Gatherers{prometheus.DefaultGatherer, s.registry}
+	return promhttp.InstrumentMetricHandler(prometheus.DefaultRegisterer,
+		promhttp.HandlerFor(gatherers, promhttp.HandlerOpts{}))
+}
+
+// appointmentsCollector counts bookings per status at scrape time.
+type appointmentsCollector struct {
+	s *Server
+}
+
+func (c appointmentsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- appointmentsDesc
+}
+
+func (c appointmentsCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	rows, err := c.s.store.CountAppointmentsByStatus(ctx)
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(appointmentsDesc, err)
+		return
+	}
+	for _, row := range rows {
+		ch <- prometheus.MustNewConstMetric(appointmentsDesc, prometheus.GaugeValue, float64(row.Count), row.Status)
+	}
+}
@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/nickg76/garage-backend/internal/db"
+	"github.com/nickg76/garage-backend/internal/store"
+)
+
+func TestMetricsAreServerScoped(t *testing.T) {
+	scrape := func(s *Server) string {
+		w := httptest.NewRecorder()
+		s.Metrics().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+		return w.Body.String()
+	}
+	newServer := func(bookings int) *Server {
+		st := store.NewMemory()
+		for i := 0; i < bookings; i++ {
+			if _, err := st.CreateAppointment(context.Background(), db.CreateAppointmentParams{
+				ID:       uuid.New(),
+				Datetime: time.Now().Add(time.Hour),
+				Title:    "Service",
+			}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		s := &Server{store: st}
+		s.registerMetrics()
+		return s
+	}
+
+	// before, the second server's collector was dropped as already
+	// registered and both reported the first server's store
+	one, two := newServer(1), newServer(2)
+	if got := scrape(one); !strings.Contains(got, `garage_appointments{status="pending"} 1`) {
+		t.Errorf("first server:\n%s", got)
+	}
+	got := scrape(two)
+	if !strings.Contains(got, `garage_appointments{status="pending"} 2`) {
+		t.Errorf("second server:\n%s", got)
+	}
+	// process-wide collectors are still served
+	if !strings.Contains(got, "go_goroutines") {
+		t.Error("default registry missing from /metrics")
+	}
+}
@@ -0,0 +1,255 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/nickg76/garage-backend/internal/auth"
+	"github.com/nickg76/garage-backend/internal/db"
+	"github.com/nickg76/garage-backend/internal/metrics"
+)
+
+// recoveryCodeCount is how many recovery codes are issued on enrollment.
+const recoveryCodeCount = 10
+
+type mfaChallengeResp struct {
+	MFARequired bool   `json:"mfa_required"`
+	MFAToken    string `json:"mfa_token"`
+}
+
+// writeMFAChallenge answers the password step of a two-factor login with a
+// challenge token to exchange at /api/login/mfa.
+func (s *Server) writeMFAChallenge(w http.ResponseWriter, r *http.Request, user db.User) {
+	token, err := s.keys.GenerateMFAChallenge(user.ID.String())
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "token error")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(mfaChallengeResp{MFARequired: true, MFAToken: token})
+}
+
+// sessionUser loads the account behind the authenticated request.
+func (s *Server) sessionUser(w http.ResponseWriter, r *http.Request) (db.User, bool) {
+	userID, _ := GetUser(r.Context())
+	id, err := uuid.Parse(userID)
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "unauthorized", "unauthorized")
+		return db.User{}, false
+	}
+	user, err := s.store.GetUserByID(r.Context(), id)
+	if err != nil {
+		writeError(w, r, http.StatusNotFound, "user_not_found", "user not found")
+		return db.User{}, false
+	}
+	return user, true
+}
+
+type totpEnrollResp struct {
+	Secret          string `json:"secret"`
+	ProvisioningURI string `json:"provisioning_uri"`
+}
+
+// EnrollTOTP starts TOTP enrollment by generating a new secret. It isn't
+// active until confirmed with a code from the authenticator app.
+func (s *Server) EnrollTOTP(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.sessionUser(w, r)
+	if !ok {
+		return
+	}
+	if user.TotpEnabledAt.Valid {
+		writeError(w, r, http.StatusConflict, "mfa_already_enabled", "two-factor authentication is already enabled")
+		return
+	}
+	secret, err := auth.NewTOTPSecret()
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "secret error")
+		return
+	}
+	sealed, err := auth.SealTOTPSecret(s.totpKey, user.ID.String(), secret)
+	if err != nil {
+		Logger(r.Context()).Error("mfa: sealing totp secret", "err", err)
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "secret error")
+		return
+	}
+	if err := s.store.SetTOTPSecret(r.Context(), db.SetTOTPSecretParams{
+		ID:         user.ID,
+		TotpSecret: sql.NullString{String: sealed, Valid: true},
+	}); err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "db error")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(totpEnrollResp{
+		Secret:          secret,
+		ProvisioningURI: auth.TOTPProvisioningURI(s.cfg.MFA.Issuer, user.Email, secret),
+	})
+}
+
+type totpConfirmReq struct {
+	Code string `json:"code"`
+}
+
+type totpConfirmResp struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+	// A session that has passed the second factor, so staff don't have to
+	// sign in again before using admin endpoints.
+	Token string `json:"token"`
+}
+
+// ConfirmTOTP activates enrollment once the user proves their app produces
+// valid codes, and hands out recovery codes. They are only shown here.
+func (s *Server) ConfirmTOTP(w http.ResponseWriter, r *http.Request) {
+	var req totpConfirmReq
+	if !decodeValid(w, r, &req) {
+		return
+	}
+	user, ok := s.sessionUser(w, r)
+	if !ok {
+		return
+	}
+	if user.TotpEnabledAt.Valid {
+		writeError(w, r, http.StatusConflict, "mfa_already_enabled", "two-factor authentication is already enabled")
+		return
+	}
+	if !user.TotpSecret.Valid {
+		writeError(w, r, http.StatusConflict, "mfa_not_enrolled", "start enrollment first")
+		return
+	}
+	secret, err := s.totpSecret(r, user)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "secret error")
+		return
+	}
+	step, ok := auth.VerifyTOTP(secret, req.Code, time.Now())
+	if !ok {
+		writeValidationProblem(w, r, []fieldError{{Field: "code", Message: "code is incorrect or expired"}})
+		return
+	}
+
+	codes, err := auth.NewRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "recovery code error")
+		return
+	}
+	params := make([]db.CreateRecoveryCodeParams, len(codes))
+	for i, c := range codes {
+		params[i] = db.CreateRecoveryCodeParams{
+			ID:       uuid.New(),
+			UserID:   user.ID,
+			CodeHash: auth.HashRecoveryCode(c),
+		}
+	}
+	ctx := r.Context()
+	if err := s.store.ConfirmTOTP(ctx, db.EnableTOTPParams{
+		ID:           user.ID,
+		TotpLastStep: sql.NullInt64{Int64: step, Valid: true},
+	}, params); err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "db error")
+		return
+	}
+
+	token, err := s.keys.GenerateMFAJWT(user.ID.String(), user.IsAdmin.Valid && user.IsAdmin.Bool)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "token error")
+		return
+	}
+	Logger(ctx).Info("two-factor authentication enabled")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(totpConfirmResp{RecoveryCodes: codes, Token: token})
+}
+
+type loginMFAReq struct {
+	MFAToken     string `json:"mfa_token"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+// LoginMFA is the second step of a two-factor login: it exchanges the
+// challenge token from Login plus a TOTP or recovery code for a session.
+// Wrong codes count towards the same lockout as wrong passwords.
+func (s *Server) LoginMFA(w http.ResponseWriter, r *http.Request) {
+	var req loginMFAReq
+	if !decodeValid(w, r, &req) {
+		return
+	}
+	userID, err := s.keys.ParseMFAChallenge(req.MFAToken)
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "invalid_mfa_token", "sign-in expired, start again")
+		return
+	}
+	id, err := uuid.Parse(userID)
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "invalid_mfa_token", "sign-in expired, start again")
+		return
+	}
+	ctx := r.Context()
+	user, err := s.store.GetUserByID(ctx, id)
+	if err != nil || !user.TotpEnabledAt.Valid {
+		writeError(w, r, http.StatusUnauthorized, "invalid_mfa_token", "sign-in expired, start again")
+		return
+	}
+	if user.DisabledAt.Valid {
+		writeError(w, r, http.StatusForbidden, "account_disabled", "account disabled")
+		return
+	}
+	if until := user.LockedUntil; until.Valid && time.Now().Before(until.Time) {
+		metrics.RateLimited.WithLabelValues("lockout").Inc()
+		writeTooManyRequests(w, r, time.Until(until.Time), "account_locked", "too many failed logins, try again later")
+		return
+	}
+
+	var passed bool
+	if req.Code != "" {
+		secret, err := s.totpSecret(r, user)
+		if err != nil {
+			writeError(w, r, http.StatusInternalServerError, "internal_error", "secret error")
+			return
+		}
+		if step, ok := auth.VerifyTOTP(secret, req.Code, time.Now()); ok {
+			// a code is only good once, even inside its 30 second window
+			n, err := s.store.UseTOTPStep(ctx, db.UseTOTPStepParams{
+				ID:           user.ID,
+				TotpLastStep: sql.NullInt64{Int64: step, Valid: true},
+			})
+			if err != nil {
+				writeError(w, r, http.StatusInternalServerError, "internal_error", "db error")
+				return
+			}
+			passed = n == 1
+		}
+	} else {
+		n, err := s.store.UseRecoveryCode(ctx, db.UseRecoveryCodeParams{
+			UserID:   user.ID,
+			CodeHash: auth.HashRecoveryCode(req.RecoveryCode),
+		})
+		if err != nil {
+			writeError(w, r, http.StatusInternalServerError, "internal_error", "db error")
+			return
+		}
+		passed = n == 1
+		if passed {
+			Logger(ctx).Warn("recovery code used", "user_id", user.ID)
+		}
+	}
+	if !passed {
+		s.recordFailedLogin(ctx, user)
+		writeError(w, r, http.StatusUnauthorized, "invalid_mfa_code", "invalid code")
+		return
+	}
+
+	s.startSession(w, r, user, true)
+}
+
+// totpSecret decrypts the user's stored TOTP secret.
+func (s *Server) totpSecret(r *http.Request, user db.User) (string, error) {
+	secret, err := auth.OpenTOTPSecret(s.totpKey, user.ID.String(), user.TotpSecret.String)
+	if err != nil {
+		Logger(r.Context()).Error("mfa: opening totp secret, was MFA_SECRET_KEY changed?", "err", err)
+	}
+	return secret, err
+}
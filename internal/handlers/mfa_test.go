@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"github.com/nickg76/garage-backend/internal/db"
+	"github.com/nickg76/garage-backend/internal/store"
+)
+
+func TestConfirmTOTPReplacesRecoveryCodes(t *testing.T) {
+	ctx := context.Background()
+	st := store.NewMemory()
+	user, err := st.CreateUser(ctx, db.CreateUserParams{ID: uuid.New(), Name: "Ann", Email: "ann@example.com", Phone: "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := func(hash string) db.CreateRecoveryCodeParams {
+		return db.CreateRecoveryCodeParams{ID: uuid.New(), UserID: user.ID, CodeHash: hash}
+	}
+	step := db.EnableTOTPParams{ID: user.ID, TotpLastStep: sql.NullInt64{Int64: 1, Valid: true}}
+	if err := st.ConfirmTOTP(ctx, step, []db.CreateRecoveryCodeParams{code("old")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.ConfirmTOTP(ctx, step, []db.CreateRecoveryCodeParams{code("new")}); err != nil {
+		t.Fatal(err)
+	}
+	use := func(hash string) int64 {
+		n, err := st.UseRecoveryCode(ctx, db.UseRecoveryCodeParams{UserID: user.ID, CodeHash: hash})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+	if use("old") != 0 || use("new") != 1 {
+		t.Fatal("confirming again didn't replace the recovery codes")
+	}
+	if user, _ = st.GetUserByID(ctx, user.ID); !user.TotpEnabledAt.Valid || user.TotpLastStep.Int64 != 1 {
+		t.Fatalf("user after confirm = %+v", user)
+	}
+}
@@ -0,0 +1,298 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+
+	"github.com/nickg76/garage-backend/internal/config"
+	"github.com/nickg76/garage-backend/internal/db"
+)
+
+// oidcStateTTL is how long the user has to finish signing in at the
+// provider.
+const oidcStateTTL = 10 * time.Minute
+
+// ssoStateCookie carries the state of a sign-in begun in this browser.
+// FinishSSO requires it to match, so a victim can't be made to finish a
+// sign-in an attacker started and end up in the attacker's account.
+const ssoStateCookie = "garage_sso_state"
+
+// oidcHTTPClient talks to identity providers. Discovery and key fetches
+// outlive the request that triggered them, so the timeout lives here.
+var oidcHTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+// ssoProvider is a configured identity provider. Discovery happens on first
+// use and is retried until it succeeds, so a provider being down doesn't
+// stop the server from starting.
+type ssoProvider struct {
+	cfg config.OIDCProvider
+
+	mu       sync.Mutex
+	provider *oidc.Provider
+}
+
+func newSSOProviders(cfg config.OIDCConfig) map[string]*ssoProvider {
+	out := map[string]*ssoProvider{}
+	for _, p := range cfg.Providers {
+		if p.Name == "" {
+			p.Name = p.ID
+		}
+		out[p.ID] = &ssoProvider{cfg: p}
+	}
+	return out
+}
+
+func (p *ssoProvider) discover() (*oidc.Provider, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.provider == nil {
+		pr, err := oidc.NewProvider(oidc.ClientContext(context.Background(), oidcHTTPClient), p.cfg.Issuer)
+		if err != nil {
+			return nil, err
+		}
+		p.provider = pr
+	}
+	return p.provider, nil
+}
+
+func (p *ssoProvider) oauth2Config(pr *oidc.Provider, redirectURL string) *oauth2.Config {
+	return &oauth2.Config{
+		ClientID:     p.cfg.ClientID,
+		ClientSecret: p.cfg.ClientSecret,
+		Endpoint:     pr.Endpoint(),
+		RedirectURL:  redirectURL,
+		Scopes:       append([]string{oidc.ScopeOpenID, "email", "profile"}, p.cfg.Scopes...),
+	}
+}
+
+// purgeOIDCStates drops abandoned sign-ins once an hour.
+func (s *Server) purgeOIDCStates(ctx context.Context) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := s.store.DeleteExpiredOIDCStates(ctx, time.Now().UTC()); err != nil {
+			slog.Error("oidc: purging expired states", "err", err)
+		}
+	}
+}
+
+type ssoProviderResp struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// ListSSOProviders returns the providers to offer on the sign-in page.
+func (s *Server) ListSSOProviders(w http.ResponseWriter, r *http.Request) {
+	out := make([]ssoProviderResp, 0, len(s.sso))
+	for _, p := range s.cfg.OIDC.Providers {
+		out = append(out, ssoProviderResp{ID: p.ID, Name: s.sso[p.ID].cfg.Name})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
+
+type beginSSOResp struct {
+	AuthorizationURL string `json:"authorization_url"`
+}
+
+// BeginSSO starts an authorization code flow with PKCE. The frontend sends
+// the browser to the returned URL. The state is also set as a cookie that
+// FinishSSO checks, binding the sign-in to this browser.
+func (s *Server) BeginSSO(w http.ResponseWriter, r *http.Request) {
+	p, ok := s.sso[r.PathValue("provider")]
+	if !ok {
+		writeError(w, r, http.StatusNotFound, "unknown_provider", "unknown sign-in provider")
+		return
+	}
+	pr, err := p.discover()
+	if err != nil {
+		Logger(r.Context()).Error("oidc discovery", "provider", p.cfg.ID, "err", err)
+		writeError(w, r, http.StatusBadGateway, "provider_unavailable", "sign-in provider is unavailable")
+		return
+	}
+	state, err1 := randomToken()
+	nonce, err2 := randomToken()
+	if err := errors.Join(err1, err2); err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "token error")
+		return
+	}
+	verifier := oauth2.GenerateVerifier()
+	if err := s.store.CreateOIDCState(r.Context(), db.CreateOIDCStateParams{
+		State:        state,
+		Provider:     p.cfg.ID,
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+		ExpiresAt:    time.Now().UTC().Add(oidcStateTTL),
+	}); err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "db error")
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     ssoStateCookie,
+		Value:    state,
+		Path:     "/api/login/oidc",
+		MaxAge:   int(oidcStateTTL / time.Second),
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(s.cfg.OIDC.RedirectURL, "https:"),
+		SameSite: http.SameSiteLaxMode,
+	})
+	u := p.oauth2Config(pr, s.cfg.OIDC.RedirectURL).AuthCodeURL(state,
+		oidc.Nonce(nonce), oauth2.S256ChallengeOption(verifier))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(beginSSOResp{AuthorizationURL: u})
+}
+
+type finishSSOReq struct {
+	State string `json:"state"`
+	Code  string `json:"code"`
+}
+
+// idTokenClaims are the ID token claims used for account linking.
+// email_verified is a string in some providers' tokens.
+type idTokenClaims struct {
+	Email         string `json:"email"`
+	EmailVerified any    `json:"email_verified"`
+}
+
+// FinishSSO exchanges the code the provider redirected back with for a
+// session. The first sign-in with a provider account links it to the user
+// with the same verified email; later ones go by the provider's subject, so
+// changing the address at the provider doesn't lose the link.
+func (s *Server) FinishSSO(w http.ResponseWriter, r *http.Request) {
+	var req finishSSOReq
+	if !decodeValid(w, r, &req) {
+		return
+	}
+	// the state is single use either way, so the cookie can go
+	http.SetCookie(w, &http.Cookie{Name: ssoStateCookie, Path: "/api/login/oidc", MaxAge: -1})
+	if c, err := r.Cookie(ssoStateCookie); err != nil || subtle.ConstantTimeCompare([]byte(c.Value), []byte(req.State)) != 1 {
+		writeError(w, r, http.StatusUnauthorized, "invalid_sso_state", "sign-in expired, start again")
+		return
+	}
+	ctx := r.Context()
+	st, err := s.store.TakeOIDCState(ctx, req.State)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "db error")
+		return
+	}
+	p, ok := s.sso[st.Provider]
+	if err != nil || !ok || time.Now().UTC().After(st.ExpiresAt) {
+		writeError(w, r, http.StatusUnauthorized, "invalid_sso_state", "sign-in expired, start again")
+		return
+	}
+	pr, err := p.discover()
+	if err != nil {
+		Logger(ctx).Error("oidc discovery", "provider", p.cfg.ID, "err", err)
+		writeError(w, r, http.StatusBadGateway, "provider_unavailable", "sign-in provider is unavailable")
+		return
+	}
+
+	tok, err := p.oauth2Config(pr, s.cfg.OIDC.RedirectURL).Exchange(
+		oidc.ClientContext(ctx, oidcHTTPClient), req.Code, oauth2.VerifierOption(st.CodeVerifier))
+	if err != nil {
+		Logger(ctx).Warn("oidc code exchange", "provider", p.cfg.ID, "err", err)
+		writeError(w, r, http.StatusUnauthorized, "sso_failed", "sign-in with the provider failed")
+		return
+	}
+	rawID, _ := tok.Extra("id_token").(string)
+	idToken, err := pr.Verifier(&oidc.Config{ClientID: p.cfg.ClientID}).Verify(ctx, rawID)
+	if err != nil || subtle.ConstantTimeCompare([]byte(idToken.Nonce), []byte(st.Nonce)) != 1 {
+		Logger(ctx).Warn("oidc id token rejected", "provider", p.cfg.ID, "err", err)
+		writeError(w, r, http.StatusUnauthorized, "sso_failed", "sign-in with the provider failed")
+		return
+	}
+	var claims idTokenClaims
+	if err := idToken.Claims(&claims); err != nil {
+		writeError(w, r, http.StatusUnauthorized, "sso_failed", "sign-in with the provider failed")
+		return
+	}
+
+	user, ok := s.ssoUser(w, r, p, idToken.Subject, claims)
+	if !ok {
+		return
+	}
+	if user.DisabledAt.Valid {
+		writeError(w, r, http.StatusForbidden, "account_disabled", "account disabled")
+		return
+	}
+	if err := s.store.TouchUserIdentity(ctx, db.TouchUserIdentityParams{Provider: p.cfg.ID, Subject: idToken.Subject}); err != nil {
+		Logger(ctx).Error("recording sso sign-in", "err", err)
+	}
+	if !p.cfg.MFA && user.TotpEnabledAt.Valid {
+		s.writeMFAChallenge(w, r, user)
+		return
+	}
+	s.startSession(w, r, user, p.cfg.MFA)
+}
+
+// ssoUser finds the account a provider identity belongs to, linking it by
+// email on first use.
+func (s *Server) ssoUser(w http.ResponseWriter, r *http.Request, p *ssoProvider, subject string, claims idTokenClaims) (db.User, bool) {
+	ctx := r.Context()
+	ident, err := s.store.GetUserIdentity(ctx, db.GetUserIdentityParams{Provider: p.cfg.ID, Subject: subject})
+	if err == nil {
+		user, err := s.store.GetUserByID(ctx, ident.UserID)
+		if err != nil {
+			writeError(w, r, http.StatusInternalServerError, "internal_error", "db error")
+			return db.User{}, false
+		}
+		return user, true
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "db error")
+		return db.User{}, false
+	}
+
+	verified := claims.EmailVerified == true || claims.EmailVerified == "true" || p.cfg.TrustEmail
+	if claims.Email == "" || !verified {
+		writeError(w, r, http.StatusForbidden, "sso_email_unverified", "the provider did not confirm your email address")
+		return db.User{}, false
+	}
+	user, err := s.store.GetUserByEmail(ctx, claims.Email)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeError(w, r, http.StatusForbidden, "sso_no_account", "no account uses this email, register first")
+		return db.User{}, false
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "db error")
+		return db.User{}, false
+	}
+	if err := s.store.CreateUserIdentity(ctx, db.CreateUserIdentityParams{
+		Provider: p.cfg.ID,
+		Subject:  subject,
+		UserID:   user.ID,
+		Email:    claims.Email,
+	}); err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "db error")
+		return db.User{}, false
+	}
+	Logger(ctx).Info("sso identity linked", "provider", p.cfg.ID, "user_id", user.ID)
+	return user, true
+}
+
+// randomToken returns 256 random bits, base64url encoded.
+func randomToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
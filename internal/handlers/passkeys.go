@@ -0,0 +1,391 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/google/uuid"
+
+	"github.com/nickg76/garage-backend/internal/config"
+	"github.com/nickg76/garage-backend/internal/db"
+)
+
+// passkeyChallengeTTL is how long a ceremony may take between begin and
+// finish.
+const passkeyChallengeTTL = 5 * time.Minute
+
+// newWebAuthn configures the relying party, or returns nil when passkeys are
+// turned off.
+func newWebAuthn(cfg config.WebAuthnConfig) (*webauthn.WebAuthn, error) {
+	if cfg.RPID == "" {
+		return nil, nil
+	}
+	return webauthn.New(&webauthn.Config{
+		RPID:          cfg.RPID,
+		RPDisplayName: cfg.RPName,
+		RPOrigins:     cfg.Origins,
+	})
+}
+
+// passkeyUser adapts an account to webauthn.User. The user handle is the
+// account ID, so a discoverable login leads straight back to the row.
+type passkeyUser struct {
+	db.User
+	credentials []webauthn.Credential
+}
+
+func (u passkeyUser) WebAuthnID() []byte                         { return u.ID[:] }
+func (u passkeyUser) WebAuthnName() string                       { return u.Email }
+func (u passkeyUser) WebAuthnDisplayName() string                { return u.Name }
+func (u passkeyUser) WebAuthnCredentials() []webauthn.Credential { return u.credentials }
+
+func (s *Server) passkeyUser(ctx context.Context, user db.User) (passkeyUser, error) {
+	rows, err := s.store.GetWebAuthnCredentialsForUser(ctx, user.ID)
+	if err != nil {
+		return passkeyUser{}, err
+	}
+	pu := passkeyUser{User: user}
+	for _, c := range rows {
+		pu.credentials = append(pu.credentials, toWebAuthnCredential(c))
+	}
+	return pu, nil
+}
+
+func toWebAuthnCredential(c db.WebauthnCredential) webauthn.Credential {
+	var transports []protocol.AuthenticatorTransport
+	for _, t := range strings.Split(c.Transports, ",") {
+		if t != "" {
+			transports = append(transports, protocol.AuthenticatorTransport(t))
+		}
+	}
+	var flags protocol.AuthenticatorFlags
+	if c.BackupEligible {
+		flags |= protocol.FlagBackupEligible
+	}
+	if c.BackupState {
+		flags |= protocol.FlagBackupState
+	}
+	return webauthn.Credential{
+		ID:              c.CredentialID,
+		PublicKey:       c.PublicKey,
+		AttestationType: c.AttestationType,
+		Transport:       transports,
+		Flags:           webauthn.NewCredentialFlags(flags),
+		Authenticator: webauthn.Authenticator{
+			AAGUID:    c.Aaguid,
+			SignCount: uint32(c.SignCount),
+		},
+	}
+}
+
+// passkeysEnabled writes a 404 when no relying party is configured.
+func (s *Server) passkeysEnabled(w http.ResponseWriter, r *http.Request) bool {
+	if s.webauthn == nil {
+		writeError(w, r, http.StatusNotFound, "passkeys_disabled", "passkey sign-in is not enabled")
+		return false
+	}
+	return true
+}
+
+// saveCeremony keeps the server side of a ceremony until the browser comes
+// back with the authenticator's response. It is keyed by the challenge, which
+// the response carries in its client data.
+func (s *Server) saveCeremony(ctx context.Context, session *webauthn.SessionData, userID uuid.NullUUID) error {
+	b, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+	return s.store.CreateWebAuthnChallenge(ctx, db.CreateWebAuthnChallengeParams{
+		Challenge: session.Challenge,
+		UserID:    userID,
+		Session:   b,
+		ExpiresAt: time.Now().Add(passkeyChallengeTTL).UTC(),
+	})
+}
+
+var errCeremonyExpired = errors.New("passkey ceremony expired")
+
+// takeCeremony removes and returns the ceremony for challenge, so each one can
+// be finished only once.
+func (s *Server) takeCeremony(ctx context.Context, challenge string) (webauthn.SessionData, uuid.NullUUID, error) {
+	var session webauthn.SessionData
+	row, err := s.store.TakeWebAuthnChallenge(ctx, challenge)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && time.Now().After(row.ExpiresAt)) {
+		return session, uuid.NullUUID{}, errCeremonyExpired
+	}
+	if err != nil {
+		return session, uuid.NullUUID{}, err
+	}
+	if err := json.Unmarshal(row.Session, &session); err != nil {
+		return session, uuid.NullUUID{}, err
+	}
+	return session, row.UserID, nil
+}
+
+// purgeWebAuthnChallenges drops abandoned ceremonies once an hour.
+func (s *Server) purgeWebAuthnChallenges(ctx context.Context) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := s.store.DeleteExpiredWebAuthnChallenges(ctx, time.Now().UTC()); err != nil {
+			slog.Error("webauthn: purging expired challenges", "err", err)
+		}
+	}
+}
+
+// BeginPasskeyRegistration returns the options for navigator.credentials.create.
+func (s *Server) BeginPasskeyRegistration(w http.ResponseWriter, r *http.Request) {
+	if !s.passkeysEnabled(w, r) {
+		return
+	}
+	user, ok := s.sessionUser(w, r)
+	if !ok {
+		return
+	}
+	ctx := r.Context()
+	pu, err := s.passkeyUser(ctx, user)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "db error")
+		return
+	}
+	creation, session, err := s.webauthn.BeginRegistration(pu,
+		// discoverable, so sign-in doesn't need the email first
+		webauthn.WithResidentKeyRequirement(protocol.ResidentKeyRequirementRequired),
+		webauthn.WithExclusions(webauthn.Credentials(pu.credentials).CredentialDescriptors()),
+	)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "webauthn error")
+		return
+	}
+	if err := s.saveCeremony(ctx, session, uuid.NullUUID{UUID: user.ID, Valid: true}); err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "db error")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(creation)
+}
+
+type passkeyDTO struct {
+	ID         string     `json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	// Synced passkeys live in a password manager or cloud keychain rather
+	// than on a single device.
+	Synced bool `json:"synced"`
+}
+
+func toPasskeyDTO(c db.WebauthnCredential) passkeyDTO {
+	dto := passkeyDTO{ID: c.ID.String(), CreatedAt: c.CreatedAt, Synced: c.BackupState}
+	if c.LastUsedAt.Valid {
+		dto.LastUsedAt = &c.LastUsedAt.Time
+	}
+	return dto
+}
+
+// FinishPasskeyRegistration verifies the authenticator's attestation and
+// stores the new credential. The body is the PublicKeyCredential from the
+// browser, serialised as JSON.
+func (s *Server) FinishPasskeyRegistration(w http.ResponseWriter, r *http.Request) {
+	if !s.passkeysEnabled(w, r) {
+		return
+	}
+	user, ok := s.sessionUser(w, r)
+	if !ok {
+		return
+	}
+	parsed, err := protocol.ParseCredentialCreationResponseBody(http.MaxBytesReader(w, r.Body, maxJSONBodySize))
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_passkey", "malformed passkey response")
+		return
+	}
+	ctx := r.Context()
+	session, owner, err := s.takeCeremony(ctx, parsed.Response.CollectedClientData.Challenge)
+	if errors.Is(err, errCeremonyExpired) || (err == nil && owner.UUID != user.ID) {
+		writeError(w, r, http.StatusBadRequest, "passkey_challenge_expired", "passkey request expired, start again")
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "db error")
+		return
+	}
+	pu, err := s.passkeyUser(ctx, user)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "db error")
+		return
+	}
+	cred, err := s.webauthn.CreateCredential(pu, session, parsed)
+	if err != nil {
+		Logger(ctx).Info("passkey registration rejected", "err", err)
+		writeError(w, r, http.StatusBadRequest, "invalid_passkey", "passkey could not be verified")
+		return
+	}
+
+	transports := make([]string, len(cred.Transport))
+	for i, t := range cred.Transport {
+		transports[i] = string(t)
+	}
+	row, err := s.store.CreateWebAuthnCredential(ctx, db.CreateWebAuthnCredentialParams{
+		ID:              uuid.New(),
+		UserID:          user.ID,
+		CredentialID:    cred.ID,
+		PublicKey:       cred.PublicKey,
+		AttestationType: cred.AttestationType,
+		Transports:      strings.Join(transports, ","),
+		Aaguid:          cred.Authenticator.AAGUID,
+		SignCount:       int64(cred.Authenticator.SignCount),
+		BackupEligible:  cred.Flags.BackupEligible,
+		BackupState:     cred.Flags.BackupState,
+	})
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "db error")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(toPasskeyDTO(row))
+}
+
+// ListPasskeys lists the caller's registered passkeys.
+func (s *Server) ListPasskeys(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.sessionUser(w, r)
+	if !ok {
+		return
+	}
+	rows, err := s.store.GetWebAuthnCredentialsForUser(r.Context(), user.ID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "db error")
+		return
+	}
+	out := make([]passkeyDTO, 0, len(rows))
+	for _, c := range rows {
+		out = append(out, toPasskeyDTO(c))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
+
+// DeletePasskey removes one of the caller's passkeys.
+func (s *Server) DeletePasskey(w http.ResponseWriter, r *http.Request) {
+	userID, _ := GetUser(r.Context())
+	uid, err := uuid.Parse(userID)
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "unauthorized", "unauthorized")
+		return
+	}
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		writeError(w, r, http.StatusNotFound, "passkey_not_found", "passkey not found")
+		return
+	}
+	n, err := s.store.DeleteWebAuthnCredential(r.Context(), db.DeleteWebAuthnCredentialParams{ID: id, UserID: uid})
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "db error")
+		return
+	}
+	if n == 0 {
+		writeError(w, r, http.StatusNotFound, "passkey_not_found", "passkey not found")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// BeginPasskeyLogin returns the options for navigator.credentials.get. No
+// email is needed, the authenticator offers the passkeys it holds for us.
+func (s *Server) BeginPasskeyLogin(w http.ResponseWriter, r *http.Request) {
+	if !s.passkeysEnabled(w, r) {
+		return
+	}
+	assertion, session, err := s.webauthn.BeginDiscoverableLogin()
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "webauthn error")
+		return
+	}
+	if err := s.saveCeremony(r.Context(), session, uuid.NullUUID{}); err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "db error")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(assertion)
+}
+
+// FinishPasskeyLogin verifies an assertion and signs the owner in. A passkey
+// unlocked with a PIN or biometric counts as two factors; otherwise accounts
+// with TOTP still get the MFA challenge.
+func (s *Server) FinishPasskeyLogin(w http.ResponseWriter, r *http.Request) {
+	if !s.passkeysEnabled(w, r) {
+		return
+	}
+	parsed, err := protocol.ParseCredentialRequestResponseBody(http.MaxBytesReader(w, r.Body, maxJSONBodySize))
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_passkey", "malformed passkey response")
+		return
+	}
+	ctx := r.Context()
+	session, owner, err := s.takeCeremony(ctx, parsed.Response.CollectedClientData.Challenge)
+	if errors.Is(err, errCeremonyExpired) || (err == nil && owner.Valid) {
+		writeError(w, r, http.StatusBadRequest, "passkey_challenge_expired", "passkey request expired, start again")
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "db error")
+		return
+	}
+
+	var user db.User
+	lookup := func(rawID, userHandle []byte) (webauthn.User, error) {
+		id, err := uuid.FromBytes(userHandle)
+		if err != nil {
+			return nil, err
+		}
+		if user, err = s.store.GetUserByID(ctx, id); err != nil {
+			return nil, err
+		}
+		return s.passkeyUser(ctx, user)
+	}
+	_, cred, err := s.webauthn.ValidatePasskeyLogin(lookup, session, parsed)
+	if err != nil {
+		Logger(ctx).Info("passkey login rejected", "err", err)
+		writeError(w, r, http.StatusUnauthorized, "invalid_passkey", "passkey not recognised")
+		return
+	}
+	if cred.Authenticator.CloneWarning {
+		// the signature counter went backwards: two copies of the key exist
+		Logger(ctx).Warn("passkey sign counter did not increase, possible cloned authenticator",
+			"passkey_user_id", user.ID, "sign_count", cred.Authenticator.SignCount)
+		writeError(w, r, http.StatusUnauthorized, "invalid_passkey", "passkey not recognised")
+		return
+	}
+	if err := s.store.UpdateWebAuthnCredentialUse(ctx, db.UpdateWebAuthnCredentialUseParams{
+		CredentialID: cred.ID,
+		SignCount:    int64(cred.Authenticator.SignCount),
+		BackupState:  cred.Flags.BackupState,
+	}); err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "db error")
+		return
+	}
+
+	// A lockout guards against password guessing, which a passkey isn't
+	// exposed to, so it doesn't apply here. Signing in clears it.
+	if user.DisabledAt.Valid {
+		writeError(w, r, http.StatusForbidden, "account_disabled", "account disabled")
+		return
+	}
+	if !cred.Flags.UserVerified && user.TotpEnabledAt.Valid {
+		s.writeMFAChallenge(w, r, user)
+		return
+	}
+	s.startSession(w, r, user, cred.Flags.UserVerified)
+}
@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/nickg76/garage-backend/internal/tracing"
+)
+
+// problem is an RFC 7807 problem document. Code is a stable machine-readable
+// identifier clients can switch on instead of matching Detail.
+type problem struct {
+	Type      string       `json:"type"`
+	Title     string       `json:"title"`
+	Status    int          `json:"status"`
+	Code      string       `json:"code"`
+	Detail    string       `json:"detail,omitempty"`
+	Instance  string       `json:"instance,omitempty"`
+	RequestID string       `json:"request_id,omitempty"`
+	TraceID   string       `json:"trace_id,omitempty"`
+	Errors    []fieldError `json:"errors,omitempty"`
+}
+
+// fieldError describes why a single request field was rejected.
+type fieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+func writeProblem(w http.ResponseWriter, r *http.Request, p problem) {
+	if p.Type == "" {
+		p.Type = "/problems/" + p.Code
+	}
+	if p.Title == "" {
+		p.Title = http.StatusText(p.Status)
+	}
+	p.Instance = r.URL.Path
+	p.RequestID = GetRequestID(r.Context())
+	p.TraceID = tracing.TraceID(r.Context())
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(p.Status)
+	json.NewEncoder(w).Encode(p)
+}
+
+// writeError is the problem+json replacement for http.Error.
+func writeError(w http.ResponseWriter, r *http.Request, status int, code, detail string) {
+	writeProblem(w, r, problem{
+		Status: status,
+		Code:   code,
+		Detail: detail,
+	})
+}
+
+// NotFound answers API requests no route matched.
+func NotFound(w http.ResponseWriter, r *http.Request) {
+	writeError(w, r, http.StatusNotFound, "not_found", "no such API endpoint")
+}
+
+// MethodNotAllowed answers API requests for a path that exists under other
+// methods. The caller sets the Allow header.
+func MethodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	writeError(w, r, http.StatusMethodNotAllowed, "method_not_allowed", "method not allowed for this endpoint")
+}
+
+func writeValidationProblem(w http.ResponseWriter, r *http.Request, errs []fieldError) {
+	writeProblem(w, r, problem{
+		Title:  "Validation failed",
+		Status: http.StatusUnprocessableEntity,
+		Code:   "validation_failed",
+		Errors: errs,
+	})
+}
@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/google/uuid"
+
+	"github.com/nickg76/garage-backend/internal/tracing"
+)
+
+const maxRequestIDLen = 128
+
+// RequestID tags every request with an ID, reusing the caller's X-Request-ID
+// when it looks sane, and echoes it back so errors can be traced. The request
+// logger carries the ID, and the trace ID when the request is traced, on
+// every line.
+func (s *Server) RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID(id) {
+			id = uuid.NewString()
+		}
+		w.Header().Set("X-Request-ID", id)
+		ctx := WithRequestID(r.Context(), id)
+		logger := slog.Default().With("request_id", id)
+		if traceID := tracing.TraceID(ctx); traceID != "" {
+			logger = logger.With("trace_id", traceID)
+		}
+		ctx = WithLogger(ctx, logger)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// validRequestID accepts IDs made of letters, digits and -_.:/ only. The ID
+// ends up in logs, headers and problem responses, so anything that could
+// forge log lines or smuggle markup is replaced with a fresh one.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLen {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		c := id[i]
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':', c == '/':
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestRequestIDKeepsSaneIDs(t *testing.T) {
+	s := &Server{}
+	for _, id := range []string{"abc-123", "req_42", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", "lb:1.2/3"} {
+		if got := requestIDFor(s, id); got != id {
+			t.Errorf("X-Request-ID %q replaced with %q", id, got)
+		}
+	}
+}
+
+func TestRequestIDReplacesUnsafeIDs(t *testing.T) {
+	s := &Server{}
+	for _, id := range []string{
+		"",
+		strings.Repeat("a", maxRequestIDLen+1),
+		"abc\nlevel=ERROR msg=forged",
+		"<script>alert(1)</script>",
+		"has space",
+		"quote\"d",
+		"ünïcode",
+	} {
+		got := requestIDFor(s, id)
+		if got == id {
+			t.Errorf("unsafe X-Request-ID %q was kept", id)
+		}
+		if _, err := uuid.Parse(got); err != nil {
+			t.Errorf("replacement for %q is %q, want a UUID", id, got)
+		}
+	}
+}
+
+// requestIDFor runs a request carrying id through RequestID and returns the
+// ID the handler saw, checking it is also echoed back.
+func requestIDFor(s *Server, id string) string {
+	var seen string
+	h := s.RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = GetRequestID(r.Context())
+	}))
+	r := httptest.NewRequest("GET", "/api/me", nil)
+	r.Header.Set("X-Request-ID", id)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if echoed := w.Header().Get("X-Request-ID"); echoed != seen {
+		return "echoed " + echoed + " but saw " + seen
+	}
+	return seen
+}
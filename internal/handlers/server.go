@@ -2,74 +2,316 @@
 package handlers
 
 import (
+	"context"
 	"database/sql"
-	"log"
+	"errors"
+	"fmt"
+	"log/slog"
 	"net/http"
 	"os"
 	"strings"
+	"sync"
+	"sync/atomic"
 
+	"github.com/go-webauthn/webauthn/webauthn"
 	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
 	_ "github.com/lib/pq"
-	"github.com/joho/godotenv"
+	"github.com/prometheus/client_golang/prometheus"
 
 	"github.com/nickg76/garage-backend/internal/auth"
-	"github.com/nickg76/garage-backend/internal/db"
+	"github.com/nickg76/garage-backend/internal/config"
+	"github.com/nickg76/garage-backend/internal/mail"
+	"github.com/nickg76/garage-backend/internal/ratelimit"
+	"github.com/nickg76/garage-backend/internal/storage"
+	"github.com/nickg76/garage-backend/internal/store"
 )
 
 type Server struct {
+	cfg		*config.Config
 	db 		*sqlx.DB
-	queries *db.Queries
+	store	store.Store
+	limiter ratelimit.Limiter
 	hub 	*EventHub
+	// Session token and signed URL keys
+	keys	*auth.Keys
+	files	storage.Storage
+	// Outgoing email, nil when no mail backend is configured
+	mail	mail.Sender
+	// OpenID Connect providers by ID
+	sso		map[string]*ssoProvider
+	// Key TOTP secrets are encrypted with, see auth.SealTOTPSecret
+	totpKey	[]byte
+	// WebAuthn relying party, nil when passkeys are disabled
+	webauthn *webauthn.WebAuthn
+	// Collectors that read this server's store, served by Metrics
+	registry *prometheus.Registry
+	draining atomic.Bool
+	// Stops the background purges, see Close
+	stop	context.CancelFunc
+	purges	sync.WaitGroup
 }
 
-func NewServer() *Server {
-	err := godotenv.Load()
+// Deps are the collaborators a Server is built from. NewServer connects to
+// the real ones; tests can pass a store.Memory and a local storage directory
+// to NewServerWith instead.
+type Deps struct {
+	// Everything the handlers persist. Required.
+	Store store.Store
+	// Attachment blobs. Required.
+	Files storage.Storage
+	// Connection pool behind Store when it is Postgres, used by health checks
+	// and pool metrics. Optional.
+	DB *sqlx.DB
+	// Buckets for RateLimit. Defaults to in-memory ones.
+	Limiter ratelimit.Limiter
+	// Outgoing email. Optional, magic links are disabled without it.
+	Mail mail.Sender
+}
+
+// NewServer connects to the database, attachment storage and mail backend
+// named by cfg.
+func NewServer(cfg *config.Config) (*Server, error) {
+	conn, err := sqlx.Connect("postgres", cfg.DatabaseURL)
+	if err != nil {
+		return nil, fmt.Errorf("database: %w", err)
+	}
+	files, err := newStorage(cfg.Storage)
 	if err != nil {
-		log.Println("No .env file found, relying on environment variables")
+		conn.Close()
+		return nil, fmt.Errorf("storage: %w", err)
 	}
-	dsn := os.Getenv("DATABASE_URL")
-	if dsn == "" {
-		log.Fatal("DATABASE_URL not set")
+	sender, err := newMailer(cfg.Mail)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("mail: %w", err)
+	}
+	var limiter ratelimit.Limiter = ratelimit.NewMemory()
+	if cfg.RateLimit.Backend == "postgres" {
+		limiter = ratelimit.NewPostgres(conn.DB)
 	}
-	conn := sqlx.MustConnect("postgres", dsn)
-	return &Server{
-		db:		 conn,
-		queries: db.New(conn.DB),
+	s, err := NewServerWith(cfg, Deps{
+		Store:   store.NewPostgres(conn.DB),
+		Files:   files,
+		DB:      conn,
+		Limiter: limiter,
+		Mail:    sender,
+	})
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// NewServerWith builds a Server from explicit dependencies.
+func NewServerWith(cfg *config.Config, deps Deps) (*Server, error) {
+	keys, err := newKeys(cfg.JWTSecret, cfg.JWT)
+	if err != nil {
+		return nil, fmt.Errorf("jwt keys: %w", err)
+	}
+	s := &Server{
+		cfg:	 cfg,
+		keys:	 keys,
+		db:		 deps.DB,
+		store:	 deps.Store,
+		limiter: deps.Limiter,
 		hub:	 NewEventHub(),
+		files:	 deps.Files,
+		mail:	 deps.Mail,
+		sso:	 newSSOProviders(cfg.OIDC),
+		totpKey: cfg.MFA.Key(),
+	}
+	if s.limiter == nil {
+		s.limiter = ratelimit.NewMemory()
+	}
+	s.registerMetrics()
+	ctx, stop := context.WithCancel(context.Background())
+	s.stop = stop
+	s.background(ctx, s.purgeIdempotencyKeys)
+	wa, err := newWebAuthn(cfg.WebAuthn)
+	if err != nil {
+		slog.Error("webauthn: passkeys disabled", "err", err)
+	}
+	if s.webauthn = wa; wa != nil {
+		s.background(ctx, s.purgeWebAuthnChallenges)
+	}
+	if s.magicLinksEnabled() {
+		s.background(ctx, s.purgeMagicLinks)
+	}
+	if len(s.sso) > 0 {
+		s.background(ctx, s.purgeOIDCStates)
 	}
+	return s, nil
+}
+
+// background runs a purge loop until Close.
+func (s *Server) background(ctx context.Context, loop func(context.Context)) {
+	s.purges.Add(1)
+	go func() {
+		defer s.purges.Done()
+		loop(ctx)
+	}()
+}
+
+// newStorage picks the attachment backend, s3 for any S3-compatible service,
+// otherwise files on local disk.
+func newStorage(cfg config.StorageConfig) (storage.Storage, error) {
+	if cfg.Backend == "s3" {
+		return storage.NewS3(storage.S3Config{
+			Endpoint:  cfg.S3.Endpoint,
+			Region:    cfg.S3.Region,
+			Bucket:    cfg.S3.Bucket,
+			AccessKey: cfg.S3.AccessKey,
+			SecretKey: cfg.S3.SecretKey,
+		})
+	}
+	return storage.NewLocal(cfg.Dir)
+}
+
+// newKeys loads the token signing keys. Without a signing key file tokens
+// stay HS256 with JWT_SECRET.
+func newKeys(secret string, cfg config.JWTConfig) (*auth.Keys, error) {
+	keys := &auth.Keys{Secret: []byte(secret), Issuer: cfg.Issuer, Audience: cfg.Audience}
+	keys.AcceptLegacyUntil(cfg.LegacyUntil)
+	if cfg.SigningKeyFile == "" {
+		return keys, nil
+	}
+	b, err := os.ReadFile(cfg.SigningKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	if err := keys.SetSigningKey(b); err != nil {
+		return nil, fmt.Errorf("%s: %w", cfg.SigningKeyFile, err)
+	}
+	for _, path := range cfg.RetiredKeyFiles {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := keys.AddRetiredKey(b, cfg.RetiredUntil); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	return keys, nil
+}
+
+// newMailer picks the outgoing email backend. It returns nil when none is
+// configured.
+func newMailer(cfg config.MailConfig) (mail.Sender, error) {
+	switch cfg.Backend {
+	case "smtp":
+		return mail.NewSMTP(mail.SMTPConfig{
+			Host:     cfg.SMTP.Host,
+			Port:     cfg.SMTP.Port,
+			Username: cfg.SMTP.Username,
+			Password: cfg.SMTP.Password,
+			From:     cfg.From,
+		})
+	case "log":
+		return mail.Log{}, nil
+	}
+	return nil, nil
+}
+
+// BeginDrain makes /readyz fail so load balancers stop sending new traffic
+// before the HTTP server shuts down.
+func (s *Server) BeginDrain() {
+	s.draining.Store(true)
+}
+
+// Shutdown starts draining long-lived work, call it when the HTTP server
+// begins shutting down.
+func (s *Server) Shutdown() {
+	s.draining.Store(true)
+	s.hub.Close()
+}
+
+// Close stops the background purges and releases the database pool.
+func (s *Server) Close() error {
+	s.stop()
+	s.purges.Wait()
+	if s.db == nil {
+		return nil
+	}
+	return s.db.Close()
 }
 
 func (s *Server) AuthMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		h := r.Header.Get("Authorization")
 		if !strings.HasPrefix(strings.ToLower(h), "bearer ") {
-			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			writeError(w, r, http.StatusUnauthorized, "unauthorized", "unauthorized")
 			return
 		}
 		token := strings.TrimSpace(h[len("Bearer "):])
-		claims, err := auth.ParseJWT(token)
+		claims, err := s.keys.ParseJWT(token)
 		if err != nil {
-			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			writeError(w, r, http.StatusUnauthorized, "unauthorized", "unauthorized")
+			return
+		}
+		if !s.accountActive(w, r, claims.Sub) {
 			return
 		}
 		ctx := r.Context()
-		ctx = WithUser(ctx, claims.Sub, claims.Admin)
+		isAdmin := s.adminSession(claims)
+		ctx = WithUser(ctx, claims.Sub, isAdmin)
+		if claims.Admin && !isAdmin {
+			ctx = withMFAPending(ctx)
+		}
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
+// accountActive checks that the user a token was issued to still exists and
+// hasn't been disabled since, writing the error response if not. Tokens stay
+// valid until they expire, so this is what makes disabling an account take
+// effect straight away.
+func (s *Server) accountActive(w http.ResponseWriter, r *http.Request, sub string) bool {
+	id, err := uuid.Parse(sub)
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "unauthorized", "unauthorized")
+		return false
+	}
+	user, err := s.store.GetUserByID(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeError(w, r, http.StatusUnauthorized, "unauthorized", "unauthorized")
+		return false
+	}
+	if err != nil {
+		Logger(r.Context()).Error("auth: loading user", "err", err)
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "db error")
+		return false
+	}
+	if user.DisabledAt.Valid {
+		writeError(w, r, http.StatusForbidden, "account_disabled", "account disabled")
+		return false
+	}
+	return true
+}
+
 func (s *Server) AdminOnly(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		_, isAdmin := GetUser(r.Context())
+		if mfaPending(r.Context()) {
+			writeError(w, r, http.StatusForbidden, "mfa_required", "admin access requires two-factor authentication")
+			return
+		}
 		if !isAdmin {
-			http.Error(w, "Forbidden", http.StatusForbidden)
+			writeError(w, r, http.StatusForbidden, "admin_required", "admin access required")
 			return
 		}
 		next.ServeHTTP(w, r)
 	})
 }
 
+// adminSession reports whether a token grants admin access. When the MFA
+// policy is on, staff who signed in with only a password get a customer
+// session until they pass a second factor.
+func (s *Server) adminSession(claims *auth.Claims) bool {
+	return claims.Admin && (claims.MFA || !s.cfg.MFA.RequireForAdmins)
+}
+
 func toNullUUID(idStr string) (uuid.NullUUID, error) {
 	u, err := uuid.Parse(idStr)
 	if err != nil {
@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/nickg76/garage-backend/internal/config"
+	"github.com/nickg76/garage-backend/internal/db"
+	"github.com/nickg76/garage-backend/internal/store"
+)
+
+func testConfig() *config.Config {
+	cfg := config.Default()
+	cfg.JWTSecret = "test-secret"
+	return cfg
+}
+
+func TestDisabledAccountTokensStopWorking(t *testing.T) {
+	ctx := context.Background()
+	st := store.NewMemory()
+	s, err := NewServerWith(testConfig(), Deps{Store: st})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	user, err := st.CreateUser(ctx, db.CreateUserParams{ID: uuid.New(), Name: "Ann", Email: "ann@example.com", Phone: "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := s.keys.GenerateJWT(user.ID.String(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	protected := s.AuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	call := func() int {
+		r := httptest.NewRequest("GET", "/api/me", nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		protected.ServeHTTP(w, r)
+		return w.Code
+	}
+	if code := call(); code != http.StatusNoContent {
+		t.Fatalf("active account: status %d", code)
+	}
+
+	if _, err := st.SetUserDisabled(ctx, db.SetUserDisabledParams{
+		Email:      user.Email,
+		DisabledAt: sql.NullTime{Time: time.Now(), Valid: true},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if code := call(); code != http.StatusForbidden {
+		t.Fatalf("disabled account: status %d, want 403", code)
+	}
+
+	w := httptest.NewRecorder()
+	s.Events(w, httptest.NewRequest("GET", "/api/events?token="+token, nil))
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("disabled account event stream: status %d, want 403", w.Code)
+	}
+}
+
+func TestDeletedAccountTokensStopWorking(t *testing.T) {
+	s, err := NewServerWith(testConfig(), Deps{Store: store.NewMemory()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	token, err := s.keys.GenerateJWT(uuid.NewString(), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest("GET", "/api/admin/appointments", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	s.AuthMiddleware(http.NotFoundHandler()).ServeHTTP(w, r)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("status %d, want 401", w.Code)
+	}
+}
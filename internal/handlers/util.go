@@ -3,14 +3,70 @@ package handlers
 
 import (
 	"context"
+	"log/slog"
 
 	"github.com/nickg76/garage-backend/internal/auth"
 )
 
+// WithUser marks the request as authenticated. The user ID is also added to
+// the request logger and the access log line.
 func WithUser(ctx context.Context, userID string, isAdmin bool) context.Context {
 	ctx = context.WithValue(ctx, auth.UserIDKey, userID)
 	ctx = context.WithValue(ctx, auth.AdminKey, isAdmin)
-	return ctx
+	if info, ok := ctx.Value(auth.AccessInfoKey).(*accessInfo); ok {
+		info.userID = userID
+	}
+	return WithLogger(ctx, Logger(ctx).With("user_id", userID))
+}
+
+// withMFAPending records that the session belongs to staff who still have to
+// pass a second factor before admin access is granted.
+func withMFAPending(ctx context.Context) context.Context {
+	return context.WithValue(ctx, auth.MFAPendingKey, true)
+}
+
+func mfaPending(ctx context.Context) bool {
+	pending, _ := ctx.Value(auth.MFAPendingKey).(bool)
+	return pending
+}
+
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, auth.RequestIDKey, requestID)
+}
+
+func GetRequestID(ctx context.Context) string {
+	id, _ := ctx.Value(auth.RequestIDKey).(string)
+	return id
+}
+
+func WithLogger(ctx context.Context, l *slog.Logger) context.Context {
+	return context.WithValue(ctx, auth.LoggerKey, l)
+}
+
+// Logger returns the request-scoped logger, falling back to the default one
+// outside a request.
+func Logger(ctx context.Context) *slog.Logger {
+	if l, ok := ctx.Value(auth.LoggerKey).(*slog.Logger); ok {
+		return l
+	}
+	return slog.Default()
+}
+
+func withAccessInfo(ctx context.Context, info *accessInfo) context.Context {
+	return context.WithValue(ctx, auth.AccessInfoKey, info)
+}
+
+func WithAPIVersion(ctx context.Context, version string) context.Context {
+	return context.WithValue(ctx, auth.APIVersionKey, version)
+}
+
+// GetAPIVersion returns the API version the request was routed through,
+// defaulting to v1.
+func GetAPIVersion(ctx context.Context) string {
+	if v, _ := ctx.Value(auth.APIVersionKey).(string); v != "" {
+		return v
+	}
+	return "v1"
 }
 
 func GetUser(ctx context.Context) (string, bool) {
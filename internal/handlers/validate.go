@@ -0,0 +1,315 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/mail"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	maxJSONBodySize   = 64 << 10 // 64 KiB
+	maxNameLen        = 100
+	maxEmailLen       = 254
+	minPasswordLen    = 8
+	maxPasswordLen    = 72 // bcrypt ignores anything past 72 bytes
+	maxTitleLen       = 200
+	maxDescriptionLen = 2000
+	maxMessageLen     = 4000
+)
+
+var phoneRe = regexp.MustCompile(`^\+?[0-9][0-9 ()-]{5,23}$`)
+
+// validatable is implemented by request DTOs. validate returns every problem
+// with the request at once so clients can show them together.
+type validatable interface {
+	validate() []fieldError
+}
+
+// decodeJSON reads a single JSON object into dst, rejecting oversized bodies,
+// unknown fields and trailing data. It writes the error response and returns
+// false on failure.
+func decodeJSON(w http.ResponseWriter, r *http.Request, dst any) bool {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxJSONBodySize))
+	dec.DisallowUnknownFields()
+
+	err := dec.Decode(dst)
+	if err == nil && dec.Decode(&struct{}{}) != io.EOF {
+		err = errors.New("body must contain a single JSON object")
+	}
+	if err == nil {
+		return true
+	}
+
+	var tooLarge *http.MaxBytesError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &tooLarge):
+		writeError(w, r, http.StatusRequestEntityTooLarge, "body_too_large", fmt.Sprintf("body must not exceed %d bytes", maxJSONBodySize))
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		field := strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), `"`)
+		writeValidationProblem(w, r, []fieldError{{Field: field, Message: "unknown field"}})
+	case errors.As(err, &typeErr) && typeErr.Field != "":
+		writeValidationProblem(w, r, []fieldError{{Field: typeErr.Field, Message: "must be a " + typeErr.Type.String()}})
+	default:
+		writeError(w, r, http.StatusBadRequest, "invalid_body", "invalid body")
+	}
+	return false
+}
+
+// decodeValid decodes the body into dst and runs its validation rules.
+func decodeValid(w http.ResponseWriter, r *http.Request, dst validatable) bool {
+	if !decodeJSON(w, r, dst) {
+		return false
+	}
+	if errs := dst.validate(); len(errs) > 0 {
+		writeValidationProblem(w, r, errs)
+		return false
+	}
+	return true
+}
+
+// --- Field rules, each returns "" when the value is fine ---
+
+func checkRequired(v string) string {
+	if strings.TrimSpace(v) == "" {
+		return "is required"
+	}
+	return ""
+}
+
+func checkMaxLen(v string, max int) string {
+	if utf8.RuneCountInString(v) > max {
+		return fmt.Sprintf("must be at most %d characters", max)
+	}
+	return ""
+}
+
+func checkEmail(v string) string {
+	if msg := checkRequired(v); msg != "" {
+		return msg
+	}
+	if len(v) > maxEmailLen {
+		return fmt.Sprintf("must be at most %d characters", maxEmailLen)
+	}
+	addr, err := mail.ParseAddress(v)
+	if err != nil || addr.Address != v || !strings.Contains(v[strings.LastIndex(v, "@"):], ".") {
+		return "must be a valid email address"
+	}
+	return ""
+}
+
+func checkPhone(v string) string {
+	if v == "" {
+		return ""
+	}
+	digits := 0
+	for _, c := range v {
+		if c >= '0' && c <= '9' {
+			digits++
+		}
+	}
+	if !phoneRe.MatchString(v) || digits < 7 || digits > 15 {
+		return "must be a valid phone number"
+	}
+	return ""
+}
+
+func checkPassword(v string) string {
+	if len(v) < minPasswordLen {
+		return fmt.Sprintf("must be at least %d characters", minPasswordLen)
+	}
+	if len(v) > maxPasswordLen {
+		return fmt.Sprintf("must be at most %d bytes", maxPasswordLen)
+	}
+	return ""
+}
+
+// checkDatetime validates an RFC 3339 timestamp.
+func checkDatetime(v string) string {
+	if v == "" {
+		return "is required"
+	}
+	if _, err := time.Parse(time.RFC3339, v); err != nil {
+		return "must be an RFC 3339 timestamp"
+	}
+	return ""
+}
+
+// checkFutureDatetime validates an RFC 3339 timestamp that isn't in the past.
+func checkFutureDatetime(v string) string {
+	if msg := checkDatetime(v); msg != "" {
+		return msg
+	}
+	if t, _ := time.Parse(time.RFC3339, v); t.Before(time.Now()) {
+		return "must not be in the past"
+	}
+	return ""
+}
+
+// rescheduledDatetime validates the datetime sent for an existing appointment
+// and returns the one to store. Sending back the current datetime is fine
+// even once it has passed, only moving the appointment has to be into the
+// future. Responses carry whole seconds, so that is what's compared.
+func rescheduledDatetime(current time.Time, v string) (time.Time, string) {
+	if msg := checkDatetime(v); msg != "" {
+		return current, msg
+	}
+	t, _ := time.Parse(time.RFC3339, v)
+	if t.Truncate(time.Second).Equal(current.Truncate(time.Second)) {
+		return current, ""
+	}
+	if t.Before(time.Now()) {
+		return current, "must not be in the past"
+	}
+	return t, ""
+}
+
+func checkTitle(v string) string {
+	if msg := checkRequired(v); msg != "" {
+		return msg
+	}
+	return checkMaxLen(v, maxTitleLen)
+}
+
+func checkStatus(v string) string {
+	switch v {
+	case "accepted", "rejected", "pending":
+		return ""
+	}
+	return "must be one of accepted, rejected, pending"
+}
+
+// fieldErrors collects the non-empty results of field rules.
+type fieldErrors []fieldError
+
+func (fe *fieldErrors) check(field, msg string) {
+	if msg != "" {
+		*fe = append(*fe, fieldError{Field: field, Message: msg})
+	}
+}
+
+// --- Request DTO rules ---
+
+func (req *registerReq) validate() []fieldError {
+	req.Name = strings.TrimSpace(req.Name)
+	req.Email = strings.TrimSpace(req.Email)
+	req.Phone = strings.TrimSpace(req.Phone)
+
+	var errs fieldErrors
+	errs.check("name", checkRequired(req.Name))
+	errs.check("name", checkMaxLen(req.Name, maxNameLen))
+	errs.check("email", checkEmail(req.Email))
+	errs.check("phone", checkPhone(req.Phone))
+	errs.check("password", checkPassword(req.Password))
+	return errs
+}
+
+func (req *loginReq) validate() []fieldError {
+	req.Email = strings.TrimSpace(req.Email)
+
+	var errs fieldErrors
+	errs.check("email", checkRequired(req.Email))
+	errs.check("email", checkMaxLen(req.Email, maxEmailLen))
+	errs.check("password", checkRequired(req.Password))
+	if len(req.Password) > maxPasswordLen {
+		errs.check("password", fmt.Sprintf("must be at most %d bytes", maxPasswordLen))
+	}
+	return errs
+}
+
+func (req *createApptReq) validate() []fieldError {
+	req.Title = strings.TrimSpace(req.Title)
+
+	var errs fieldErrors
+	errs.check("datetime", checkFutureDatetime(req.Datetime))
+	errs.check("title", checkTitle(req.Title))
+	errs.check("description", checkMaxLen(req.Description, maxDescriptionLen))
+	return errs
+}
+
+func (req *editApptReq) validate() []fieldError {
+	req.Title = strings.TrimSpace(req.Title)
+
+	// whether the datetime may be in the past depends on the stored one,
+	// UserEditAppointment checks that part
+	var errs fieldErrors
+	errs.check("datetime", checkDatetime(req.Datetime))
+	errs.check("title", checkTitle(req.Title))
+	errs.check("description", checkMaxLen(req.Description, maxDescriptionLen))
+	return errs
+}
+
+func (req *updateStatusReq) validate() []fieldError {
+	req.Status = strings.ToLower(strings.TrimSpace(req.Status))
+
+	var errs fieldErrors
+	errs.check("status", checkStatus(req.Status))
+	return errs
+}
+
+func (req *createMessageReq) validate() []fieldError {
+	req.Body = strings.TrimSpace(req.Body)
+
+	var errs fieldErrors
+	errs.check("body", checkRequired(req.Body))
+	errs.check("body", checkMaxLen(req.Body, maxMessageLen))
+	return errs
+}
+
+func (req *totpConfirmReq) validate() []fieldError {
+	req.Code = strings.TrimSpace(req.Code)
+
+	var errs fieldErrors
+	errs.check("code", checkRequired(req.Code))
+	return errs
+}
+
+func (req *loginMFAReq) validate() []fieldError {
+	req.Code = strings.TrimSpace(req.Code)
+	req.RecoveryCode = strings.TrimSpace(req.RecoveryCode)
+
+	var errs fieldErrors
+	errs.check("mfa_token", checkRequired(req.MFAToken))
+	if (req.Code == "") == (req.RecoveryCode == "") {
+		errs.check("code", "provide either code or recovery_code")
+	}
+	errs.check("recovery_code", checkMaxLen(req.RecoveryCode, 32))
+	return errs
+}
+
+func (req *magicLinkReq) validate() []fieldError {
+	req.Email = strings.TrimSpace(req.Email)
+
+	var errs fieldErrors
+	errs.check("email", checkRequired(req.Email))
+	errs.check("email", checkMaxLen(req.Email, maxEmailLen))
+	return errs
+}
+
+func (req *verifyMagicLinkReq) validate() []fieldError {
+	req.Email = strings.TrimSpace(req.Email)
+	req.Token = strings.TrimSpace(req.Token)
+
+	var errs fieldErrors
+	errs.check("email", checkRequired(req.Email))
+	errs.check("email", checkMaxLen(req.Email, maxEmailLen))
+	errs.check("token", checkRequired(req.Token))
+	errs.check("token", checkMaxLen(req.Token, 128))
+	return errs
+}
+
+func (req *finishSSOReq) validate() []fieldError {
+	var errs fieldErrors
+	errs.check("state", checkRequired(req.State))
+	errs.check("state", checkMaxLen(req.State, 128))
+	errs.check("code", checkRequired(req.Code))
+	errs.check("code", checkMaxLen(req.Code, 2048))
+	return errs
+}
@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRescheduledDatetime(t *testing.T) {
+	past := time.Now().Add(-48 * time.Hour).Truncate(time.Second).Add(250 * time.Millisecond)
+	future := time.Now().Add(48 * time.Hour).Truncate(time.Second)
+
+	cases := []struct {
+		name    string
+		current time.Time
+		v       string
+		want    time.Time
+		msg     string
+	}{
+		{"unchanged past datetime is kept", past, past.Format(time.RFC3339), past, ""},
+		{"same instant in another zone", past, past.In(time.FixedZone("", 3600)).Format(time.RFC3339), past, ""},
+		{"moved into the future", past, future.Format(time.RFC3339), future, ""},
+		{"moved to another past time", future, past.Add(time.Hour).Format(time.RFC3339), future, "must not be in the past"},
+		{"not a timestamp", future, "next tuesday", future, "must be an RFC 3339 timestamp"},
+		{"missing", future, "", future, "is required"},
+	}
+	for _, c := range cases {
+		got, msg := rescheduledDatetime(c.current, c.v)
+		if msg != c.msg || !got.Equal(c.want) {
+			t.Errorf("%s: got (%v, %q), want (%v, %q)", c.name, got, msg, c.want, c.msg)
+		}
+	}
+}
+
+func TestCreateApptReqRejectsPastDatetime(t *testing.T) {
+	req := createApptReq{Datetime: time.Now().Add(-time.Hour).Format(time.RFC3339), Title: "Service"}
+	errs := req.validate()
+	if len(errs) != 1 || errs[0].Field != "datetime" {
+		t.Fatalf("errs = %+v, want one datetime error", errs)
+	}
+
+	// edits defer the past check to rescheduledDatetime
+	edit := editApptReq(req)
+	if errs := edit.validate(); len(errs) != 0 {
+		t.Fatalf("edit errs = %+v, want none", errs)
+	}
+}
@@ -0,0 +1,121 @@
+package integration
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"testing"
+	"time"
+
+	_ "github.com/lib/pq"
+
+	"github.com/nickg76/garage-backend/internal/auth"
+	"github.com/nickg76/garage-backend/internal/db"
+)
+
+type session struct {
+	Token                 string `json:"token"`
+	MFAEnrollmentRequired bool   `json:"mfa_enrollment_required"`
+}
+
+type appointment struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+}
+
+func register(t *testing.T, name, email, password string) {
+	t.Helper()
+	send(t, "POST", "/api/register", "", map[string]string{
+		"name": name, "email": email, "phone": "01234 567890", "password": password,
+	}, nil, http.StatusOK)
+}
+
+func login(t *testing.T, email, password string) session {
+	t.Helper()
+	var s session
+	send(t, "POST", "/api/login", "", map[string]string{"email": email, "password": password}, &s, http.StatusOK)
+	return s
+}
+
+// staffSession registers a member of staff, promotes them in the database
+// and completes the TOTP enrollment admin sessions require.
+func staffSession(t *testing.T, email string) string {
+	t.Helper()
+	register(t, "Staff", email, "staff-password")
+	conn, err := sql.Open("postgres", env.DatabaseURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := db.New(conn).SetAdmin(context.Background(), db.SetAdminParams{
+		Email: email, IsAdmin: sql.NullBool{Bool: true, Valid: true},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	s := login(t, email, "staff-password")
+	if !s.MFAEnrollmentRequired {
+		t.Fatal("staff login did not ask for two-factor enrollment")
+	}
+	send(t, "GET", "/api/admin/appointments", s.Token, nil, nil, http.StatusForbidden)
+
+	var enroll struct{ Secret string }
+	send(t, "POST", "/api/mfa/totp/enroll", s.Token, nil, &enroll, http.StatusOK)
+	code, err := auth.TOTPCode(enroll.Secret, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var confirmed session
+	send(t, "POST", "/api/mfa/totp/confirm", s.Token, map[string]string{"code": code}, &confirmed, http.StatusOK)
+	return confirmed.Token
+}
+
+// TestBookingAcceptedByStaff is the main customer journey: sign up, book,
+// and hear about it live when the garage accepts.
+func TestBookingAcceptedByStaff(t *testing.T) {
+	needEnv(t)
+
+	register(t, "Ann", "ann@booking.test", "ann-password")
+	customer := login(t, "ann@booking.test", "ann-password").Token
+	staff := staffSession(t, "staff@booking.test")
+
+	events := subscribe(t, customer)
+
+	var booked appointment
+	send(t, "POST", "/api/appointments", customer, map[string]string{
+		"datetime": time.Now().Add(72 * time.Hour).UTC().Format(time.RFC3339),
+		"title":    "MOT",
+	}, &booked, http.StatusOK, "Idempotency-Key", "ann-mot")
+	if booked.Status != "pending" {
+		t.Fatalf("new booking status = %q", booked.Status)
+	}
+
+	var all []appointment
+	send(t, "GET", "/api/admin/appointments", staff, nil, &all, http.StatusOK)
+	var found bool
+	for _, a := range all {
+		found = found || a.ID == booked.ID
+	}
+	if !found {
+		t.Fatalf("staff list %+v misses %s", all, booked.ID)
+	}
+
+	h := send(t, "GET", "/api/appointments/"+booked.ID, customer, nil, nil, http.StatusOK)
+	send(t, "PATCH", "/api/admin/appointments/"+booked.ID+"/status", staff,
+		map[string]string{"status": "accepted"}, nil, http.StatusNoContent, "If-Match", h.Get("ETag"))
+
+	select {
+	case ev := <-events:
+		if ev.Type != "appointment_status" || ev.Appointment != booked.ID || ev.Status != "accepted" {
+			t.Fatalf("event = %+v", ev)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("customer was not notified")
+	}
+
+	var mine appointment
+	send(t, "GET", "/api/appointments/"+booked.ID, customer, nil, &mine, http.StatusOK)
+	if mine.Status != "accepted" {
+		t.Fatalf("status after accepting = %q", mine.Status)
+	}
+}
@@ -0,0 +1,4 @@
+// Package integration holds end-to-end tests that run the whole API against
+// a real Postgres started by package testenv. They are skipped when no
+// Postgres is available, see testenv.ErrNoPostgres.
+package integration
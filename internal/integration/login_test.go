@@ -0,0 +1,60 @@
+package integration
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/nickg76/garage-backend/internal/db"
+)
+
+func TestLogin(t *testing.T) {
+	e := needEnv(t)
+	register(t, "Lee", "lee@login.test", "lee-password")
+
+	s := login(t, "lee@login.test", "lee-password")
+	var me struct{ Email string }
+	send(t, "GET", "/api/me", s.Token, nil, &me, http.StatusOK)
+	if me.Email != "lee@login.test" {
+		t.Fatalf("me = %+v", me)
+	}
+
+	type problem struct{ Code, Title, Detail string }
+	wrong := map[string]string{"email": "lee@login.test", "password": "wrong"}
+	for range e.Config.Lockout.Threshold {
+		send(t, "POST", "/api/login", "", wrong, nil, http.StatusUnauthorized)
+	}
+
+	// locked now, and answered exactly like an unknown address
+	var locked, unknown problem
+	send(t, "POST", "/api/login", "", map[string]string{"email": "lee@login.test", "password": "lee-password"},
+		&locked, http.StatusUnauthorized)
+	send(t, "POST", "/api/login", "", map[string]string{"email": "nobody@login.test", "password": "lee-password"},
+		&unknown, http.StatusUnauthorized)
+	if locked != unknown {
+		t.Fatalf("locked account answered %+v, unknown email %+v", locked, unknown)
+	}
+
+	// garagectl users reset-password replaces the password and unlocks
+	conn, err := sql.Open("postgres", e.DatabaseURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	hash, err := bcrypt.GenerateFromPassword([]byte("new-password"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := db.New(conn).SetPasswordHash(context.Background(), db.SetPasswordHashParams{
+		Email:        "lee@login.test",
+		PasswordHash: string(hash),
+	}); err != nil || n != 1 {
+		t.Fatalf("SetPasswordHash = %d, %v", n, err)
+	}
+	send(t, "POST", "/api/login", "", map[string]string{"email": "lee@login.test", "password": "lee-password"},
+		nil, http.StatusUnauthorized)
+	login(t, "lee@login.test", "new-password")
+}
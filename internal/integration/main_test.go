@@ -0,0 +1,136 @@
+package integration
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nickg76/garage-backend/internal/handlers"
+	"github.com/nickg76/garage-backend/internal/testenv"
+)
+
+// env is shared by every test in the package, nil when Postgres isn't
+// available.
+var env *testenv.Env
+
+func TestMain(m *testing.M) {
+	flag.Parse()
+	if !testing.Short() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		var err error
+		env, err = testenv.Start(ctx)
+		cancel()
+		if err != nil && !errors.Is(err, testenv.ErrNoPostgres) {
+			fmt.Fprintln(os.Stderr, "starting test environment:", err)
+			os.Exit(1)
+		}
+	}
+	code := m.Run()
+	if env != nil {
+		env.Close()
+	}
+	os.Exit(code)
+}
+
+// needEnv skips the test when there is no environment to run it against.
+func needEnv(t *testing.T) *testenv.Env {
+	t.Helper()
+	if env == nil {
+		t.Skip("no Postgres for integration tests: set TEST_DATABASE_URL or put initdb on PATH")
+	}
+	return env
+}
+
+// send makes a JSON request to the API and decodes a JSON answer into out,
+// failing the test unless the status is want.
+func send(t *testing.T, method, path, token string, body, out any, want int, hdr ...string) http.Header {
+	t.Helper()
+	var rd io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rd = bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(method, env.API.URL+path, rd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	for i := 0; i+1 < len(hdr); i += 2 {
+		req.Header.Set(hdr[i], hdr[i+1])
+	}
+	resp, err := env.API.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != want {
+		t.Fatalf("%s %s: status %d, want %d\n%s", method, path, resp.StatusCode, want, b)
+	}
+	if out != nil {
+		if err := json.Unmarshal(b, out); err != nil {
+			t.Fatalf("%s %s: %v\n%s", method, path, err, b)
+		}
+	}
+	return resp.Header
+}
+
+// subscribe opens the event stream for token and returns its events.
+func subscribe(t *testing.T, token string) <-chan handlers.Event {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	req, _ := http.NewRequestWithContext(ctx, "GET", env.API.URL+"/api/events?token="+url.QueryEscape(token), nil)
+	resp, err := env.API.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("events: status %d", resp.StatusCode)
+	}
+	events := make(chan handlers.Event, 16)
+	connected := make(chan struct{})
+	go func() {
+		defer resp.Body.Close()
+		defer close(events)
+		sc := bufio.NewScanner(resp.Body)
+		for sc.Scan() {
+			if sc.Text() == ": connected" {
+				close(connected)
+			}
+			data, ok := strings.CutPrefix(sc.Text(), "data: ")
+			if !ok {
+				continue
+			}
+			var ev handlers.Event
+			if json.Unmarshal([]byte(data), &ev) == nil {
+				events <- ev
+			}
+		}
+	}()
+	select {
+	case <-connected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("events: stream did not open")
+	}
+	return events
+}
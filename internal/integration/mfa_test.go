@@ -0,0 +1,33 @@
+package integration
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/nickg76/garage-backend/internal/auth"
+)
+
+func TestTOTPEnrollmentStored(t *testing.T) {
+	needEnv(t)
+	staffSession(t, "staff@mfa.test")
+
+	conn, err := sql.Open("postgres", env.DatabaseURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	var secret string
+	var codes int
+	if err := conn.QueryRow(`
+		SELECT u.totp_secret, count(c.id) FROM users u
+		LEFT JOIN mfa_recovery_codes c ON c.user_id = u.id
+		WHERE u.email = $1 GROUP BY u.totp_secret`, "staff@mfa.test").Scan(&secret, &codes); err != nil {
+		t.Fatal(err)
+	}
+	if !auth.IsSealedTOTPSecret(secret) {
+		t.Fatalf("totp_secret %q isn't encrypted", secret)
+	}
+	if codes != 10 {
+		t.Fatalf("%d recovery codes stored, want 10", codes)
+	}
+}
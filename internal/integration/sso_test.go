@@ -0,0 +1,40 @@
+package integration
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/nickg76/garage-backend/internal/oidctest"
+)
+
+// TestSSO signs in through the mock provider, linking the account by its
+// verified email.
+func TestSSO(t *testing.T) {
+	e := needEnv(t)
+	register(t, "Sam", "sam@sso.test", "sam-password")
+	e.IdP.SetUser(oidctest.User{Subject: "idp-sam", Email: "sam@sso.test", EmailVerified: true, Name: "Sam"})
+
+	var begin struct {
+		AuthorizationURL string `json:"authorization_url"`
+	}
+	h := send(t, "POST", "/api/login/oidc/mock/begin", "", nil, &begin, http.StatusOK)
+	cookies := (&http.Response{Header: h}).Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("begin set cookies %v", cookies)
+	}
+	code, state, err := e.IdP.Authorize(begin.AuthorizationURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	finish := map[string]string{"code": code, "state": state}
+	send(t, "POST", "/api/login/oidc/finish", "", finish, nil, http.StatusUnauthorized)
+	var s session
+	send(t, "POST", "/api/login/oidc/finish", "", finish, &s, http.StatusOK,
+		"Cookie", cookies[0].Name+"="+cookies[0].Value)
+	var me struct{ Email string }
+	send(t, "GET", "/api/me", s.Token, nil, &me, http.StatusOK)
+	if me.Email != "sam@sso.test" {
+		t.Fatalf("me = %+v", me)
+	}
+}
@@ -0,0 +1,31 @@
+// Package mail sends transactional email such as sign-in links.
+package mail
+
+import (
+	"context"
+	"errors"
+	"strings"
+)
+
+// Message is a plain-text email to a single recipient.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Sender delivers messages.
+type Sender interface {
+	Send(ctx context.Context, m Message) error
+}
+
+// validate rejects header injection through the recipient or subject.
+func (m Message) validate() error {
+	if m.To == "" {
+		return errors.New("mail: no recipient")
+	}
+	if strings.ContainsAny(m.To+m.Subject, "\r\n") {
+		return errors.New("mail: line break in header")
+	}
+	return nil
+}
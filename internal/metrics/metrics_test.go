@@ -0,0 +1,31 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestInstrumentSkipsLatencyForEventStreams(t *testing.T) {
+	plain := Instrument("GET /test/plain", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	stream := Instrument("GET /test/stream", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte(": ping\n\n"))
+	}))
+	plain.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/test/plain", nil))
+	stream.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/test/stream", nil))
+
+	if n := testutil.ToFloat64(httpRequests.WithLabelValues("/test/plain", "GET", "418")); n != 1 {
+		t.Errorf("plain requests = %v, want 1", n)
+	}
+	if n := testutil.ToFloat64(httpRequests.WithLabelValues("/test/stream", "GET", "200")); n != 1 {
+		t.Errorf("stream requests = %v, want 1", n)
+	}
+	if n := testutil.CollectAndCount(httpDuration, "garage_http_request_duration_seconds"); n != 1 {
+		t.Errorf("latency series = %d, want only the plain route", n)
+	}
+}
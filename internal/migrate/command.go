@@ -0,0 +1,94 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// ErrUsage is returned by RunCommand for an unknown command or the wrong
+// arguments. Callers print their own usage text.
+var ErrUsage = errors.New("migrate: invalid command")
+
+// RunCommand runs one of the migrate commands shared by the server binary
+// and garagectl, writing progress to w:
+//
+//	up                  apply all pending migrations
+//	down                roll back the most recent migration
+//	status              list migrations and when they were applied
+//	baseline <version>  mark migrations up to version as applied
+func RunCommand(ctx context.Context, db *sql.DB, args []string, w io.Writer) error {
+	if len(args) == 0 || len(args) != argCount(args[0]) {
+		return ErrUsage
+	}
+	m, err := New(db)
+	if err != nil {
+		return fmt.Errorf("loading migrations: %w", err)
+	}
+
+	switch args[0] {
+	case "up":
+		ran, err := m.Up(ctx)
+		for _, mig := range ran {
+			fmt.Fprintf(w, "applied %s\n", mig.Name)
+		}
+		if err != nil {
+			return err
+		}
+		if len(ran) == 0 {
+			fmt.Fprintln(w, "no pending migrations")
+		}
+	case "down":
+		mig, ok, err := m.Down(ctx)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			fmt.Fprintln(w, "no migrations to roll back")
+			return nil
+		}
+		fmt.Fprintf(w, "rolled back %s\n", mig.Name)
+	case "baseline":
+		version, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return ErrUsage
+		}
+		recorded, err := m.Baseline(ctx, version)
+		if err != nil {
+			return err
+		}
+		for _, mig := range recorded {
+			fmt.Fprintf(w, "marked %s as applied\n", mig.Name)
+		}
+		if len(recorded) == 0 {
+			fmt.Fprintln(w, "nothing to mark, already at or past that version")
+		}
+	case "status":
+		statuses, err := m.Status(ctx)
+		if err != nil {
+			return err
+		}
+		for _, st := range statuses {
+			applied := "pending"
+			if st.AppliedAt != nil {
+				applied = st.AppliedAt.Local().Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%-35s %s\n", st.Name, applied)
+		}
+	default:
+		return ErrUsage
+	}
+	return nil
+}
+
+// argCount is how many arguments, command included, a command takes.
+func argCount(cmd string) int {
+	if cmd == "baseline" {
+		return 2
+	}
+	return 1
+}
@@ -0,0 +1,434 @@
+// Package migrate applies the SQL files embedded in package migrations and
+// records them in a schema_migrations table.
+//
+// Files keep goose's "-- +goose Up" / "-- +goose Down" annotations so they
+// stay usable with the goose CLI. Each migration runs in its own transaction
+// while a Postgres advisory lock is held, so replicas starting at the same
+// time don't race each other.
+package migrate
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nickg76/garage-backend/migrations"
+)
+
+// lockID is the pg_advisory_lock key guarding migrations, an arbitrary
+// constant shared by every instance of the service.
+const lockID = 727274301
+
+// ErrNoDown is returned when rolling back a migration that has no
+// "-- +goose Down" section.
+var ErrNoDown = errors.New("migration has no down section")
+
+// ErrUntracked is returned by Up when the database already has tables but no
+// migration history, typically because the schema was applied by hand. Run
+// Baseline with the version it matches first.
+var ErrUntracked = errors.New("database has tables but no recorded migrations, run \"migrate baseline <version>\" first")
+
+// ErrUnknownVersion is returned by Baseline for a version that isn't shipped
+// with the binary.
+var ErrUnknownVersion = errors.New("unknown migration version")
+
+// Migration is one numbered SQL file.
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Status pairs a migration with when it was applied, nil if it hasn't been.
+type Status struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+type Migrator struct {
+	db         *sql.DB
+	migrations []Migration
+}
+
+// New returns a Migrator for the migrations embedded in the binary.
+func New(db *sql.DB) (*Migrator, error) {
+	ms, err := Load(migrations.FS)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, migrations: ms}, nil
+}
+
+// Load parses every NNN_name.sql file in fsys, ordered by version.
+func Load(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+	var ms []Migration
+	seen := map[int64]string{}
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".sql") {
+			continue
+		}
+		prefix, _, ok := strings.Cut(e.Name(), "_")
+		v, err := strconv.ParseInt(prefix, 10, 64)
+		if !ok || err != nil {
+			return nil, fmt.Errorf("migration %s: name must start with a version number", e.Name())
+		}
+		if other, dup := seen[v]; dup {
+			return nil, fmt.Errorf("migrations %s and %s share version %d", other, e.Name(), v)
+		}
+		seen[v] = e.Name()
+
+		b, err := fs.ReadFile(fsys, e.Name())
+		if err != nil {
+			return nil, err
+		}
+		m, err := parse(string(b))
+		if err != nil {
+			return nil, fmt.Errorf("migration %s: %w", e.Name(), err)
+		}
+		m.Version, m.Name = v, strings.TrimSuffix(e.Name(), ".sql")
+		ms = append(ms, m)
+	}
+	sort.Slice(ms, func(i, j int) bool { return ms[i].Version < ms[j].Version })
+	return ms, nil
+}
+
+// parse splits a goose-style file into its up and down sections. Other goose
+// annotations such as StatementBegin are dropped, the whole section is sent
+// to Postgres as one multi-statement query.
+func parse(src string) (Migration, error) {
+	var m Migration
+	var up, down strings.Builder
+	var cur *strings.Builder
+	sc := bufio.NewScanner(strings.NewReader(src))
+	for sc.Scan() {
+		line := sc.Text()
+		if strings.HasPrefix(strings.TrimSpace(line), "-- +goose ") {
+			switch strings.Fields(line)[2] {
+			case "Up":
+				cur = &up
+			case "Down":
+				cur = &down
+			}
+			continue
+		}
+		if cur != nil {
+			cur.WriteString(line)
+			cur.WriteByte('\n')
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return m, err
+	}
+	m.Up, m.Down = strings.TrimSpace(up.String()), strings.TrimSpace(down.String())
+	if m.Up == "" {
+		return m, errors.New("missing -- +goose Up section")
+	}
+	return m, nil
+}
+
+// Latest is the highest version the Migrator knows about.
+func (m *Migrator) Latest() int64 {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+// Up applies every pending migration in order and returns the ones it ran.
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	var ran []Migration
+	err := m.locked(ctx, func(conn *sql.Conn) error {
+		applied, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if len(applied) == 0 {
+			untracked, err := hasTables(ctx, conn)
+			if err != nil {
+				return err
+			}
+			if untracked {
+				return ErrUntracked
+			}
+		}
+		for _, mig := range m.migrations {
+			if _, ok := applied[mig.Version]; ok {
+				continue
+			}
+			if err := apply(ctx, conn, mig.Up,
+				`INSERT INTO schema_migrations (version, name) VALUES ($1, $2)`, mig.Version, mig.Name,
+			); err != nil {
+				return fmt.Errorf("applying %s: %w", mig.Name, err)
+			}
+			slog.Info("migration applied", "version", mig.Version, "name", mig.Name)
+			ran = append(ran, mig)
+		}
+		return nil
+	})
+	return ran, err
+}
+
+// Baseline records every migration up to and including version as applied
+// without running it, for databases whose schema was created by hand or by
+// another tool. It returns the migrations it recorded.
+func (m *Migrator) Baseline(ctx context.Context, version int64) ([]Migration, error) {
+	if _, ok := m.find(version); !ok {
+		return nil, fmt.Errorf("baseline %d: %w", version, ErrUnknownVersion)
+	}
+	var recorded []Migration
+	err := m.locked(ctx, func(conn *sql.Conn) error {
+		applied, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+		tx, err := conn.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+		for _, mig := range m.migrations {
+			if mig.Version > version {
+				break
+			}
+			if _, ok := applied[mig.Version]; ok {
+				continue
+			}
+			if _, err := tx.ExecContext(ctx,
+				`INSERT INTO schema_migrations (version, name) VALUES ($1, $2)`, mig.Version, mig.Name,
+			); err != nil {
+				return err
+			}
+			recorded = append(recorded, mig)
+		}
+		if err := tx.Commit(); err != nil {
+			recorded = nil
+			return err
+		}
+		slog.Info("migrations baselined", "version", version, "recorded", len(recorded))
+		return nil
+	})
+	return recorded, err
+}
+
+// Down rolls back the most recently applied migration. It returns false when
+// there was nothing to roll back.
+func (m *Migrator) Down(ctx context.Context) (Migration, bool, error) {
+	var rolled Migration
+	var found bool
+	err := m.locked(ctx, func(conn *sql.Conn) error {
+		var v int64
+		err := conn.QueryRowContext(ctx, `SELECT version FROM schema_migrations ORDER BY version DESC LIMIT 1`).Scan(&v)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		mig, ok := m.find(v)
+		if !ok {
+			return fmt.Errorf("version %d is applied but not shipped with this binary", v)
+		}
+		if mig.Down == "" {
+			return fmt.Errorf("%s: %w", mig.Name, ErrNoDown)
+		}
+		if err := apply(ctx, conn, mig.Down,
+			`DELETE FROM schema_migrations WHERE version = $1`, mig.Version,
+		); err != nil {
+			return fmt.Errorf("rolling back %s: %w", mig.Name, err)
+		}
+		slog.Info("migration rolled back", "version", mig.Version, "name", mig.Name)
+		rolled, found = mig, true
+		return nil
+	})
+	return rolled, found, err
+}
+
+// Status lists every known migration and whether it has been applied.
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	var out []Status
+	err := m.locked(ctx, func(conn *sql.Conn) error {
+		applied, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, mig := range m.migrations {
+			st := Status{Migration: mig}
+			if at, ok := applied[mig.Version]; ok {
+				st.AppliedAt = &at
+			}
+			out = append(out, st)
+		}
+		return nil
+	})
+	return out, err
+}
+
+// Version is the highest applied migration, 0 on an empty database. It only
+// reads, so it doesn't take the lock or create the table.
+func Version(ctx context.Context, db *sql.DB) (int64, error) {
+	var v int64
+	err := db.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&v)
+	return v, err
+}
+
+func (m *Migrator) find(version int64) (Migration, bool) {
+	for _, mig := range m.migrations {
+		if mig.Version == version {
+			return mig, true
+		}
+	}
+	return Migration{}, false
+}
+
+// locked runs fn on a single connection holding the migration advisory lock,
+// after making sure the bookkeeping table exists.
+func (m *Migrator) locked(ctx context.Context, fn func(*sql.Conn) error) error {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, lockID); err != nil {
+		return fmt.Errorf("taking migration lock: %w", err)
+	}
+	defer func() {
+		// the lock is per session, release it even if ctx was cancelled
+		unlockCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		conn.ExecContext(unlockCtx, `SELECT pg_advisory_unlock($1)`, lockID)
+	}()
+
+	if err := m.ensureTable(ctx, conn); err != nil {
+		return err
+	}
+	return fn(conn)
+}
+
+// ensureTable creates schema_migrations. Databases previously migrated with
+// the goose CLI have their goose_db_version history copied across so nothing
+// is applied twice.
+func (m *Migrator) ensureTable(ctx context.Context, conn *sql.Conn) error {
+	var exists bool
+	if err := conn.QueryRowContext(ctx, `SELECT to_regclass('schema_migrations') IS NOT NULL`).Scan(&exists); err != nil {
+		return err
+	}
+	if exists {
+		return nil
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `CREATE TABLE schema_migrations (
+		version BIGINT PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`); err != nil {
+		return fmt.Errorf("creating schema_migrations: %w", err)
+	}
+
+	var goose bool
+	if err := tx.QueryRowContext(ctx, `SELECT to_regclass('goose_db_version') IS NOT NULL`).Scan(&goose); err != nil {
+		return err
+	}
+	if goose {
+		rows, err := tx.QueryContext(ctx, `SELECT DISTINCT ON (version_id) version_id, is_applied
+			FROM goose_db_version WHERE version_id > 0 ORDER BY version_id, id DESC`)
+		if err != nil {
+			return fmt.Errorf("reading goose_db_version: %w", err)
+		}
+		var versions []int64
+		for rows.Next() {
+			var v int64
+			var applied bool
+			if err := rows.Scan(&v, &applied); err != nil {
+				rows.Close()
+				return err
+			}
+			if applied {
+				versions = append(versions, v)
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		for _, v := range versions {
+			name := strconv.FormatInt(v, 10)
+			if mig, ok := m.find(v); ok {
+				name = mig.Name
+			}
+			if _, err := tx.ExecContext(ctx,
+				`INSERT INTO schema_migrations (version, name) VALUES ($1, $2)`, v, name,
+			); err != nil {
+				return err
+			}
+		}
+		slog.Info("imported goose migration history", "versions", len(versions))
+	}
+	return tx.Commit()
+}
+
+// hasTables reports whether the current schema holds anything besides the
+// migration bookkeeping tables.
+func hasTables(ctx context.Context, conn *sql.Conn) (bool, error) {
+	var exists bool
+	err := conn.QueryRowContext(ctx, `SELECT EXISTS (
+		SELECT 1 FROM information_schema.tables
+		WHERE table_schema = current_schema()
+			AND table_name NOT IN ('schema_migrations', 'goose_db_version')
+	)`).Scan(&exists)
+	return exists, err
+}
+
+func appliedVersions(ctx context.Context, conn *sql.Conn) (map[int64]time.Time, error) {
+	rows, err := conn.QueryContext(ctx, `SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	applied := map[int64]time.Time{}
+	for rows.Next() {
+		var v int64
+		var at time.Time
+		if err := rows.Scan(&v, &at); err != nil {
+			return nil, err
+		}
+		applied[v] = at
+	}
+	return applied, rows.Err()
+}
+
+// apply runs a migration body and its bookkeeping statement in one
+// transaction.
+func apply(ctx context.Context, conn *sql.Conn, body, record string, args ...any) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, body); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, record, args...); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
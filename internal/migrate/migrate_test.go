@@ -0,0 +1,140 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	_ "github.com/lib/pq"
+)
+
+func TestLoad(t *testing.T) {
+	ms, err := Load(fstest.MapFS{
+		"002_b.sql":     {Data: []byte("-- +goose Up\nSELECT 2;\n-- +goose Down\nSELECT -2;\n")},
+		"001_a.sql":     {Data: []byte("-- +goose Up\n-- +goose StatementBegin\nSELECT 1;\n-- +goose StatementEnd\n")},
+		"README.md":     {Data: []byte("not a migration")},
+		"migrations.go": {Data: []byte("package migrations")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ms) != 2 || ms[0].Name != "001_a" || ms[1].Version != 2 {
+		t.Fatalf("loaded %+v", ms)
+	}
+	if ms[0].Up != "SELECT 1;" || ms[0].Down != "" || ms[1].Down != "SELECT -2;" {
+		t.Fatalf("sections: %+v", ms)
+	}
+
+	if _, err := Load(fstest.MapFS{"init.sql": {Data: []byte("-- +goose Up\nSELECT 1;")}}); err == nil {
+		t.Fatal("a file without a version loaded")
+	}
+}
+
+func TestBaselineUnknownVersion(t *testing.T) {
+	m, err := New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Baseline(context.Background(), m.Latest()+1); !errors.Is(err, ErrUnknownVersion) {
+		t.Fatalf("err = %v, want ErrUnknownVersion", err)
+	}
+}
+
+func TestRunCommandUsage(t *testing.T) {
+	for _, args := range [][]string{nil, {"sideways"}, {"up", "now"}, {"baseline"}, {"baseline", "latest"}} {
+		if err := RunCommand(context.Background(), nil, args, io.Discard); !errors.Is(err, ErrUsage) {
+			t.Errorf("%q: err = %v, want ErrUsage", args, err)
+		}
+	}
+}
+
+// TestBaselineHandMigratedDatabase needs TEST_DATABASE_URL and works in a
+// scratch schema so the database itself is left alone.
+func TestBaselineHandMigratedDatabase(t *testing.T) {
+	db := scratchSchema(t)
+	ctx := context.Background()
+	m, err := New(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := m.migrations[0]
+
+	// the first migration applied by hand, without any bookkeeping
+	if _, err := db.ExecContext(ctx, first.Up); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Up(ctx); !errors.Is(err, ErrUntracked) {
+		t.Fatalf("Up on an untracked schema: %v, want ErrUntracked", err)
+	}
+
+	recorded, err := m.Baseline(ctx, first.Version)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recorded) != 1 || recorded[0].Version != first.Version {
+		t.Fatalf("recorded %+v", recorded)
+	}
+	if again, err := m.Baseline(ctx, first.Version); err != nil || len(again) != 0 {
+		t.Fatalf("second baseline: %v, %+v", err, again)
+	}
+
+	ran, err := m.Up(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ran) != len(m.migrations)-1 {
+		t.Fatalf("Up ran %d migrations, want %d", len(ran), len(m.migrations)-1)
+	}
+}
+
+func scratchSchema(t *testing.T) *sql.DB {
+	t.Helper()
+	base := os.Getenv("TEST_DATABASE_URL")
+	if base == "" {
+		t.Skip("TEST_DATABASE_URL not set")
+	}
+	schema := fmt.Sprintf("migrate_test_%d", time.Now().UnixNano())
+
+	admin, err := sql.Open("postgres", base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer admin.Close()
+	if _, err := admin.Exec(`CREATE SCHEMA ` + schema); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		admin, err := sql.Open("postgres", base)
+		if err != nil {
+			return
+		}
+		defer admin.Close()
+		admin.Exec(`DROP SCHEMA ` + schema + ` CASCADE`)
+	})
+
+	dsn := base + " search_path=" + schema
+	if strings.Contains(base, "://") {
+		u, err := url.Parse(base)
+		if err != nil {
+			t.Fatal(err)
+		}
+		q := u.Query()
+		q.Set("search_path", schema)
+		u.RawQuery = q.Encode()
+		dsn = u.String()
+	}
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
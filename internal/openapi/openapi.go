@@ -0,0 +1,89 @@
+package openapi
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+//go:embed openapi.json
+var spec []byte
+
+//go:embed docs.html
+var docs []byte
+
+//go:embed swagger-ui/swagger-ui-bundle.js swagger-ui/swagger-ui.css
+var assets embed.FS
+
+// Spec serves the OpenAPI document.
+func Spec(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(spec)
+}
+
+// Docs serves a Swagger UI page pointed at the spec.
+func Docs(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(docs)
+}
+
+// DocsAsset serves the Swagger UI files the docs page loads, vendored in
+// swagger-ui/ rather than pulled from a CDN.
+func DocsAsset(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("asset")
+	data, err := assets.ReadFile("swagger-ui/" + name)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	http.ServeContent(w, r, name, time.Time{}, bytes.NewReader(data))
+}
+
+// Missing returns the ServeMux patterns ("METHOD /path") that have no matching
+// operation in the spec. Paths must match exactly, apart from the names of
+// wildcards, so subtree patterns ending in "/" are always reported: they
+// accept paths no spec entry describes. Patterns without a method are ignored.
+func Missing(patterns []string) ([]string, error) {
+	var doc struct {
+		Paths map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	if err := json.Unmarshal(spec, &doc); err != nil {
+		return nil, err
+	}
+	documented := map[string]bool{}
+	for path, ops := range doc.Paths {
+		for method := range ops {
+			documented[strings.ToUpper(method)+" "+normalizePath(path)] = true
+		}
+	}
+
+	var missing []string
+	for _, p := range patterns {
+		method, path, ok := strings.Cut(p, " ")
+		if !ok {
+			continue
+		}
+		if !documented[method+" "+normalizePath(path)] {
+			missing = append(missing, p)
+		}
+	}
+	sort.Strings(missing)
+	return missing, nil
+}
+
+// normalizePath blanks out wildcard names, so /a/{id} and /a/{appointmentID}
+// compare equal.
+func normalizePath(path string) string {
+	segs := strings.Split(path, "/")
+	for i, seg := range segs {
+		if strings.HasPrefix(seg, "{") && strings.HasSuffix(seg, "}") {
+			segs[i] = "{}"
+		}
+	}
+	return strings.Join(segs, "/")
+}
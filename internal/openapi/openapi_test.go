@@ -0,0 +1,25 @@
+package openapi
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMissingMatchesExactPaths(t *testing.T) {
+	got, err := Missing([]string{
+		"GET /api/me",                           // documented
+		"PATCH /api/appointments/{appointment}", // documented as {id}
+		"DELETE /api/appointments/",             // subtree, never documented
+		"GET /api/login/",                       // prefix of documented paths
+		"POST /api/me",                          // path documented, method not
+		"GET /api/nowhere",                      // not documented at all
+		"/api/anything",                         // no method, ignored
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"DELETE /api/appointments/", "GET /api/login/", "GET /api/nowhere", "POST /api/me"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Missing = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,86 @@
+package ratelimit
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"github.com/nickg76/garage-backend/internal/db"
+	"github.com/nickg76/garage-backend/internal/tracing"
+)
+
+// staleAfter is how long an untouched bucket row is kept. It comfortably
+// exceeds any refill time we configure.
+const staleAfter = 24 * time.Hour
+
+// Postgres keeps buckets in the rate_limit_buckets table so every replica
+// draws from the same ones. Each Allow locks its bucket row for the length of
+// a short transaction.
+type Postgres struct {
+	db        *sql.DB
+	lastSweep atomic.Int64
+}
+
+func NewPostgres(conn *sql.DB) *Postgres {
+	p := &Postgres{db: conn}
+	p.lastSweep.Store(time.Now().Unix())
+	return p
+}
+
+func (p *Postgres) Allow(ctx context.Context, key string, l Limit) (Result, error) {
+	if l.Unlimited() {
+		return Result{Allowed: true}, nil
+	}
+	// TIMESTAMP columns carry no zone, always store UTC
+	now := time.Now().UTC()
+
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Result{}, err
+	}
+	defer tx.Rollback()
+	q := db.New(tracing.WrapDB(tx))
+
+	if err := q.EnsureRateLimitBucket(ctx, db.EnsureRateLimitBucketParams{
+		Key:       key,
+		Tokens:    float64(l.Burst),
+		UpdatedAt: now,
+	}); err != nil {
+		return Result{}, err
+	}
+	b, err := q.GetRateLimitBucketForUpdate(ctx, key)
+	if err != nil {
+		return Result{}, err
+	}
+	tokens, res := take(b.Tokens, b.UpdatedAt, now, l)
+	if err := q.UpdateRateLimitBucket(ctx, db.UpdateRateLimitBucketParams{
+		Key:       key,
+		Tokens:    tokens,
+		UpdatedAt: now,
+	}); err != nil {
+		return Result{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return Result{}, err
+	}
+
+	p.maybeSweep(now)
+	return res, nil
+}
+
+// maybeSweep deletes stale rows at most once an hour per replica.
+func (p *Postgres) maybeSweep(now time.Time) {
+	last := p.lastSweep.Load()
+	if now.Unix()-last < int64(time.Hour/time.Second) || !p.lastSweep.CompareAndSwap(last, now.Unix()) {
+		return
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := db.New(p.db).DeleteStaleRateLimitBuckets(ctx, now.Add(-staleAfter)); err != nil {
+			slog.Error("ratelimit: purging stale buckets", "err", err)
+		}
+	}()
+}
@@ -0,0 +1,56 @@
+// Package ratelimit implements token bucket rate limiting. Buckets live in
+// process memory, or in Postgres when several replicas must share them.
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"time"
+)
+
+// Limit is a bucket refilling at PerMinute tokens a minute and holding at
+// most Burst of them. A Limit without a positive rate and burst, including
+// the zero value, doesn't limit anything.
+type Limit struct {
+	PerMinute float64
+	Burst     int
+}
+
+// Unlimited reports whether l lets every request through.
+func (l Limit) Unlimited() bool {
+	return l.PerMinute <= 0 || l.Burst <= 0
+}
+
+// Result says whether a request may proceed and, if not, how long until a
+// token is available.
+type Result struct {
+	Allowed    bool
+	RetryAfter time.Duration
+}
+
+// Limiter takes one token from the bucket named key, creating it full the
+// first time it is seen. Unlimited limits are allowed without touching a
+// bucket.
+type Limiter interface {
+	Allow(ctx context.Context, key string, l Limit) (Result, error)
+}
+
+// take refills a bucket that held tokens at last and tries to remove one at
+// now. It returns the bucket's new level.
+func take(tokens float64, last, now time.Time, l Limit) (float64, Result) {
+	perSecond := l.PerMinute / 60
+	if elapsed := now.Sub(last).Seconds(); elapsed > 0 {
+		tokens = math.Min(float64(l.Burst), tokens+elapsed*perSecond)
+	}
+	if tokens >= 1 {
+		return tokens - 1, Result{Allowed: true}
+	}
+	wait := time.Duration((1 - tokens) / perSecond * float64(time.Second))
+	return tokens, Result{RetryAfter: wait}
+}
+
+// idleFor is how long an untouched bucket takes to refill completely, after
+// which it can be forgotten.
+func idleFor(l Limit) time.Duration {
+	return time.Duration(float64(l.Burst) / l.PerMinute * float64(time.Minute))
+}
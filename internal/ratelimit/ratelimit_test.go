@@ -0,0 +1,57 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestTake(t *testing.T) {
+	l := Limit{PerMinute: 60, Burst: 2}
+	now := time.Now()
+
+	tokens, res := take(2, now, now, l)
+	if !res.Allowed || tokens != 1 {
+		t.Fatalf("full bucket: %v, %+v", tokens, res)
+	}
+	tokens, res = take(0.5, now, now, l)
+	if res.Allowed || res.RetryAfter != 500*time.Millisecond || tokens != 0.5 {
+		t.Fatalf("empty bucket: %v, %+v", tokens, res)
+	}
+	// refills at a token a second, never beyond the burst
+	if tokens, _ = take(0, now, now.Add(time.Hour), l); tokens != 1 {
+		t.Fatalf("refilled to %v, want burst 2 minus the token taken", tokens)
+	}
+}
+
+func TestMemoryZeroLimitAllows(t *testing.T) {
+	m := NewMemory()
+	for _, l := range []Limit{{}, {PerMinute: 5}, {Burst: 5}} {
+		for i := range 20 {
+			res, err := m.Allow(context.Background(), "k", l)
+			if err != nil || !res.Allowed {
+				t.Fatalf("limit %+v, request %d: %+v, %v", l, i, res, err)
+			}
+		}
+	}
+	if len(m.buckets) != 0 {
+		t.Fatalf("unlimited requests created %d buckets", len(m.buckets))
+	}
+}
+
+func TestMemoryLimits(t *testing.T) {
+	m := NewMemory()
+	l := Limit{PerMinute: 1, Burst: 3}
+	for i := range 3 {
+		if res, _ := m.Allow(context.Background(), "k", l); !res.Allowed {
+			t.Fatalf("request %d within the burst refused", i)
+		}
+	}
+	res, _ := m.Allow(context.Background(), "k", l)
+	if res.Allowed || res.RetryAfter <= 0 || res.RetryAfter > time.Minute {
+		t.Fatalf("over the burst: %+v", res)
+	}
+	if res, _ := m.Allow(context.Background(), "other", l); !res.Allowed {
+		t.Fatal("buckets are shared between keys")
+	}
+}
@@ -0,0 +1,79 @@
+// Package response lets middleware observe what a handler wrote: the status
+// code, the number of body bytes and, when asked, the body itself.
+//
+// Every middleware that needs this wraps with Writer rather than growing its
+// own http.ResponseWriter, so passing Flush through for server-sent events and
+// Unwrap for http.ResponseController only has to be right in one place.
+package response
+
+import (
+	"bytes"
+	"net/http"
+)
+
+// Writer records a response while passing it through to the wrapped writer.
+type Writer struct {
+	http.ResponseWriter
+	status      int
+	bytes       int64
+	wroteHeader bool
+	body        *bytes.Buffer
+}
+
+// Wrap returns a Writer around w.
+func Wrap(w http.ResponseWriter) *Writer {
+	return &Writer{ResponseWriter: w, status: http.StatusOK}
+}
+
+// Capture makes the Writer keep a copy of everything written from now on,
+// available from Body.
+func (w *Writer) Capture() {
+	if w.body == nil {
+		w.body = &bytes.Buffer{}
+	}
+}
+
+// Status is the status code sent, 200 if the handler wrote without setting one.
+func (w *Writer) Status() int { return w.status }
+
+// Bytes is the number of body bytes written.
+func (w *Writer) Bytes() int64 { return w.bytes }
+
+// Body is the captured body, nil unless Capture was called.
+func (w *Writer) Body() []byte {
+	if w.body == nil {
+		return nil
+	}
+	return w.body.Bytes()
+}
+
+func (w *Writer) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.status = code
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *Writer) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	if w.body != nil {
+		w.body.Write(b[:n])
+	}
+	return n, err
+}
+
+// Flush passes through to the wrapped writer so streams aren't buffered.
+func (w *Writer) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		w.wroteHeader = true
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *Writer) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
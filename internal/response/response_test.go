@@ -0,0 +1,73 @@
+package response
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestWriterRecordsFirstStatusAndSize(t *testing.T) {
+	rec := httptest.NewRecorder()
+	w := Wrap(rec)
+	w.WriteHeader(http.StatusCreated)
+	w.WriteHeader(http.StatusInternalServerError) // superfluous, not recorded
+	w.Write([]byte("hello"))
+	w.Write([]byte(" world"))
+
+	if w.Status() != http.StatusCreated || w.Bytes() != 11 {
+		t.Fatalf("status %d bytes %d, want 201 and 11", w.Status(), w.Bytes())
+	}
+	if w.Body() != nil {
+		t.Fatal("body captured without Capture")
+	}
+	if rec.Body.String() != "hello world" {
+		t.Fatalf("passed through %q", rec.Body)
+	}
+}
+
+func TestWriterImplicitOK(t *testing.T) {
+	w := Wrap(httptest.NewRecorder())
+	w.Write([]byte("x"))
+	w.WriteHeader(http.StatusTeapot)
+	if w.Status() != http.StatusOK {
+		t.Fatalf("status %d, want 200 once the body started", w.Status())
+	}
+}
+
+func TestWriterCapture(t *testing.T) {
+	w := Wrap(httptest.NewRecorder())
+	w.Write([]byte("before "))
+	w.Capture()
+	w.Write([]byte("after"))
+	if string(w.Body()) != "after" {
+		t.Fatalf("captured %q", w.Body())
+	}
+}
+
+type deadlineRecorder struct {
+	*httptest.ResponseRecorder
+	deadline time.Time
+}
+
+func (d *deadlineRecorder) SetWriteDeadline(t time.Time) error {
+	d.deadline = t
+	return nil
+}
+
+func TestWriterFlushAndResponseController(t *testing.T) {
+	rec := &deadlineRecorder{ResponseRecorder: httptest.NewRecorder()}
+	w := Wrap(Wrap(rec)) // middleware nests
+	w.Write([]byte("data: x\n\n"))
+	w.Flush()
+	if !rec.Flushed {
+		t.Fatal("Flush did not reach the underlying writer")
+	}
+	// SSE clears the server's write deadline through a ResponseController
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}.Add(time.Hour)); err != nil {
+		t.Fatalf("SetWriteDeadline: %v", err)
+	}
+	if rec.deadline.IsZero() {
+		t.Fatal("deadline did not reach the underlying writer")
+	}
+}
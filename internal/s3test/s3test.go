@@ -0,0 +1,218 @@
+// Package s3test is an in-memory stand-in for an S3-compatible object store,
+// for exercising storage.S3 without AWS or MinIO.
+//
+// It serves path-style PUT, GET and DELETE for a single bucket and checks
+// every request's Signature Version 4 Authorization header against its own
+// credentials, so signing bugs fail the same way they would against S3.
+package s3test
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Object is a stored blob.
+type Object struct {
+	Body        []byte
+	ContentType string
+}
+
+// Server holds the objects of Bucket.
+type Server struct {
+	Bucket    string
+	Region    string
+	AccessKey string
+	SecretKey string
+
+	mu      sync.Mutex
+	objects map[string]Object
+}
+
+// New returns a stand-in for bucket accepting requests signed with the
+// given credentials for region us-east-1.
+func New(bucket, accessKey, secretKey string) *Server {
+	return &Server{
+		Bucket:    bucket,
+		Region:    "us-east-1",
+		AccessKey: accessKey,
+		SecretKey: secretKey,
+		objects:   map[string]Object{},
+	}
+}
+
+// Start runs a new stand-in on a local port. Close the returned server when done.
+func Start(bucket, accessKey, secretKey string) (*Server, *httptest.Server) {
+	s := New(bucket, accessKey, secretKey)
+	return s, httptest.NewServer(s)
+}
+
+// Object returns the stored object for key.
+func (s *Server) Object(key string) (Object, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	o, ok := s.objects[key]
+	return o, ok
+}
+
+// Keys returns the stored keys in order.
+func (s *Server) Keys() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := make([]string, 0, len(s.objects))
+	for k := range s.objects {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "IncompleteBody")
+		return
+	}
+	if code := s.verify(r, body); code != "" {
+		writeError(w, http.StatusForbidden, code)
+		return
+	}
+
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if bucket != s.Bucket {
+		writeError(w, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+	if key == "" {
+		writeError(w, http.StatusBadRequest, "InvalidRequest")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch r.Method {
+	case http.MethodPut:
+		s.objects[key] = Object{Body: body, ContentType: r.Header.Get("Content-Type")}
+	case http.MethodGet:
+		o, ok := s.objects[key]
+		if !ok {
+			writeError(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		if o.ContentType != "" {
+			w.Header().Set("Content-Type", o.ContentType)
+		}
+		w.Write(o.Body)
+	case http.MethodDelete:
+		// S3 answers 204 whether or not the key existed
+		delete(s.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "MethodNotAllowed")
+	}
+}
+
+// verify checks the SigV4 Authorization header and returns an S3 error code
+// when it doesn't hold up.
+func (s *Server) verify(r *http.Request, body []byte) string {
+	const prefix = "AWS4-HMAC-SHA256 "
+	authz := r.Header.Get("Authorization")
+	if !strings.HasPrefix(authz, prefix) {
+		return "AccessDenied"
+	}
+	fields := map[string]string{}
+	for _, part := range strings.Split(strings.TrimPrefix(authz, prefix), ",") {
+		k, v, _ := strings.Cut(strings.TrimSpace(part), "=")
+		fields[k] = v
+	}
+
+	amzDate := r.Header.Get("X-Amz-Date")
+	if len(amzDate) != len("20060102T150405Z") {
+		return "AccessDenied"
+	}
+	date := amzDate[:8]
+	scope := date + "/" + s.Region + "/s3/aws4_request"
+	if fields["Credential"] != s.AccessKey+"/"+scope {
+		return "InvalidAccessKeyId"
+	}
+
+	payloadHash := r.Header.Get("X-Amz-Content-Sha256")
+	if payloadHash != sha256Hex(body) {
+		return "XAmzContentSHA256Mismatch"
+	}
+
+	signed := strings.Split(fields["SignedHeaders"], ";")
+	var canonicalHeaders strings.Builder
+	for _, name := range signed {
+		v := r.Header.Get(name)
+		if name == "host" {
+			v = r.Host
+		}
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(v) + "\n")
+	}
+
+	canonicalRequest := strings.Join([]string{
+		r.Method,
+		r.URL.EscapedPath(),
+		canonicalQuery(r.URL.Query()),
+		canonicalHeaders.String(),
+		fields["SignedHeaders"],
+		payloadHash,
+	}, "\n")
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.SecretKey), date)
+	key = hmacSHA256(key, s.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	want := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	if !hmac.Equal([]byte(want), []byte(fields["Signature"])) {
+		return "SignatureDoesNotMatch"
+	}
+	return ""
+}
+
+func canonicalQuery(q url.Values) string {
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, k := range keys {
+		for _, v := range q[k] {
+			parts = append(parts, url.QueryEscape(k)+"="+url.QueryEscape(v))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+func writeError(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n<Error><Code>%s</Code></Error>", code)
+}
+
+func sha256Hex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
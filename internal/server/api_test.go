@@ -0,0 +1,584 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"image"
+	"image/png"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode"
+
+	"github.com/google/uuid"
+
+	"github.com/nickg76/garage-backend/internal/auth"
+	"github.com/nickg76/garage-backend/internal/config"
+	"github.com/nickg76/garage-backend/internal/db"
+	"github.com/nickg76/garage-backend/internal/handlers"
+	"github.com/nickg76/garage-backend/internal/mail"
+	"github.com/nickg76/garage-backend/internal/storage"
+	"github.com/nickg76/garage-backend/internal/store"
+)
+
+// mailbox is a mail.Sender that keeps what it is given.
+type mailbox struct {
+	mu   sync.Mutex
+	msgs []mail.Message
+}
+
+func (m *mailbox) Send(ctx context.Context, msg mail.Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.msgs = append(m.msgs, msg)
+	return nil
+}
+
+// waitFor returns the first message to addr, sending happens after the
+// response.
+func (m *mailbox) waitFor(t *testing.T, addr string) mail.Message {
+	t.Helper()
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		m.mu.Lock()
+		for _, msg := range m.msgs {
+			if msg.To == addr {
+				m.mu.Unlock()
+				return msg
+			}
+		}
+		m.mu.Unlock()
+	}
+	t.Fatalf("no mail to %s", addr)
+	return mail.Message{}
+}
+
+// apiTest drives the full route table over HTTP against store.Memory and
+// remembers which route patterns were exercised.
+type apiTest struct {
+	t       *testing.T
+	srv     *httptest.Server
+	store   *store.Memory
+	files   storage.Storage
+	mail    *mailbox
+	covered map[string]bool
+}
+
+func newAPITest(t *testing.T, configure ...func(*config.Config)) *apiTest {
+	t.Helper()
+	files, err := storage.NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &apiTest{t: t, store: store.NewMemory(), files: files, mail: &mailbox{}, covered: map[string]bool{}}
+	cfg := config.Default()
+	cfg.JWTSecret = "test-secret"
+	cfg.MFA.SecretKey = "AAECAwQFBgcICQoLDA0ODxAREhMUFRYXGBkaGxwdHh8="
+	// every request comes from the same address
+	cfg.RateLimit.IPPerMinute, cfg.RateLimit.IPBurst = 600, 600
+	cfg.RateLimit.AccountPerMinute, cfg.RateLimit.AccountBurst = 600, 600
+	cfg.Lockout.Threshold = 3
+	cfg.WebAuthn.RPID, cfg.WebAuthn.Origins = "localhost", []string{"http://localhost"}
+	cfg.MagicLink.URL = "http://localhost/magic"
+	for _, f := range configure {
+		f(cfg)
+	}
+	s, err := handlers.NewServerWith(cfg, handlers.Deps{Store: a.store, Files: files, Mail: a.mail})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.srv = httptest.NewServer(Routes(s))
+	t.Cleanup(func() {
+		s.Shutdown()
+		a.srv.Close()
+		s.Close()
+	})
+	return a
+}
+
+// call sends a request for the route registered as pattern. body is sent
+// as is when it is an io.Reader and as JSON otherwise; hdr holds header
+// name/value pairs.
+func (a *apiTest) call(pattern, path, token string, body any, hdr ...string) (*http.Response, []byte) {
+	a.t.Helper()
+	a.covered[pattern] = true
+	method, _, _ := strings.Cut(pattern, " ")
+
+	var rd io.Reader
+	switch b := body.(type) {
+	case nil:
+	case io.Reader:
+		rd = b
+	default:
+		buf, err := json.Marshal(b)
+		if err != nil {
+			a.t.Fatal(err)
+		}
+		rd = bytes.NewReader(buf)
+	}
+	req, err := http.NewRequest(method, a.srv.URL+path, rd)
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	if rd != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	for i := 0; i+1 < len(hdr); i += 2 {
+		req.Header.Set(hdr[i], hdr[i+1])
+	}
+	resp, err := a.srv.Client().Do(req)
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	out, err := io.ReadAll(resp.Body)
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	return resp, out
+}
+
+// expect is call plus a status check, decoding a JSON answer into into.
+func (a *apiTest) expect(status int, into any, pattern, path, token string, body any, hdr ...string) *http.Response {
+	a.t.Helper()
+	resp, out := a.call(pattern, path, token, body, hdr...)
+	if resp.StatusCode != status {
+		a.t.Fatalf("%s %s: status %d, want %d\n%s", pattern, path, resp.StatusCode, status, out)
+	}
+	if into != nil {
+		if err := json.Unmarshal(out, into); err != nil {
+			a.t.Fatalf("%s %s: %v\n%s", pattern, path, err, out)
+		}
+	}
+	return resp
+}
+
+func (a *apiTest) register(name, email, password string) {
+	a.t.Helper()
+	a.expect(http.StatusOK, nil, "POST /api/register", "/api/register", "",
+		map[string]string{"name": name, "email": email, "phone": "01234 567890", "password": password})
+}
+
+func (a *apiTest) login(email, password string) string {
+	a.t.Helper()
+	var resp struct{ Token string }
+	a.expect(http.StatusOK, &resp, "POST /api/login", "/api/login", "",
+		map[string]string{"email": email, "password": password})
+	if resp.Token == "" {
+		a.t.Fatalf("login %s: no token", email)
+	}
+	return resp.Token
+}
+
+// events opens the SSE stream for token and returns the data lines.
+func (a *apiTest) events(token string) <-chan string {
+	a.t.Helper()
+	a.covered["GET /api/events"] = true
+	ctx, cancel := context.WithCancel(context.Background())
+	a.t.Cleanup(cancel)
+	req, _ := http.NewRequestWithContext(ctx, "GET", a.srv.URL+"/api/events?token="+url.QueryEscape(token), nil)
+	resp, err := a.srv.Client().Do(req)
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		a.t.Fatalf("events: status %d", resp.StatusCode)
+	}
+	lines := make(chan string, 16)
+	connected := make(chan struct{})
+	go func() {
+		defer resp.Body.Close()
+		defer close(lines)
+		sc := bufio.NewScanner(resp.Body)
+		for sc.Scan() {
+			if sc.Text() == ": connected" {
+				close(connected)
+			}
+			if data, ok := strings.CutPrefix(sc.Text(), "data: "); ok {
+				lines <- data
+			}
+		}
+	}()
+	select {
+	case <-connected:
+	case <-time.After(2 * time.Second):
+		a.t.Fatal("events: stream did not open")
+	}
+	return lines
+}
+
+func nextEvent(t *testing.T, lines <-chan string) handlers.Event {
+	t.Helper()
+	select {
+	case data, ok := <-lines:
+		if !ok {
+			t.Fatal("event stream closed")
+		}
+		var ev handlers.Event
+		if err := json.Unmarshal([]byte(data), &ev); err != nil {
+			t.Fatalf("event %q: %v", data, err)
+		}
+		return ev
+	case <-time.After(2 * time.Second):
+		t.Fatal("no event")
+	}
+	return handlers.Event{}
+}
+
+func pngUpload(t *testing.T, filename string) (io.Reader, string) {
+	t.Helper()
+	var img bytes.Buffer
+	if err := png.Encode(&img, image.NewRGBA(image.Rect(0, 0, 32, 32))); err != nil {
+		t.Fatal(err)
+	}
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, err := mw.CreateFormFile("file", filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fw.Write(img.Bytes())
+	mw.Close()
+	return &body, mw.FormDataContentType()
+}
+
+// TestAPIRoutes walks a customer and a member of staff through every route
+// in the table, with everything stored in memory.
+func TestAPIRoutes(t *testing.T) {
+	// the member of staff signs in with a password only
+	a := newAPITest(t, func(cfg *config.Config) { cfg.MFA.RequireForAdmins = false })
+	ctx := context.Background()
+
+	a.register("Ann", "ann@example.com", "ann-password")
+	a.register("Bob", "bob@example.com", "bob-password")
+	if _, err := a.store.SetAdmin(ctx, db.SetAdminParams{Email: "bob@example.com", IsAdmin: sql.NullBool{Bool: true, Valid: true}}); err != nil {
+		t.Fatal(err)
+	}
+	ann := a.login("ann@example.com", "ann-password")
+	staff := a.login("bob@example.com", "bob-password")
+
+	var me struct {
+		Email string
+		Admin bool
+	}
+	a.expect(http.StatusOK, &me, "GET /api/me", "/api/me", ann, nil)
+	if me.Email != "ann@example.com" || me.Admin {
+		t.Fatalf("me = %+v", me)
+	}
+
+	// booking, retried with the same Idempotency-Key
+	type appt struct {
+		ID, Title, Status string
+		Version           int32
+	}
+	booking := map[string]string{"datetime": time.Now().Add(72 * time.Hour).UTC().Format(time.RFC3339), "title": "MOT"}
+	var booked, replayed appt
+	a.expect(http.StatusOK, &booked, "POST /api/appointments", "/api/appointments", ann, booking, "Idempotency-Key", "book-1")
+	resp := a.expect(http.StatusOK, &replayed, "POST /api/appointments", "/api/appointments", ann, booking, "Idempotency-Key", "book-1")
+	if replayed.ID != booked.ID || resp.Header.Get("Idempotent-Replayed") != "true" {
+		t.Fatalf("retry booked %s (replayed %q), want %s", replayed.ID, resp.Header.Get("Idempotent-Replayed"), booked.ID)
+	}
+	apptPath := "/api/appointments/" + booked.ID
+
+	var mine []appt
+	a.expect(http.StatusOK, &mine, "GET /api/appointments", "/api/appointments", ann, nil)
+	if len(mine) != 1 {
+		t.Fatalf("my appointments = %+v", mine)
+	}
+	resp = a.expect(http.StatusOK, nil, "GET /api/appointments/{id}", apptPath, ann, nil)
+	etag := resp.Header.Get("ETag")
+
+	var edited appt
+	resp = a.expect(http.StatusOK, &edited, "PATCH /api/appointments/{id}", apptPath, ann,
+		map[string]string{"title": "MOT and service"}, "If-Match", etag)
+	if edited.Title != "MOT and service" {
+		t.Fatalf("patched = %+v", edited)
+	}
+	booking["title"] = "Full service"
+	resp = a.expect(http.StatusOK, &edited, "PUT /api/appointments/{id}", apptPath, ann,
+		booking, "If-Match", resp.Header.Get("ETag"))
+	etag = resp.Header.Get("ETag")
+
+	// messages
+	var msgs []struct {
+		Body          string
+		SenderIsStaff bool   `json:"sender_is_staff"`
+		ReadAt        string `json:"read_at"`
+	}
+	a.expect(http.StatusCreated, nil, "POST /api/appointments/{id}/messages", apptPath+"/messages", staff,
+		map[string]string{"body": "Can you bring the V5C?"}, "Idempotency-Key", "msg-1")
+	a.expect(http.StatusOK, &msgs, "GET /api/appointments/{id}/messages", apptPath+"/messages", ann, nil)
+	if len(msgs) != 1 || !msgs[0].SenderIsStaff || msgs[0].ReadAt == "" {
+		t.Fatalf("messages = %+v, want the staff message marked read", msgs)
+	}
+
+	// attachments, downloaded through the signed URL without a token
+	body, contentType := pngUpload(t, "dent.png")
+	var att struct {
+		ID           string
+		URL          string
+		ThumbnailURL string `json:"thumbnail_url"`
+	}
+	a.expect(http.StatusCreated, &att, "POST /api/appointments/{id}/attachments", apptPath+"/attachments", ann,
+		body, "Content-Type", contentType)
+	var atts []struct{ ID string }
+	a.expect(http.StatusOK, &atts, "GET /api/appointments/{id}/attachments", apptPath+"/attachments", staff, nil)
+	if len(atts) != 1 || atts[0].ID != att.ID {
+		t.Fatalf("attachments = %+v", atts)
+	}
+	resp, got := a.call("GET /api/attachments/{id}", att.URL, "", nil)
+	if resp.StatusCode != http.StatusOK || !bytes.HasPrefix(got, []byte("\x89PNG")) {
+		t.Fatalf("download: status %d", resp.StatusCode)
+	}
+	if resp, _ := a.call("GET /api/attachments/{id}", "/api/attachments/"+att.ID, "", nil); resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("unsigned download: status %d, want 403", resp.StatusCode)
+	}
+
+	// staff accept the booking and the customer hears about it
+	stream := a.events(ann)
+	var all []appt
+	a.expect(http.StatusOK, &all, "GET /api/admin/appointments", "/api/admin/appointments", staff, nil)
+	if len(all) != 1 {
+		t.Fatalf("admin appointments = %+v", all)
+	}
+	a.expect(http.StatusForbidden, nil, "GET /api/admin/appointments", "/api/admin/appointments", ann, nil)
+	a.expect(http.StatusNoContent, nil, "PATCH /api/admin/appointments/{id}/status", "/api/admin/appointments/"+booked.ID+"/status",
+		staff, map[string]string{"status": "accepted"}, "If-Match", etag)
+	if ev := nextEvent(t, stream); ev.Type != "appointment_status" || ev.Status != "accepted" || ev.Appointment != booked.ID {
+		t.Fatalf("event = %+v", ev)
+	}
+
+	// lockout after repeated wrong passwords
+	a.register("Cat", "cat@example.com", "cat-password")
+	for range 3 {
+		a.expect(http.StatusUnauthorized, nil, "POST /api/login", "/api/login", "",
+			map[string]string{"email": "cat@example.com", "password": "wrong"})
+	}
+	var locked []struct{ Email string }
+	a.expect(http.StatusOK, &locked, "GET /api/admin/locked-accounts", "/api/admin/locked-accounts", staff, nil)
+	if len(locked) != 1 || locked[0].Email != "cat@example.com" {
+		t.Fatalf("locked accounts = %+v", locked)
+	}
+
+	// sign-in link
+	a.expect(http.StatusAccepted, nil, "POST /api/login/magic", "/api/login/magic", "",
+		map[string]string{"email": "ann@example.com"})
+	link := a.mail.waitFor(t, "ann@example.com").Body
+	i := strings.Index(link, "http://localhost/magic?")
+	u, err := url.Parse(strings.Fields(link[i:])[0])
+	if i < 0 || err != nil {
+		t.Fatalf("no sign-in link in %q", link)
+	}
+	var session struct{ Token string }
+	a.expect(http.StatusOK, &session, "POST /api/login/magic/verify", "/api/login/magic/verify", "",
+		map[string]string{"email": "ann@example.com", "token": u.Query().Get("token")})
+	if session.Token == "" {
+		t.Fatal("sign-in link gave no session")
+	}
+
+	// TOTP enrollment, then a two-step login with a recovery code
+	var enroll struct{ Secret string }
+	a.expect(http.StatusOK, &enroll, "POST /api/mfa/totp/enroll", "/api/mfa/totp/enroll", ann, nil)
+	code, err := auth.TOTPCode(enroll.Secret, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var confirmed struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+	a.expect(http.StatusOK, &confirmed, "POST /api/mfa/totp/confirm", "/api/mfa/totp/confirm", ann,
+		map[string]string{"code": code})
+	var challenge struct {
+		MFARequired bool   `json:"mfa_required"`
+		MFAToken    string `json:"mfa_token"`
+	}
+	a.expect(http.StatusOK, &challenge, "POST /api/login", "/api/login", "",
+		map[string]string{"email": "ann@example.com", "password": "ann-password"})
+	if !challenge.MFARequired || len(confirmed.RecoveryCodes) == 0 {
+		t.Fatalf("login after enrollment = %+v", challenge)
+	}
+	a.expect(http.StatusOK, &session, "POST /api/login/mfa", "/api/login/mfa", "",
+		map[string]string{"mfa_token": challenge.MFAToken, "recovery_code": confirmed.RecoveryCodes[0]})
+
+	// passkeys: the ceremonies start, an unsigned response is refused
+	var keys []any
+	a.expect(http.StatusOK, &keys, "GET /api/passkeys", "/api/passkeys", ann, nil)
+	if len(keys) != 0 {
+		t.Fatalf("passkeys = %+v", keys)
+	}
+	a.expect(http.StatusOK, nil, "POST /api/passkeys/register/begin", "/api/passkeys/register/begin", ann, nil)
+	a.expect(http.StatusBadRequest, nil, "POST /api/passkeys/register/finish", "/api/passkeys/register/finish", ann, map[string]string{})
+	a.expect(http.StatusNotFound, nil, "DELETE /api/passkeys/{id}", "/api/passkeys/"+uuid.NewString(), ann, nil)
+	a.expect(http.StatusOK, nil, "POST /api/login/passkey/begin", "/api/login/passkey/begin", "", nil)
+	a.expect(http.StatusBadRequest, nil, "POST /api/login/passkey/finish", "/api/login/passkey/finish", "", map[string]string{})
+
+	// single sign-on without providers configured
+	var providers []any
+	a.expect(http.StatusOK, &providers, "GET /api/login/oidc", "/api/login/oidc", "", nil)
+	if len(providers) != 0 {
+		t.Fatalf("providers = %+v", providers)
+	}
+	a.expect(http.StatusNotFound, nil, "POST /api/login/oidc/{provider}/begin", "/api/login/oidc/google/begin", "", nil)
+	a.expect(http.StatusUnauthorized, nil, "POST /api/login/oidc/finish", "/api/login/oidc/finish", "",
+		map[string]string{"state": "forged", "code": "x"})
+
+	// cancelling removes the booking and its files
+	a.expect(http.StatusNoContent, nil, "DELETE /api/appointments/{id}", apptPath, ann, nil)
+	a.expect(http.StatusNotFound, nil, "GET /api/appointments/{id}", apptPath, ann, nil)
+	if resp, _ := a.call("GET /api/attachments/{id}", att.URL, "", nil); resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("download after cancelling: status %d, want 404", resp.StatusCode)
+	}
+	if _, err := a.files.Get(ctx, "attachments/"+booked.ID+"/"+att.ID); err != storage.ErrNotFound {
+		t.Fatalf("attachment blob after cancelling: %v", err)
+	}
+
+	// API description
+	a.expect(http.StatusOK, nil, "GET /api/openapi.json", "/api/openapi.json", "", nil)
+	a.expect(http.StatusOK, nil, "GET /api/docs", "/api/docs", "", nil)
+	a.expect(http.StatusOK, nil, "GET /api/docs/{asset}", "/api/docs/swagger-ui.css", "", nil)
+
+	for _, p := range apiRoutes(newTestServer(t)).patterns {
+		if !a.covered[p] {
+			t.Errorf("route %q is not exercised by TestAPIRoutes", p)
+		}
+	}
+}
+
+// TestAPIRequiresSession checks every route behind AuthMiddleware refuses a
+// request without a token.
+func TestAPIRequiresSession(t *testing.T) {
+	a := newAPITest(t)
+	id := uuid.NewString()
+	for _, c := range []struct{ pattern, path string }{
+		{"GET /api/me", "/api/me"},
+		{"GET /api/appointments", "/api/appointments"},
+		{"POST /api/appointments", "/api/appointments"},
+		{"GET /api/appointments/{id}", "/api/appointments/" + id},
+		{"DELETE /api/appointments/{id}", "/api/appointments/" + id},
+		{"PATCH /api/appointments/{id}", "/api/appointments/" + id},
+		{"PUT /api/appointments/{id}", "/api/appointments/" + id},
+		{"GET /api/appointments/{id}/messages", "/api/appointments/" + id + "/messages"},
+		{"POST /api/appointments/{id}/messages", "/api/appointments/" + id + "/messages"},
+		{"GET /api/appointments/{id}/attachments", "/api/appointments/" + id + "/attachments"},
+		{"POST /api/appointments/{id}/attachments", "/api/appointments/" + id + "/attachments"},
+		{"GET /api/admin/appointments", "/api/admin/appointments"},
+		{"PATCH /api/admin/appointments/{id}/status", "/api/admin/appointments/" + id + "/status"},
+		{"GET /api/admin/locked-accounts", "/api/admin/locked-accounts"},
+		{"POST /api/mfa/totp/enroll", "/api/mfa/totp/enroll"},
+		{"POST /api/mfa/totp/confirm", "/api/mfa/totp/confirm"},
+		{"GET /api/passkeys", "/api/passkeys"},
+		{"POST /api/passkeys/register/begin", "/api/passkeys/register/begin"},
+		{"POST /api/passkeys/register/finish", "/api/passkeys/register/finish"},
+		{"DELETE /api/passkeys/{id}", "/api/passkeys/" + id},
+	} {
+		if resp, body := a.call(c.pattern, c.path, "", nil); resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("%s without a token: status %d\n%s", c.pattern, resp.StatusCode, body)
+		}
+	}
+	if resp, _ := a.call("GET /api/events", "/api/events", "", nil); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("events without a token: status %d", resp.StatusCode)
+	}
+}
+
+// TestLockedLoginLooksLikeUnknownEmail checks a locked account can't be told
+// apart from an address nobody registered.
+func TestLockedLoginLooksLikeUnknownEmail(t *testing.T) {
+	a := newAPITest(t)
+	a.register("Dan", "dan@example.com", "dan-password")
+	for range 3 {
+		a.expect(http.StatusUnauthorized, nil, "POST /api/login", "/api/login", "",
+			map[string]string{"email": "dan@example.com", "password": "wrong"})
+	}
+
+	type problem struct{ Type, Title, Detail, Code string }
+	var locked, unknown problem
+	a.expect(http.StatusUnauthorized, &locked, "POST /api/login", "/api/login", "",
+		map[string]string{"email": "dan@example.com", "password": "dan-password"})
+	resp := a.expect(http.StatusUnauthorized, &unknown, "POST /api/login", "/api/login", "",
+		map[string]string{"email": "nobody@example.com", "password": "dan-password"})
+	if locked != unknown || resp.Header.Get("Retry-After") != "" {
+		t.Fatalf("locked account answered %+v, unknown email %+v", locked, unknown)
+	}
+}
+
+// TestZeroRateLimitDoesNotLimit runs with RateLimit unset, which must not
+// turn every login into a 429.
+func TestZeroRateLimitDoesNotLimit(t *testing.T) {
+	h := Routes(newTestServer(t, func(cfg *config.Config) { cfg.RateLimit = config.RateLimitConfig{} }))
+	for i := range 50 {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("POST", "/api/login",
+			strings.NewReader(`{"email":"nobody@example.com","password":"x"}`)))
+		if w.Code != http.StatusUnauthorized {
+			t.Fatalf("attempt %d: status %d, want 401", i, w.Code)
+		}
+	}
+}
+
+func TestTOTPSecretEncryptedAtRest(t *testing.T) {
+	a := newAPITest(t)
+	a.register("Ann", "ann@example.com", "ann-password")
+	ann := a.login("ann@example.com", "ann-password")
+
+	var enroll struct{ Secret string }
+	a.expect(http.StatusOK, &enroll, "POST /api/mfa/totp/enroll", "/api/mfa/totp/enroll", ann, nil)
+	user, err := a.store.GetUserByEmail(context.Background(), "ann@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored := user.TotpSecret.String; !auth.IsSealedTOTPSecret(stored) || strings.Contains(stored, enroll.Secret) {
+		t.Fatalf("stored secret %q isn't encrypted", stored)
+	}
+
+	code, err := auth.TOTPCode(enroll.Secret, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var confirmed struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+	a.expect(http.StatusOK, &confirmed, "POST /api/mfa/totp/confirm", "/api/mfa/totp/confirm", ann,
+		map[string]string{"code": code})
+	if len(confirmed.RecoveryCodes) == 0 {
+		t.Fatal("no recovery codes")
+	}
+}
+
+func TestAttachmentFilenameNotASCII(t *testing.T) {
+	a := newAPITest(t)
+	a.register("Ann", "ann@example.com", "ann-password")
+	ann := a.login("ann@example.com", "ann-password")
+	var booked struct{ ID string }
+	a.expect(http.StatusOK, &booked, "POST /api/appointments", "/api/appointments", ann,
+		map[string]string{"datetime": time.Now().Add(72 * time.Hour).UTC().Format(time.RFC3339), "title": "MOT"})
+
+	const name = "pare-brise fêlé \"avant\".png"
+	body, contentType := pngUpload(t, name)
+	var att struct{ URL string }
+	a.expect(http.StatusCreated, &att, "POST /api/appointments/{id}/attachments", "/api/appointments/"+booked.ID+"/attachments", ann,
+		body, "Content-Type", contentType)
+	resp, _ := a.call("GET /api/attachments/{id}", att.URL, "", nil)
+	header := resp.Header.Get("Content-Disposition")
+	// clients read raw header bytes as Latin-1, so the name must be encoded
+	for _, c := range header {
+		if c > unicode.MaxASCII {
+			t.Fatalf("Content-Disposition %q is not ASCII", header)
+		}
+	}
+	disposition, params, err := mime.ParseMediaType(header)
+	if err != nil || disposition != "inline" || params["filename"] != name {
+		t.Fatalf("Content-Disposition %q parses as %q %q, %v", header, disposition, params["filename"], err)
+	}
+}
@@ -0,0 +1,292 @@
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/go-webauthn/webauthn/protocol/webauthncbor"
+)
+
+var b64url = base64.RawURLEncoding
+
+// authenticator is a software passkey for the relying party newAPITest
+// configures: rp ID localhost, origin http://localhost. It keeps a single
+// ES256 credential and reports user presence, user verification and a
+// synced (backed up) key.
+type authenticator struct {
+	t      *testing.T
+	key    *ecdsa.PrivateKey
+	credID []byte
+	// user handle the relying party gave at registration
+	user  []byte
+	count uint32
+}
+
+type creationOptions struct {
+	PublicKey struct {
+		Challenge string
+		User      struct{ ID string }
+	}
+}
+
+type assertionOptions struct {
+	PublicKey struct {
+		Challenge        string
+		AllowCredentials []json.RawMessage
+	}
+}
+
+const (
+	flagUP    = 0x01 // user present
+	flagUV    = 0x04 // user verified
+	flagBE    = 0x08 // backup eligible
+	flagBS    = 0x10 // backed up
+	flagAT    = 0x40 // attested credential data included
+	passkeyRP = "localhost"
+)
+
+func (a *authenticator) clientData(typ, challenge string) []byte {
+	b, err := json.Marshal(map[string]string{"type": typ, "challenge": challenge, "origin": "http://" + passkeyRP})
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	return b
+}
+
+// authData is the authenticator data up to and including the sign count.
+func (a *authenticator) authData(flags byte) []byte {
+	rp := sha256.Sum256([]byte(passkeyRP))
+	ad := append(rp[:], flags|flagUP|flagUV|flagBE|flagBS)
+	return binary.BigEndian.AppendUint32(ad, a.count)
+}
+
+// create answers navigator.credentials.create with a new credential.
+func (a *authenticator) create(opts creationOptions) string {
+	a.t.Helper()
+	var err error
+	if a.user, err = b64url.DecodeString(opts.PublicKey.User.ID); err != nil {
+		a.t.Fatal(err)
+	}
+	if a.key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader); err != nil {
+		a.t.Fatal(err)
+	}
+	a.credID = make([]byte, 16)
+	rand.Read(a.credID)
+	cose, err := webauthncbor.Marshal(map[int]any{
+		1: 2, 3: -7, // EC2, ES256
+		-1: 1, // P-256
+		-2: a.key.X.FillBytes(make([]byte, 32)),
+		-3: a.key.Y.FillBytes(make([]byte, 32)),
+	})
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	ad := a.authData(flagAT)
+	ad = append(ad, make([]byte, 16)...) // AAGUID
+	ad = binary.BigEndian.AppendUint16(ad, uint16(len(a.credID)))
+	ad = append(ad, a.credID...)
+	ad = append(ad, cose...)
+	att, err := webauthncbor.Marshal(map[string]any{"fmt": "none", "attStmt": map[string]any{}, "authData": ad})
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	return a.credential(map[string]string{
+		"attestationObject": b64url.EncodeToString(att),
+		"clientDataJSON":    b64url.EncodeToString(a.clientData("webauthn.create", opts.PublicKey.Challenge)),
+	})
+}
+
+// get answers navigator.credentials.get, counting the signature.
+func (a *authenticator) get(opts assertionOptions) string {
+	a.t.Helper()
+	a.count++
+	ad := a.authData(0)
+	cd := a.clientData("webauthn.get", opts.PublicKey.Challenge)
+	cdHash := sha256.Sum256(cd)
+	digest := sha256.Sum256(append(append([]byte{}, ad...), cdHash[:]...))
+	sig, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	return a.credential(map[string]string{
+		"authenticatorData": b64url.EncodeToString(ad),
+		"clientDataJSON":    b64url.EncodeToString(cd),
+		"signature":         b64url.EncodeToString(sig),
+		"userHandle":        b64url.EncodeToString(a.user),
+	})
+}
+
+func (a *authenticator) credential(response map[string]string) string {
+	b, err := json.Marshal(map[string]any{
+		"id":       b64url.EncodeToString(a.credID),
+		"rawId":    b64url.EncodeToString(a.credID),
+		"type":     "public-key",
+		"response": response,
+	})
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	return string(b)
+}
+
+// registerPasskey adds a passkey held by a new authenticator to the
+// account behind token.
+func (a *apiTest) registerPasskey(token string) *authenticator {
+	a.t.Helper()
+	var opts creationOptions
+	a.expect(http.StatusOK, &opts, "POST /api/passkeys/register/begin", "/api/passkeys/register/begin", token, nil)
+	key := &authenticator{t: a.t}
+	a.expect(http.StatusCreated, nil, "POST /api/passkeys/register/finish", "/api/passkeys/register/finish", token,
+		strings.NewReader(key.create(opts)))
+	return key
+}
+
+// passkeyLogin runs a sign-in ceremony with key and returns the response.
+func (a *apiTest) passkeyLogin(key *authenticator) (*http.Response, []byte) {
+	a.t.Helper()
+	var opts assertionOptions
+	a.expect(http.StatusOK, &opts, "POST /api/login/passkey/begin", "/api/login/passkey/begin", "", nil)
+	return a.call("POST /api/login/passkey/finish", "/api/login/passkey/finish", "", strings.NewReader(key.get(opts)))
+}
+
+func TestPasskeyRegistrationAndLogin(t *testing.T) {
+	a := newAPITest(t)
+	a.register("Ann", "ann@example.com", "ann-password")
+	ann := a.login("ann@example.com", "ann-password")
+
+	var opts creationOptions
+	a.expect(http.StatusOK, &opts, "POST /api/passkeys/register/begin", "/api/passkeys/register/begin", ann, nil)
+	key := &authenticator{t: t}
+	body := key.create(opts)
+	var created struct {
+		ID     string
+		Synced bool
+	}
+	a.expect(http.StatusCreated, &created, "POST /api/passkeys/register/finish", "/api/passkeys/register/finish", ann,
+		strings.NewReader(body))
+	if !created.Synced {
+		t.Errorf("backed up passkey not reported as synced: %+v", created)
+	}
+	// each ceremony finishes once
+	a.expect(http.StatusBadRequest, nil, "POST /api/passkeys/register/finish", "/api/passkeys/register/finish", ann,
+		strings.NewReader(body))
+
+	var keys []struct{ ID string }
+	a.expect(http.StatusOK, &keys, "GET /api/passkeys", "/api/passkeys", ann, nil)
+	if len(keys) != 1 || keys[0].ID != created.ID {
+		t.Fatalf("passkeys = %+v, want %s", keys, created.ID)
+	}
+
+	// discoverable: sign-in starts without naming the account
+	var login assertionOptions
+	a.expect(http.StatusOK, &login, "POST /api/login/passkey/begin", "/api/login/passkey/begin", "", nil)
+	if len(login.PublicKey.AllowCredentials) != 0 {
+		t.Fatalf("sign-in options list credentials: %s", login.PublicKey.AllowCredentials)
+	}
+	var session struct {
+		Token string
+		User  struct{ Email string }
+	}
+	a.expect(http.StatusOK, &session, "POST /api/login/passkey/finish", "/api/login/passkey/finish", "",
+		strings.NewReader(key.get(login)))
+	if session.Token == "" || session.User.Email != "ann@example.com" {
+		t.Fatalf("passkey login = %+v", session)
+	}
+	var me struct{ Email string }
+	a.expect(http.StatusOK, &me, "GET /api/me", "/api/me", session.Token, nil)
+	if me.Email != "ann@example.com" {
+		t.Fatalf("me = %+v", me)
+	}
+
+	user, err := a.store.GetUserByEmail(context.Background(), "ann@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	creds, err := a.store.GetWebAuthnCredentialsForUser(context.Background(), user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(creds) != 1 || creds[0].SignCount != 1 || !creds[0].LastUsedAt.Valid {
+		t.Fatalf("credential after sign-in = %+v", creds)
+	}
+
+	// a deleted passkey no longer signs in
+	a.expect(http.StatusNoContent, nil, "DELETE /api/passkeys/{id}", "/api/passkeys/"+created.ID, ann, nil)
+	if resp, out := a.passkeyLogin(key); resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("deleted passkey: status %d\n%s", resp.StatusCode, out)
+	}
+}
+
+func TestPasskeyCloneWarning(t *testing.T) {
+	a := newAPITest(t)
+	a.register("Ann", "ann@example.com", "ann-password")
+	key := a.registerPasskey(a.login("ann@example.com", "ann-password"))
+
+	if resp, out := a.passkeyLogin(key); resp.StatusCode != http.StatusOK {
+		t.Fatalf("first sign-in: status %d\n%s", resp.StatusCode, out)
+	}
+	// a copy of the key that missed the last signature repeats its count
+	key.count--
+	resp, out := a.passkeyLogin(key)
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("repeated sign count: status %d, want 401\n%s", resp.StatusCode, out)
+	}
+	var problem struct{ Code string }
+	if err := json.Unmarshal(out, &problem); err != nil || problem.Code != "invalid_passkey" {
+		t.Fatalf("problem = %s", out)
+	}
+
+	user, err := a.store.GetUserByEmail(context.Background(), "ann@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	creds, err := a.store.GetWebAuthnCredentialsForUser(context.Background(), user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if creds[0].SignCount != 1 {
+		t.Fatalf("sign count = %d after a rejected sign-in, want 1", creds[0].SignCount)
+	}
+}
+
+func TestPasskeyCeremonyOfAnotherUser(t *testing.T) {
+	a := newAPITest(t)
+	a.register("Ann", "ann@example.com", "ann-password")
+	a.register("Bob", "bob@example.com", "bob-password")
+	ann := a.login("ann@example.com", "ann-password")
+	bob := a.login("bob@example.com", "bob-password")
+
+	var opts creationOptions
+	a.expect(http.StatusOK, &opts, "POST /api/passkeys/register/begin", "/api/passkeys/register/begin", ann, nil)
+	key := &authenticator{t: t}
+	var problem struct{ Code string }
+	a.expect(http.StatusBadRequest, &problem, "POST /api/passkeys/register/finish", "/api/passkeys/register/finish", bob,
+		strings.NewReader(key.create(opts)))
+	if problem.Code != "passkey_challenge_expired" {
+		t.Fatalf("problem = %+v", problem)
+	}
+
+	// nor can a sign-in ceremony be finished as a registration
+	var login assertionOptions
+	a.expect(http.StatusOK, &login, "POST /api/login/passkey/begin", "/api/login/passkey/begin", "", nil)
+	opts.PublicKey.Challenge = login.PublicKey.Challenge
+	a.expect(http.StatusBadRequest, nil, "POST /api/passkeys/register/finish", "/api/passkeys/register/finish", bob,
+		strings.NewReader(key.create(opts)))
+
+	for _, token := range []string{ann, bob} {
+		var keys []any
+		a.expect(http.StatusOK, &keys, "GET /api/passkeys", "/api/passkeys", token, nil)
+		if len(keys) != 0 {
+			t.Fatalf("passkeys = %+v", keys)
+		}
+	}
+}
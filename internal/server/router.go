@@ -0,0 +1,29 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/nickg76/garage-backend/internal/metrics"
+	"github.com/nickg76/garage-backend/internal/tracing"
+)
+
+// router is a ServeMux that remembers which patterns were registered, so the
+// route table can be checked against the OpenAPI document, and records
+// per-route metrics and trace span names.
+type router struct {
+	*http.ServeMux
+	patterns []string
+}
+
+func newRouter() *router {
+	return &router{ServeMux: http.NewServeMux()}
+}
+
+func (rt *router) Handle(pattern string, h http.Handler) {
+	rt.ServeMux.Handle(pattern, metrics.Instrument(pattern, tracing.Route(pattern, h)))
+	rt.patterns = append(rt.patterns, pattern)
+}
+
+func (rt *router) HandleFunc(pattern string, h func(http.ResponseWriter, *http.Request)) {
+	rt.Handle(pattern, http.HandlerFunc(h))
+}
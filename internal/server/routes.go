@@ -7,33 +7,30 @@ import (
 	"path/filepath"
 
 	"github.com/nickg76/garage-backend/internal/handlers"
+	"github.com/nickg76/garage-backend/internal/openapi"
+	"github.com/nickg76/garage-backend/internal/tracing"
 )
 
 func Routes(s *handlers.Server) http.Handler {
-	mux := http.NewServeMux()
+	s.SetAdminAccountsFromEnv()
+	api := problemFallback(apiRoutes(s))
 
-	// --- API routes ---
-	mux.HandleFunc("POST /api/register", s.Register)
-	mux.HandleFunc("POST /api/login", s.Login)
-	mux.Handle("GET /api/me", s.AuthMiddleware(http.HandlerFunc(s.Me)))
-	mux.Handle("GET /api/appointments", s.AuthMiddleware(http.HandlerFunc(s.GetMyAppointments)))
-	mux.Handle("POST /api/appointments", s.AuthMiddleware(http.HandlerFunc(s.CreateAppointment)))
-	mux.Handle("DELETE /api/appointments/", s.AuthMiddleware(http.HandlerFunc(s.UserDeleteAppointment)))
-	mux.Handle("PATCH /api/appointments/", s.AuthMiddleware(http.HandlerFunc(s.UserEditAppointment)))
-	mux.Handle("PUT /api/appointments/", s.AuthMiddleware(http.HandlerFunc(s.UserEditAppointment)))
+	// Every published version serves the same route table, handlers pick
+	// version-specific DTOs via handlers.GetAPIVersion. Unversioned /api/ is
+	// an alias for v1 so existing clients keep working.
+	root := http.NewServeMux()
+	for _, v := range apiVersions {
+		root.Handle("/api/"+v.Name+"/", versioned(v, api))
+	}
+	root.Handle("/api/", versioned(apiVersions[0], api))
 
-	// Admin routes
-	adminList := s.AuthMiddleware(s.AdminOnly(http.HandlerFunc(s.AdminListAppointments)))
-	adminUpdate := s.AuthMiddleware(s.AdminOnly(http.HandlerFunc(s.AdminUpdateStatus)))
-	mux.Handle("GET /api/admin/appointments", adminList)
-	mux.Handle("PATCH /api/admin/appointments/", adminUpdate)
+	for _, rt := range rootRoutes(s) {
+		root.Handle(rt.pattern, rt.handler)
+	}
 
-	s.SetAdminAccountsFromEnv()
-	// SSE events (JWT via query params)
-	mux.HandleFunc("GET /api/events", s.Events)
 	//
 	// // --- Frontend routes fallback ---
-	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+	root.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 	    // Attempt to serve static file
 	    staticPath := filepath.Join("public", r.URL.Path)
 	    if info, err := os.Stat(staticPath); err == nil && !info.IsDir() {
@@ -45,10 +42,88 @@ func Routes(s *handlers.Server) http.Handler {
 	    http.ServeFile(w, r, "public/index.html")
 	})
 
-	// return cors(mux)
-	return mux
+	// return cors(root)
+	return tracing.Middleware(s.RequestID(s.AccessLog(root)))
+}
+
+type route struct {
+	pattern string
+	handler http.Handler
+}
+
+// rootRoutes are served outside the versioned API, without a prefix. Like
+// apiRoutes they must be documented in the OpenAPI spec.
+func rootRoutes(s *handlers.Server) []route {
+	return []route{
+		// Orchestrator probes
+		{"GET /healthz", http.HandlerFunc(s.Healthz)},
+		{"GET /readyz", http.HandlerFunc(s.Readyz)},
+		{"GET /version", http.HandlerFunc(s.Version)},
+		{"GET /metrics", s.Metrics()},
+		// Token verification keys for other services
+		{"GET /.well-known/jwks.json", http.HandlerFunc(s.JWKS)},
+	}
 }
 
+// apiRoutes is the route table served under every API version. Every pattern
+// in it must be documented in the OpenAPI spec, see TestRoutesDocumented.
+func apiRoutes(s *handlers.Server) *router {
+	mux := newRouter()
+
+	// Mutating endpoints honour Idempotency-Key so clients can safely retry.
+	// Login is left out on purpose, replaying it would mean storing tokens.
+	mutating := func(h http.HandlerFunc) http.Handler {
+		return s.AuthMiddleware(s.Idempotent(h))
+	}
+
+	// --- API routes ---
+	mux.Handle("POST /api/register", s.RateLimit(s.Idempotent(http.HandlerFunc(s.Register))))
+	mux.Handle("POST /api/login", s.RateLimit(http.HandlerFunc(s.Login)))
+	mux.Handle("POST /api/login/mfa", s.RateLimit(http.HandlerFunc(s.LoginMFA)))
+	mux.Handle("POST /api/login/magic", s.RateLimit(http.HandlerFunc(s.RequestMagicLink)))
+	mux.Handle("POST /api/login/magic/verify", s.RateLimit(http.HandlerFunc(s.VerifyMagicLink)))
+	mux.HandleFunc("GET /api/login/oidc", s.ListSSOProviders)
+	mux.Handle("POST /api/login/oidc/{provider}/begin", s.RateLimit(http.HandlerFunc(s.BeginSSO)))
+	mux.Handle("POST /api/login/oidc/finish", s.RateLimit(http.HandlerFunc(s.FinishSSO)))
+	mux.Handle("POST /api/mfa/totp/enroll", s.AuthMiddleware(http.HandlerFunc(s.EnrollTOTP)))
+	mux.Handle("POST /api/mfa/totp/confirm", s.AuthMiddleware(http.HandlerFunc(s.ConfirmTOTP)))
+	mux.Handle("POST /api/login/passkey/begin", s.RateLimit(http.HandlerFunc(s.BeginPasskeyLogin)))
+	mux.Handle("POST /api/login/passkey/finish", s.RateLimit(http.HandlerFunc(s.FinishPasskeyLogin)))
+	mux.Handle("GET /api/passkeys", s.AuthMiddleware(http.HandlerFunc(s.ListPasskeys)))
+	mux.Handle("POST /api/passkeys/register/begin", s.AuthMiddleware(http.HandlerFunc(s.BeginPasskeyRegistration)))
+	mux.Handle("POST /api/passkeys/register/finish", s.AuthMiddleware(http.HandlerFunc(s.FinishPasskeyRegistration)))
+	mux.Handle("DELETE /api/passkeys/{id}", s.AuthMiddleware(http.HandlerFunc(s.DeletePasskey)))
+	mux.Handle("GET /api/me", s.AuthMiddleware(http.HandlerFunc(s.Me)))
+	mux.Handle("GET /api/appointments", s.AuthMiddleware(http.HandlerFunc(s.GetMyAppointments)))
+	mux.Handle("POST /api/appointments", mutating(s.CreateAppointment))
+	mux.Handle("GET /api/appointments/{id}", s.AuthMiddleware(http.HandlerFunc(s.GetAppointment)))
+	mux.Handle("DELETE /api/appointments/{id}", mutating(s.UserDeleteAppointment))
+	mux.Handle("PATCH /api/appointments/{id}", mutating(s.UserPatchAppointment))
+	mux.Handle("PUT /api/appointments/{id}", mutating(s.UserEditAppointment))
+	mux.Handle("GET /api/appointments/{id}/messages", s.AuthMiddleware(http.HandlerFunc(s.GetAppointmentMessages)))
+	mux.Handle("POST /api/appointments/{id}/messages", mutating(s.CreateAppointmentMessage))
+	mux.Handle("GET /api/appointments/{id}/attachments", s.AuthMiddleware(http.HandlerFunc(s.ListAttachments)))
+	mux.Handle("POST /api/appointments/{id}/attachments", s.AuthMiddleware(s.IdempotentUpload(http.HandlerFunc(s.UploadAttachment))))
+	// Attachment downloads are authorised by the signed URL, not the JWT
+	mux.HandleFunc("GET /api/attachments/{id}", s.DownloadAttachment)
+
+	// Admin routes
+	adminList := s.AuthMiddleware(s.AdminOnly(http.HandlerFunc(s.AdminListAppointments)))
+	adminUpdate := s.AuthMiddleware(s.AdminOnly(s.Idempotent(http.HandlerFunc(s.AdminUpdateStatus))))
+	mux.Handle("GET /api/admin/appointments", adminList)
+	mux.Handle("PATCH /api/admin/appointments/{id}/status", adminUpdate)
+	mux.Handle("GET /api/admin/locked-accounts", s.AuthMiddleware(s.AdminOnly(http.HandlerFunc(s.AdminListLockedAccounts))))
+
+	// SSE events (JWT via query params)
+	mux.HandleFunc("GET /api/events", s.Events)
+
+	// API description
+	mux.HandleFunc("GET /api/openapi.json", openapi.Spec)
+	mux.HandleFunc("GET /api/docs", openapi.Docs)
+	mux.HandleFunc("GET /api/docs/{asset}", openapi.DocsAsset)
+
+	return mux
+}
 
 // // cors is a middleware that handles cross-origin requests.
 // func cors(next http.Handler) http.Handler {
@@ -0,0 +1,79 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/nickg76/garage-backend/internal/config"
+	"github.com/nickg76/garage-backend/internal/handlers"
+	"github.com/nickg76/garage-backend/internal/openapi"
+	"github.com/nickg76/garage-backend/internal/storage"
+	"github.com/nickg76/garage-backend/internal/store"
+)
+
+func newTestServer(t *testing.T, configure ...func(*config.Config)) *handlers.Server {
+	t.Helper()
+	files, err := storage.NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.Default()
+	cfg.JWTSecret = "test-secret"
+	for _, f := range configure {
+		f(cfg)
+	}
+	s, err := handlers.NewServerWith(cfg, handlers.Deps{
+		Store: store.NewMemory(),
+		Files: files,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// TestRoutesDocumented fails when a route is added without describing it in
+// internal/openapi/openapi.json.
+func TestRoutesDocumented(t *testing.T) {
+	s := newTestServer(t)
+	patterns := apiRoutes(s).patterns
+	for _, rt := range rootRoutes(s) {
+		patterns = append(patterns, rt.pattern)
+	}
+	missing, err := openapi.Missing(patterns)
+	if err != nil {
+		t.Fatalf("invalid spec: %v", err)
+	}
+	for _, p := range missing {
+		t.Errorf("route %q is not in the OpenAPI spec", p)
+	}
+}
+
+func TestDocsServeVendoredAssets(t *testing.T) {
+	h := Routes(newTestServer(t))
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w
+	}
+
+	page := get("/api/docs")
+	if page.Code != http.StatusOK || strings.Contains(page.Body.String(), "https://") {
+		t.Fatalf("docs page: %d, must not load anything from another origin:\n%s", page.Code, page.Body)
+	}
+
+	for _, path := range []string{"/api/docs/swagger-ui-bundle.js", "/api/v1/docs/swagger-ui.css"} {
+		w := get(path)
+		body, _ := io.ReadAll(w.Body)
+		if w.Code != http.StatusOK || len(body) < 1000 {
+			t.Errorf("%s: status %d, %d bytes", path, w.Code, len(body))
+		}
+	}
+	if w := get("/api/docs/index.html"); w.Code != http.StatusNotFound {
+		t.Errorf("unknown asset: status %d, want 404", w.Code)
+	}
+}
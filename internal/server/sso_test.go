@@ -0,0 +1,160 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/nickg76/garage-backend/internal/config"
+	"github.com/nickg76/garage-backend/internal/db"
+	"github.com/nickg76/garage-backend/internal/oidctest"
+)
+
+// newSSOTest is newAPITest with a mock provider configured as "mock".
+func newSSOTest(t *testing.T) (*apiTest, *oidctest.Provider) {
+	t.Helper()
+	idp, srv, err := oidctest.Start("garage", "client-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(srv.Close)
+	a := newAPITest(t, func(cfg *config.Config) {
+		cfg.OIDC = config.OIDCConfig{
+			RedirectURL: "http://localhost/sso/callback",
+			Providers: []config.OIDCProvider{{
+				ID:           "mock",
+				Issuer:       idp.Issuer,
+				ClientID:     idp.ClientID,
+				ClientSecret: idp.ClientSecret,
+			}},
+		}
+	})
+	return a, idp
+}
+
+// beginSSO starts a sign-in with the mock provider and returns the
+// provider's answer, plus the state cookie the browser was given.
+func (a *apiTest) beginSSO(idp *oidctest.Provider) (code, state string, cookie *http.Cookie) {
+	a.t.Helper()
+	var begin struct {
+		AuthorizationURL string `json:"authorization_url"`
+	}
+	resp := a.expect(http.StatusOK, &begin, "POST /api/login/oidc/{provider}/begin", "/api/login/oidc/mock/begin", "", nil)
+	for _, c := range resp.Cookies() {
+		if c.Name == "garage_sso_state" {
+			cookie = c
+		}
+	}
+	if cookie == nil || !cookie.HttpOnly {
+		a.t.Fatalf("begin set cookies %v, want an HttpOnly state cookie", resp.Cookies())
+	}
+	code, state, err := idp.Authorize(begin.AuthorizationURL)
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	if state != cookie.Value {
+		a.t.Fatalf("provider returned state %q, cookie holds %q", state, cookie.Value)
+	}
+	return code, state, cookie
+}
+
+// finishSSO posts the provider's answer back and returns the problem code,
+// or "" when a session was started.
+func (a *apiTest) finishSSO(code, state string, cookie *http.Cookie, want int) string {
+	a.t.Helper()
+	var out struct {
+		Token string
+		Code  string
+	}
+	var hdr []string
+	if cookie != nil {
+		hdr = append(hdr, "Cookie", cookie.Name+"="+cookie.Value)
+	}
+	a.expect(want, &out, "POST /api/login/oidc/finish", "/api/login/oidc/finish", "",
+		map[string]string{"code": code, "state": state}, hdr...)
+	if want == http.StatusOK && out.Token == "" {
+		a.t.Fatal("sign-in gave no token")
+	}
+	return out.Code
+}
+
+func TestSSOLinksAccountByVerifiedEmail(t *testing.T) {
+	a, idp := newSSOTest(t)
+	a.register("Ann", "ann@example.com", "ann-password")
+
+	var providers []struct{ ID, Name string }
+	a.expect(http.StatusOK, &providers, "GET /api/login/oidc", "/api/login/oidc", "", nil)
+	if len(providers) != 1 || providers[0].ID != "mock" {
+		t.Fatalf("providers = %+v", providers)
+	}
+
+	idp.SetUser(oidctest.User{Subject: "idp-ann", Email: "ann@example.com", EmailVerified: true, Name: "Ann"})
+	code, state, cookie := a.beginSSO(idp)
+	a.finishSSO(code, state, cookie, http.StatusOK)
+
+	user, err := a.store.GetUserByEmail(context.Background(), "ann@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ident, err := a.store.GetUserIdentity(context.Background(), db.GetUserIdentityParams{Provider: "mock", Subject: "idp-ann"})
+	if err != nil || ident.UserID != user.ID {
+		t.Fatalf("identity = %+v, %v, want linked to %s", ident, err, user.ID)
+	}
+
+	// linked by subject from now on, so a changed address at the provider
+	// still signs in
+	idp.SetUser(oidctest.User{Subject: "idp-ann", Email: "ann@elsewhere.example", Name: "Ann"})
+	code, state, cookie = a.beginSSO(idp)
+	a.finishSSO(code, state, cookie, http.StatusOK)
+
+	// and the state can't be used twice
+	if got := a.finishSSO(code, state, cookie, http.StatusUnauthorized); got != "invalid_sso_state" {
+		t.Fatalf("replayed state: %q", got)
+	}
+}
+
+func TestSSORejectsUnverifiedEmail(t *testing.T) {
+	a, idp := newSSOTest(t)
+	a.register("Ann", "ann@example.com", "ann-password")
+
+	idp.SetUser(oidctest.User{Subject: "idp-mallory", Email: "ann@example.com", EmailVerified: false})
+	code, state, cookie := a.beginSSO(idp)
+	if got := a.finishSSO(code, state, cookie, http.StatusForbidden); got != "sso_email_unverified" {
+		t.Fatalf("problem = %q", got)
+	}
+	if _, err := a.store.GetUserIdentity(context.Background(), db.GetUserIdentityParams{Provider: "mock", Subject: "idp-mallory"}); err == nil {
+		t.Fatal("unverified email was linked")
+	}
+}
+
+func TestSSORejectsNonceMismatch(t *testing.T) {
+	a, idp := newSSOTest(t)
+	a.register("Ann", "ann@example.com", "ann-password")
+	idp.SetUser(oidctest.User{Subject: "idp-ann", Email: "ann@example.com", EmailVerified: true})
+
+	idp.SetNonce("nonce-from-another-sign-in")
+	code, state, cookie := a.beginSSO(idp)
+	if got := a.finishSSO(code, state, cookie, http.StatusUnauthorized); got != "sso_failed" {
+		t.Fatalf("problem = %q", got)
+	}
+}
+
+func TestSSOStateBoundToBrowser(t *testing.T) {
+	a, idp := newSSOTest(t)
+	a.register("Ann", "ann@example.com", "ann-password")
+	idp.SetUser(oidctest.User{Subject: "idp-ann", Email: "ann@example.com", EmailVerified: true})
+
+	// an attacker's code and state, finished in a browser that didn't begin
+	// the sign-in
+	code, state, cookie := a.beginSSO(idp)
+	if got := a.finishSSO(code, state, nil, http.StatusUnauthorized); got != "invalid_sso_state" {
+		t.Fatalf("without cookie: %q", got)
+	}
+	_, _, other := a.beginSSO(idp)
+	if got := a.finishSSO(code, state, other, http.StatusUnauthorized); got != "invalid_sso_state" {
+		t.Fatalf("with another sign-in's cookie: %q", got)
+	}
+
+	// the rejected attempts didn't use the state up
+	a.finishSSO(code, state, cookie, http.StatusOK)
+}
@@ -0,0 +1,91 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nickg76/garage-backend/internal/handlers"
+)
+
+// apiVersion is one published version of the API.
+//
+// To ship v2: add {Name: "v2"} after v1, switch handlers that need new DTOs on
+// handlers.GetAPIVersion, then set Deprecated and Sunset on v1. Clients on v1
+// then get Deprecation (RFC 9745), Sunset (RFC 8594) and a successor Link.
+type apiVersion struct {
+	Name       string
+	Deprecated time.Time // zero while the version is fully supported
+	Sunset     time.Time // zero until a removal date is announced
+	Successor  string    // version clients should move to, e.g. "v2"
+}
+
+// apiVersions lists every served version, oldest first. The first entry is
+// what the unversioned /api/ alias resolves to.
+var apiVersions = []apiVersion{
+	{Name: "v1"},
+}
+
+// versioned rewrites /api/{version}/... to the canonical /api/... path the
+// handlers parse, records the version on the context and adds the version's
+// lifecycle headers.
+func versioned(v apiVersion, next http.Handler) http.Handler {
+	prefix := "/api/" + v.Name + "/"
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := w.Header()
+		h.Set("API-Version", v.Name)
+		if !v.Deprecated.IsZero() {
+			h.Set("Deprecation", "@"+strconv.FormatInt(v.Deprecated.Unix(), 10))
+		}
+		if !v.Sunset.IsZero() {
+			h.Set("Sunset", v.Sunset.UTC().Format(http.TimeFormat))
+		}
+		if v.Successor != "" {
+			h.Add("Link", `</api/`+v.Successor+`/>; rel="successor-version"`)
+		}
+
+		r = r.WithContext(handlers.WithAPIVersion(r.Context(), v.Name))
+		if rest, ok := strings.CutPrefix(r.URL.Path, prefix); ok {
+			r2 := new(http.Request)
+			*r2 = *r
+			u := *r.URL
+			u.Path = "/api/" + rest
+			u.RawPath = ""
+			r2.URL = &u
+			r = r2
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// problemFallback serves the API route table, answering requests it has no
+// route for with problem+json instead of ServeMux's plain-text 404 and 405.
+func problemFallback(mux *router) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h, pattern := mux.Handler(r)
+		if pattern != "" {
+			mux.ServeHTTP(w, r)
+			return
+		}
+		// ServeMux works out 404 vs 405 and the Allow header, keep its verdict
+		verdict := &headerOnlyWriter{header: http.Header{}}
+		h.ServeHTTP(verdict, r)
+		if verdict.status == http.StatusMethodNotAllowed {
+			w.Header().Set("Allow", verdict.header.Get("Allow"))
+			handlers.MethodNotAllowed(w, r)
+			return
+		}
+		handlers.NotFound(w, r)
+	})
+}
+
+// headerOnlyWriter keeps the status and headers of a response and drops the body.
+type headerOnlyWriter struct {
+	header http.Header
+	status int
+}
+
+func (w *headerOnlyWriter) Header() http.Header         { return w.header }
+func (w *headerOnlyWriter) WriteHeader(code int)        { w.status = code }
+func (w *headerOnlyWriter) Write(b []byte) (int, error) { return len(b), nil }
@@ -0,0 +1,53 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestUnmatchedAPIPathsAreProblems(t *testing.T) {
+	h := Routes(newTestServer(t))
+	cases := []struct {
+		method, path string
+		status       int
+		code, allow  string
+	}{
+		{"GET", "/api/v1/nope", http.StatusNotFound, "not_found", ""},
+		{"GET", "/api/nope", http.StatusNotFound, "not_found", ""},
+		{"GET", "/api/v9/me", http.StatusNotFound, "not_found", ""},
+		{"POST", "/api/v1/me", http.StatusMethodNotAllowed, "method_not_allowed", "GET, HEAD"},
+	}
+	for _, c := range cases {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(c.method, c.path, nil))
+		if w.Code != c.status {
+			t.Errorf("%s %s: status %d, want %d", c.method, c.path, w.Code, c.status)
+			continue
+		}
+		if ct := w.Header().Get("Content-Type"); ct != "application/problem+json" {
+			t.Errorf("%s %s: content type %q", c.method, c.path, ct)
+		}
+		if got := w.Header().Get("Allow"); got != c.allow {
+			t.Errorf("%s %s: Allow %q, want %q", c.method, c.path, got, c.allow)
+		}
+		var p struct {
+			Code     string `json:"code"`
+			Instance string `json:"instance"`
+		}
+		if err := json.NewDecoder(w.Body).Decode(&p); err != nil || p.Code != c.code {
+			t.Errorf("%s %s: problem %+v (%v), want code %q", c.method, c.path, p, err, c.code)
+		}
+	}
+}
+
+func TestMatchedAPIPathsStillRoute(t *testing.T) {
+	h := Routes(newTestServer(t))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/me", nil))
+	// reaching the handler means auth runs, rather than a 404
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("GET /api/v1/me: status %d, want 401", w.Code)
+	}
+}
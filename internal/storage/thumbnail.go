@@ -0,0 +1,68 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+)
+
+// MaxImagePixels caps the decoded size of an upload. A few KB of PNG can
+// claim to be 100k x 100k pixels and decoding it would allocate tens of GB.
+const MaxImagePixels = 40_000_000
+
+// ErrImageTooLarge is returned by Thumbnail for images over MaxImagePixels.
+var ErrImageTooLarge = errors.New("image dimensions too large")
+
+// Thumbnail decodes a JPEG, PNG or GIF image and returns a JPEG scaled so its
+// longest side is at most max pixels.
+func Thumbnail(data []byte, max int) ([]byte, error) {
+	// check the header before decoding, the pixel buffer is sized from it
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 || int64(cfg.Width)*int64(cfg.Height) > MaxImagePixels {
+		return nil, ErrImageTooLarge
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w > max || h > max {
+		if w >= h {
+			h = h * max / w
+			w = max
+		} else {
+			w = w * max / h
+			h = max
+		}
+	}
+	if w < 1 {
+		w = 1
+	}
+	if h < 1 {
+		h = 1
+	}
+
+	// nearest-neighbour sampling is plenty for a preview
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		sy := b.Min.Y + y*b.Dy()/h
+		for x := 0; x < w; x++ {
+			sx := b.Min.X + x*b.Dx()/w
+			dst.Set(x, y, src.At(sx, sy))
+		}
+	}
+
+	var out bytes.Buffer
+	if err := jpeg.Encode(&out, dst, &jpeg.Options{Quality: 80}); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
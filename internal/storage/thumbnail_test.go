@@ -0,0 +1,68 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+func TestThumbnailScalesLongestSide(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 400, 100))
+	for x := 0; x < 400; x++ {
+		src.Set(x, 50, color.RGBA{R: 255, A: 255})
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, src); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := Thumbnail(buf.Bytes(), 200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := jpeg.DecodeConfig(bytes.NewReader(out))
+	if err != nil {
+		t.Fatalf("thumbnail is not a JPEG: %v", err)
+	}
+	if cfg.Width != 200 || cfg.Height != 50 {
+		t.Fatalf("thumbnail is %dx%d, want 200x50", cfg.Width, cfg.Height)
+	}
+}
+
+func TestThumbnailRejectsHugeDimensions(t *testing.T) {
+	// a PNG header claiming 100000x100000 pixels, decoding it would try to
+	// allocate ~40 GB before noticing the data is missing
+	data := pngHeader(100000, 100000)
+	if _, err := Thumbnail(data, 256); !errors.Is(err, ErrImageTooLarge) {
+		t.Fatalf("err = %v, want ErrImageTooLarge", err)
+	}
+}
+
+func TestThumbnailRejectsGarbage(t *testing.T) {
+	if _, err := Thumbnail([]byte("not an image"), 256); err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+// pngHeader returns the signature and IHDR chunk of an RGBA PNG.
+func pngHeader(w, h uint32) []byte {
+	var ihdr [13]byte
+	binary.BigEndian.PutUint32(ihdr[0:], w)
+	binary.BigEndian.PutUint32(ihdr[4:], h)
+	ihdr[8] = 8 // bit depth
+	ihdr[9] = 6 // colour type RGBA
+
+	var b bytes.Buffer
+	b.WriteString("\x89PNG\r\n\x1a\n")
+	binary.Write(&b, binary.BigEndian, uint32(len(ihdr)))
+	chunk := append([]byte("IHDR"), ihdr[:]...)
+	b.Write(chunk)
+	binary.Write(&b, binary.BigEndian, crc32.ChecksumIEEE(chunk))
+	return b.Bytes()
+}
@@ -0,0 +1,807 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/nickg76/garage-backend/internal/db"
+)
+
+// ErrDuplicateEmail is what Memory returns where Postgres would report a
+// unique violation on users.email.
+var ErrDuplicateEmail = errors.New("store: email already registered")
+
+// ErrDuplicateCredential is the Memory counterpart of a unique violation on
+// webauthn_credentials.credential_id.
+var ErrDuplicateCredential = errors.New("store: passkey already registered")
+
+// Memory is a Store kept in maps, for tests and local experiments. It follows
+// the SQL queries' semantics (ordering, version checks, defaults) closely
+// enough for handler tests, but nothing is persisted.
+type Memory struct {
+	mu          sync.Mutex
+	users       map[uuid.UUID]db.User
+	appts       map[uuid.UUID]db.Appointment
+	messages    map[uuid.UUID]db.Message
+	attachments map[uuid.UUID]db.Attachment
+	// idempotency keys by key and scope
+	idempotency map[[2]string]db.IdempotencyKey
+	recovery    map[uuid.UUID]db.MfaRecoveryCode
+	passkeys    map[uuid.UUID]db.WebauthnCredential
+	// WebAuthn ceremonies in progress, by challenge
+	challenges map[string]db.WebauthnChallenge
+	// sign-in links, by token hash
+	magicLinks map[string]db.MagicLinkToken
+	// OpenID Connect accounts by provider and subject, and pending sign-ins
+	// by state
+	identities map[[2]string]db.UserIdentity
+	oidcStates map[string]db.OidcState
+	// last timestamp handed out by now
+	last time.Time
+}
+
+func NewMemory() *Memory {
+	return &Memory{
+		users:       map[uuid.UUID]db.User{},
+		appts:       map[uuid.UUID]db.Appointment{},
+		messages:    map[uuid.UUID]db.Message{},
+		attachments: map[uuid.UUID]db.Attachment{},
+		idempotency: map[[2]string]db.IdempotencyKey{},
+		recovery:    map[uuid.UUID]db.MfaRecoveryCode{},
+		passkeys:    map[uuid.UUID]db.WebauthnCredential{},
+		challenges:  map[string]db.WebauthnChallenge{},
+		magicLinks:  map[string]db.MagicLinkToken{},
+		identities:  map[[2]string]db.UserIdentity{},
+		oidcStates:  map[string]db.OidcState{},
+	}
+}
+
+var _ Store = (*Memory)(nil)
+
+// now is time.Now, nudged forward when the clock hasn't moved since the last
+// call so rows listed by creation time keep their insertion order. Callers
+// hold mu.
+func (m *Memory) now() time.Time {
+	t := time.Now()
+	if !t.After(m.last) {
+		t = m.last.Add(time.Microsecond)
+	}
+	m.last = t
+	return t
+}
+
+// --- Users ---
+
+func (m *Memory) CreateUser(ctx context.Context, arg db.CreateUserParams) (db.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, u := range m.users {
+		if u.Email == arg.Email {
+			return db.User{}, ErrDuplicateEmail
+		}
+	}
+	u := db.User{
+		ID:           arg.ID,
+		Name:         arg.Name,
+		Email:        arg.Email,
+		PasswordHash: arg.PasswordHash,
+		Phone:        arg.Phone,
+		IsAdmin:      arg.IsAdmin,
+		CreatedAt:    time.Now(),
+	}
+	m.users[u.ID] = u
+	return u, nil
+}
+
+func (m *Memory) GetUserByEmail(ctx context.Context, email string) (db.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if u, ok := m.userByEmail(email); ok {
+		return u, nil
+	}
+	return db.User{}, sql.ErrNoRows
+}
+
+func (m *Memory) GetUserByID(ctx context.Context, id uuid.UUID) (db.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if u, ok := m.users[id]; ok {
+		return u, nil
+	}
+	return db.User{}, sql.ErrNoRows
+}
+
+func (m *Memory) GetAdminUserIDs(ctx context.Context) ([]uuid.UUID, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var ids []uuid.UUID
+	for _, u := range m.users {
+		if u.IsAdmin.Valid && u.IsAdmin.Bool {
+			ids = append(ids, u.ID)
+		}
+	}
+	return ids, nil
+}
+
+func (m *Memory) SetAdmin(ctx context.Context, arg db.SetAdminParams) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u, ok := m.userByEmail(arg.Email)
+	if !ok {
+		return 0, nil
+	}
+	u.IsAdmin = arg.IsAdmin
+	m.users[u.ID] = u
+	return 1, nil
+}
+
+func (m *Memory) SetUserDisabled(ctx context.Context, arg db.SetUserDisabledParams) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u, ok := m.userByEmail(arg.Email)
+	if !ok {
+		return 0, nil
+	}
+	u.DisabledAt = arg.DisabledAt
+	m.users[u.ID] = u
+	return 1, nil
+}
+
+func (m *Memory) RecordFailedLogin(ctx context.Context, id uuid.UUID) (int32, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u, ok := m.users[id]
+	if !ok {
+		return 0, sql.ErrNoRows
+	}
+	u.FailedLogins++
+	m.users[id] = u
+	return u.FailedLogins, nil
+}
+
+func (m *Memory) LockUser(ctx context.Context, arg db.LockUserParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if u, ok := m.users[arg.ID]; ok {
+		u.LockedUntil = arg.LockedUntil
+		m.users[arg.ID] = u
+	}
+	return nil
+}
+
+func (m *Memory) ResetFailedLogins(ctx context.Context, id uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if u, ok := m.users[id]; ok {
+		u.FailedLogins = 0
+		u.LockedUntil = sql.NullTime{}
+		m.users[id] = u
+	}
+	return nil
+}
+
+func (m *Memory) GetLockedUsers(ctx context.Context, lockedUntil sql.NullTime) ([]db.GetLockedUsersRow, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var out []db.GetLockedUsersRow
+	for _, u := range m.users {
+		if u.LockedUntil.Valid && u.LockedUntil.Time.After(lockedUntil.Time) {
+			out = append(out, db.GetLockedUsersRow{
+				ID:           u.ID,
+				Name:         u.Name,
+				Email:        u.Email,
+				FailedLogins: u.FailedLogins,
+				LockedUntil:  u.LockedUntil,
+			})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].LockedUntil.Time.After(out[j].LockedUntil.Time) })
+	return out, nil
+}
+
+func (m *Memory) SetTOTPSecret(ctx context.Context, arg db.SetTOTPSecretParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if u, ok := m.users[arg.ID]; ok {
+		u.TotpSecret = arg.TotpSecret
+		u.TotpLastStep = sql.NullInt64{}
+		m.users[arg.ID] = u
+	}
+	return nil
+}
+
+func (m *Memory) UseTOTPStep(ctx context.Context, arg db.UseTOTPStepParams) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u, ok := m.users[arg.ID]
+	if !ok || (u.TotpLastStep.Valid && u.TotpLastStep.Int64 >= arg.TotpLastStep.Int64) {
+		return 0, nil
+	}
+	u.TotpLastStep = arg.TotpLastStep
+	m.users[arg.ID] = u
+	return 1, nil
+}
+
+func (m *Memory) DisableTOTP(ctx context.Context, email string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u, ok := m.userByEmail(email)
+	if !ok {
+		return 0, nil
+	}
+	u.TotpSecret = sql.NullString{}
+	u.TotpEnabledAt = sql.NullTime{}
+	u.TotpLastStep = sql.NullInt64{}
+	m.users[u.ID] = u
+	return 1, nil
+}
+
+func (m *Memory) ConfirmTOTP(ctx context.Context, arg db.EnableTOTPParams, codes []db.CreateRecoveryCodeParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u, ok := m.users[arg.ID]
+	if !ok {
+		return nil
+	}
+	for id, c := range m.recovery {
+		if c.UserID == arg.ID {
+			delete(m.recovery, id)
+		}
+	}
+	for _, c := range codes {
+		m.recovery[c.ID] = db.MfaRecoveryCode{
+			ID:        c.ID,
+			UserID:    c.UserID,
+			CodeHash:  c.CodeHash,
+			CreatedAt: time.Now(),
+		}
+	}
+	u.TotpEnabledAt = sql.NullTime{Time: time.Now(), Valid: true}
+	u.TotpLastStep = arg.TotpLastStep
+	m.users[arg.ID] = u
+	return nil
+}
+
+func (m *Memory) UseRecoveryCode(ctx context.Context, arg db.UseRecoveryCodeParams) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for id, c := range m.recovery {
+		if c.UserID == arg.UserID && c.CodeHash == arg.CodeHash && !c.UsedAt.Valid {
+			c.UsedAt = sql.NullTime{Time: time.Now(), Valid: true}
+			m.recovery[id] = c
+			return 1, nil
+		}
+	}
+	return 0, nil
+}
+
+func (m *Memory) userByEmail(email string) (db.User, bool) {
+	for _, u := range m.users {
+		if u.Email == email {
+			return u, true
+		}
+	}
+	return db.User{}, false
+}
+
+// --- Appointments ---
+
+func (m *Memory) CreateAppointment(ctx context.Context, arg db.CreateAppointmentParams) (db.Appointment, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if arg.UserID.Valid {
+		if _, ok := m.users[arg.UserID.UUID]; !ok {
+			return db.Appointment{}, errors.New("store: appointment user does not exist")
+		}
+	}
+	a := db.Appointment{
+		ID:          arg.ID,
+		UserID:      arg.UserID,
+		Datetime:    arg.Datetime,
+		Title:       arg.Title,
+		Description: arg.Description,
+		Status:      "pending",
+		CreatedAt:   time.Now(),
+		Version:     1,
+	}
+	m.appts[a.ID] = a
+	return a, nil
+}
+
+func (m *Memory) GetAppointmentsByID(ctx context.Context, id uuid.UUID) (db.Appointment, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if a, ok := m.appts[id]; ok {
+		return a, nil
+	}
+	return db.Appointment{}, sql.ErrNoRows
+}
+
+func (m *Memory) GetAppointmentsForUser(ctx context.Context, userID uuid.NullUUID) ([]db.Appointment, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var out []db.Appointment
+	for _, a := range m.appts {
+		if userID.Valid && a.UserID == userID {
+			out = append(out, a)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt.After(out[j].CreatedAt) })
+	return out, nil
+}
+
+func (m *Memory) GetAppointmentsForDay(ctx context.Context, arg db.GetAppointmentsForDayParams) ([]db.GetAppointmentsForDayRow, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var out []db.GetAppointmentsForDayRow
+	for _, a := range m.appts {
+		u, ok := m.users[a.UserID.UUID]
+		if !ok || a.Datetime.Before(arg.DayStart) || !a.Datetime.Before(arg.DayEnd) {
+			continue
+		}
+		out = append(out, db.GetAppointmentsForDayRow(joinUser(a, u)))
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Datetime.Before(out[j].Datetime) })
+	return out, nil
+}
+
+func (m *Memory) GetAllAppointments(ctx context.Context) ([]db.GetAllAppointmentsRow, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var out []db.GetAllAppointmentsRow
+	for _, a := range m.appts {
+		// inner join, appointments without a user are left out
+		if u, ok := m.users[a.UserID.UUID]; ok {
+			out = append(out, joinUser(a, u))
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt.After(out[j].CreatedAt) })
+	return out, nil
+}
+
+func (m *Memory) CountAppointmentsByStatus(ctx context.Context) ([]db.CountAppointmentsByStatusRow, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	counts := map[string]int64{}
+	for _, a := range m.appts {
+		counts[a.Status]++
+	}
+	var out []db.CountAppointmentsByStatusRow
+	for status, n := range counts {
+		out = append(out, db.CountAppointmentsByStatusRow{Status: status, Count: n})
+	}
+	return out, nil
+}
+
+func (m *Memory) UpdateAppointmentStatus(ctx context.Context, arg db.UpdateAppointmentStatusParams) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	a, ok := m.appts[arg.ID]
+	if !ok || a.Version != arg.Version {
+		return 0, nil
+	}
+	a.Status = arg.Status
+	a.Version++
+	m.appts[a.ID] = a
+	return 1, nil
+}
+
+func (m *Memory) UserUpdateAppointment(ctx context.Context, arg db.UserUpdateAppointmentParams) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	a, ok := m.appts[arg.ID]
+	if !ok || a.UserID != arg.UserID || a.Version != arg.Version {
+		return 0, nil
+	}
+	a.Datetime = arg.Datetime
+	a.Title = arg.Title
+	a.Description = arg.Description
+	a.Version++
+	m.appts[a.ID] = a
+	return 1, nil
+}
+
+func (m *Memory) DeleteAppointment(ctx context.Context, id uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.appts, id)
+	// ON DELETE CASCADE
+	for mid, msg := range m.messages {
+		if msg.AppointmentID == id {
+			delete(m.messages, mid)
+		}
+	}
+	for aid, att := range m.attachments {
+		if att.AppointmentID == id {
+			delete(m.attachments, aid)
+		}
+	}
+	return nil
+}
+
+// --- Messages ---
+
+func (m *Memory) CreateMessage(ctx context.Context, arg db.CreateMessageParams) (db.Message, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.appts[arg.AppointmentID]; !ok {
+		return db.Message{}, errors.New("store: message appointment does not exist")
+	}
+	msg := db.Message{
+		ID:            arg.ID,
+		AppointmentID: arg.AppointmentID,
+		SenderID:      arg.SenderID,
+		SenderIsStaff: arg.SenderIsStaff,
+		Body:          arg.Body,
+		CreatedAt:     m.now(),
+	}
+	m.messages[msg.ID] = msg
+	return msg, nil
+}
+
+func (m *Memory) GetMessagesForAppointment(ctx context.Context, appointmentID uuid.UUID) ([]db.Message, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var out []db.Message
+	for _, msg := range m.messages {
+		if msg.AppointmentID == appointmentID {
+			out = append(out, msg)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt.Before(out[j].CreatedAt) })
+	return out, nil
+}
+
+func (m *Memory) MarkMessagesRead(ctx context.Context, arg db.MarkMessagesReadParams) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var n int64
+	for id, msg := range m.messages {
+		if msg.AppointmentID == arg.AppointmentID && msg.SenderIsStaff == arg.SenderIsStaff && !msg.ReadAt.Valid {
+			msg.ReadAt = sql.NullTime{Time: time.Now(), Valid: true}
+			m.messages[id] = msg
+			n++
+		}
+	}
+	return n, nil
+}
+
+// --- Attachments ---
+
+func (m *Memory) CreateAttachment(ctx context.Context, arg db.CreateAttachmentParams) (db.Attachment, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.appts[arg.AppointmentID]; !ok {
+		return db.Attachment{}, errors.New("store: attachment appointment does not exist")
+	}
+	att := db.Attachment{
+		ID:            arg.ID,
+		AppointmentID: arg.AppointmentID,
+		UploaderID:    arg.UploaderID,
+		Filename:      arg.Filename,
+		ContentType:   arg.ContentType,
+		SizeBytes:     arg.SizeBytes,
+		StorageKey:    arg.StorageKey,
+		ThumbnailKey:  arg.ThumbnailKey,
+		CreatedAt:     m.now(),
+	}
+	m.attachments[att.ID] = att
+	return att, nil
+}
+
+func (m *Memory) GetAttachmentsForAppointment(ctx context.Context, appointmentID uuid.UUID) ([]db.Attachment, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var out []db.Attachment
+	for _, att := range m.attachments {
+		if att.AppointmentID == appointmentID {
+			out = append(out, att)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt.Before(out[j].CreatedAt) })
+	return out, nil
+}
+
+func (m *Memory) GetAttachmentByID(ctx context.Context, id uuid.UUID) (db.Attachment, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if att, ok := m.attachments[id]; ok {
+		return att, nil
+	}
+	return db.Attachment{}, sql.ErrNoRows
+}
+
+// --- Idempotency keys ---
+
+// idempotencyTTL matches the 24 hour interval in the SQL queries.
+const idempotencyTTL = 24 * time.Hour
+
+func (m *Memory) GetIdempotencyKey(ctx context.Context, arg db.GetIdempotencyKeyParams) (db.IdempotencyKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	k, ok := m.idempotency[[2]string{arg.Key, arg.UserID}]
+	if !ok || time.Since(k.CreatedAt) >= idempotencyTTL {
+		return db.IdempotencyKey{}, sql.ErrNoRows
+	}
+	return k, nil
+}
+
+func (m *Memory) ClaimIdempotencyKey(ctx context.Context, arg db.ClaimIdempotencyKeyParams) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	id := [2]string{arg.Key, arg.UserID}
+	if k, ok := m.idempotency[id]; ok && time.Since(k.CreatedAt) < idempotencyTTL {
+		return 0, nil
+	}
+	m.idempotency[id] = db.IdempotencyKey{
+		Key:         arg.Key,
+		UserID:      arg.UserID,
+		RequestHash: arg.RequestHash,
+		CreatedAt:   time.Now(),
+	}
+	return 1, nil
+}
+
+func (m *Memory) CompleteIdempotencyKey(ctx context.Context, arg db.CompleteIdempotencyKeyParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	id := [2]string{arg.Key, arg.UserID}
+	if k, ok := m.idempotency[id]; ok {
+		k.StatusCode = arg.StatusCode
+		k.ContentType = arg.ContentType
+		k.ResponseBody = arg.ResponseBody
+		m.idempotency[id] = k
+	}
+	return nil
+}
+
+func (m *Memory) DeleteIdempotencyKey(ctx context.Context, arg db.DeleteIdempotencyKeyParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.idempotency, [2]string{arg.Key, arg.UserID})
+	return nil
+}
+
+func (m *Memory) DeleteExpiredIdempotencyKeys(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for id, k := range m.idempotency {
+		if time.Since(k.CreatedAt) >= idempotencyTTL {
+			delete(m.idempotency, id)
+		}
+	}
+	return nil
+}
+
+// --- Passkeys ---
+
+func (m *Memory) CreateWebAuthnCredential(ctx context.Context, arg db.CreateWebAuthnCredentialParams) (db.WebauthnCredential, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, c := range m.passkeys {
+		if bytes.Equal(c.CredentialID, arg.CredentialID) {
+			return db.WebauthnCredential{}, ErrDuplicateCredential
+		}
+	}
+	c := db.WebauthnCredential{
+		ID:              arg.ID,
+		UserID:          arg.UserID,
+		CredentialID:    arg.CredentialID,
+		PublicKey:       arg.PublicKey,
+		AttestationType: arg.AttestationType,
+		Transports:      arg.Transports,
+		Aaguid:          arg.Aaguid,
+		SignCount:       arg.SignCount,
+		BackupEligible:  arg.BackupEligible,
+		BackupState:     arg.BackupState,
+		CreatedAt:       time.Now(),
+	}
+	m.passkeys[c.ID] = c
+	return c, nil
+}
+
+func (m *Memory) GetWebAuthnCredentialsForUser(ctx context.Context, userID uuid.UUID) ([]db.WebauthnCredential, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var out []db.WebauthnCredential
+	for _, c := range m.passkeys {
+		if c.UserID == userID {
+			out = append(out, c)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt.Before(out[j].CreatedAt) })
+	return out, nil
+}
+
+func (m *Memory) UpdateWebAuthnCredentialUse(ctx context.Context, arg db.UpdateWebAuthnCredentialUseParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for id, c := range m.passkeys {
+		if bytes.Equal(c.CredentialID, arg.CredentialID) {
+			c.SignCount = arg.SignCount
+			c.BackupState = arg.BackupState
+			c.LastUsedAt = sql.NullTime{Time: time.Now(), Valid: true}
+			m.passkeys[id] = c
+		}
+	}
+	return nil
+}
+
+func (m *Memory) DeleteWebAuthnCredential(ctx context.Context, arg db.DeleteWebAuthnCredentialParams) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if c, ok := m.passkeys[arg.ID]; ok && c.UserID == arg.UserID {
+		delete(m.passkeys, arg.ID)
+		return 1, nil
+	}
+	return 0, nil
+}
+
+func (m *Memory) CreateWebAuthnChallenge(ctx context.Context, arg db.CreateWebAuthnChallengeParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.challenges[arg.Challenge] = db.WebauthnChallenge{
+		Challenge: arg.Challenge,
+		UserID:    arg.UserID,
+		Session:   arg.Session,
+		ExpiresAt: arg.ExpiresAt,
+	}
+	return nil
+}
+
+func (m *Memory) TakeWebAuthnChallenge(ctx context.Context, challenge string) (db.WebauthnChallenge, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c, ok := m.challenges[challenge]
+	if !ok {
+		return db.WebauthnChallenge{}, sql.ErrNoRows
+	}
+	delete(m.challenges, challenge)
+	return c, nil
+}
+
+func (m *Memory) DeleteExpiredWebAuthnChallenges(ctx context.Context, expiresAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for k, c := range m.challenges {
+		if c.ExpiresAt.Before(expiresAt) {
+			delete(m.challenges, k)
+		}
+	}
+	return nil
+}
+
+// --- Magic links ---
+
+func (m *Memory) CreateMagicLinkToken(ctx context.Context, arg db.CreateMagicLinkTokenParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.magicLinks[arg.TokenHash] = db.MagicLinkToken{
+		TokenHash: arg.TokenHash,
+		UserID:    arg.UserID,
+		Email:     arg.Email,
+		ExpiresAt: arg.ExpiresAt,
+		CreatedAt: time.Now(),
+	}
+	return nil
+}
+
+func (m *Memory) UseMagicLinkToken(ctx context.Context, arg db.UseMagicLinkTokenParams) (uuid.UUID, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t, ok := m.magicLinks[arg.TokenHash]
+	if !ok || t.Email != arg.Email || t.UsedAt.Valid || !t.ExpiresAt.After(arg.ExpiresAt) {
+		return uuid.Nil, sql.ErrNoRows
+	}
+	t.UsedAt = sql.NullTime{Time: time.Now(), Valid: true}
+	m.magicLinks[arg.TokenHash] = t
+	return t.UserID, nil
+}
+
+func (m *Memory) DeleteExpiredMagicLinkTokens(ctx context.Context, expiresAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for k, t := range m.magicLinks {
+		if t.ExpiresAt.Before(expiresAt) {
+			delete(m.magicLinks, k)
+		}
+	}
+	return nil
+}
+
+// --- Identities ---
+
+func (m *Memory) CreateUserIdentity(ctx context.Context, arg db.CreateUserIdentityParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := [2]string{arg.Provider, arg.Subject}
+	if _, ok := m.identities[key]; ok {
+		return nil
+	}
+	m.identities[key] = db.UserIdentity{
+		Provider:  arg.Provider,
+		Subject:   arg.Subject,
+		UserID:    arg.UserID,
+		Email:     arg.Email,
+		CreatedAt: time.Now(),
+	}
+	return nil
+}
+
+func (m *Memory) GetUserIdentity(ctx context.Context, arg db.GetUserIdentityParams) (db.UserIdentity, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if i, ok := m.identities[[2]string{arg.Provider, arg.Subject}]; ok {
+		return i, nil
+	}
+	return db.UserIdentity{}, sql.ErrNoRows
+}
+
+func (m *Memory) TouchUserIdentity(ctx context.Context, arg db.TouchUserIdentityParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := [2]string{arg.Provider, arg.Subject}
+	if i, ok := m.identities[key]; ok {
+		i.LastLoginAt = sql.NullTime{Time: time.Now(), Valid: true}
+		m.identities[key] = i
+	}
+	return nil
+}
+
+func (m *Memory) CreateOIDCState(ctx context.Context, arg db.CreateOIDCStateParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.oidcStates[arg.State] = db.OidcState{
+		State:        arg.State,
+		Provider:     arg.Provider,
+		Nonce:        arg.Nonce,
+		CodeVerifier: arg.CodeVerifier,
+		ExpiresAt:    arg.ExpiresAt,
+	}
+	return nil
+}
+
+func (m *Memory) TakeOIDCState(ctx context.Context, state string) (db.OidcState, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	st, ok := m.oidcStates[state]
+	if !ok {
+		return db.OidcState{}, sql.ErrNoRows
+	}
+	delete(m.oidcStates, state)
+	return st, nil
+}
+
+func (m *Memory) DeleteExpiredOIDCStates(ctx context.Context, expiresAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for k, st := range m.oidcStates {
+		if st.ExpiresAt.Before(expiresAt) {
+			delete(m.oidcStates, k)
+		}
+	}
+	return nil
+}
+
+func joinUser(a db.Appointment, u db.User) db.GetAllAppointmentsRow {
+	return db.GetAllAppointmentsRow{
+		ID:          a.ID,
+		UserID:      a.UserID,
+		Datetime:    a.Datetime,
+		Title:       a.Title,
+		Description: a.Description,
+		Status:      a.Status,
+		CreatedAt:   a.CreatedAt,
+		Version:     a.Version,
+		UserName:    u.Name,
+		UserEmail:   u.Email,
+		UserPhone:   u.Phone,
+	}
+}
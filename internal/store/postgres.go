@@ -0,0 +1,43 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/nickg76/garage-backend/internal/db"
+	"github.com/nickg76/garage-backend/internal/tracing"
+)
+
+// Postgres is the production Store, backed by the sqlc queries.
+type Postgres struct {
+	*db.Queries
+	conn *sql.DB
+}
+
+// NewPostgres runs every query on conn, traced.
+func NewPostgres(conn *sql.DB) *Postgres {
+	return &Postgres{Queries: db.New(tracing.WrapDB(conn)), conn: conn}
+}
+
+func (p *Postgres) ConfirmTOTP(ctx context.Context, arg db.EnableTOTPParams, codes []db.CreateRecoveryCodeParams) error {
+	tx, err := p.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	q := db.New(tracing.WrapDB(tx))
+	if err := q.DeleteRecoveryCodes(ctx, arg.ID); err != nil {
+		return err
+	}
+	for _, c := range codes {
+		if err := q.CreateRecoveryCode(ctx, c); err != nil {
+			return err
+		}
+	}
+	if err := q.EnableTOTP(ctx, arg); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+var _ Store = (*Postgres)(nil)
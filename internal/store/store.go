@@ -0,0 +1,118 @@
+// Package store is the persistence boundary for users, appointments, their
+// messages and attachments, idempotency keys and the various ways of
+// signing in.
+//
+// Handlers depend on the Store interface rather than on *db.Queries so they
+// can run against the in-memory implementation without a database. The
+// method set mirrors the sqlc queries, including their parameter and row
+// types, and a missing row is reported as sql.ErrNoRows in every
+// implementation.
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/nickg76/garage-backend/internal/db"
+)
+
+type Users interface {
+	CreateUser(ctx context.Context, arg db.CreateUserParams) (db.User, error)
+	GetUserByEmail(ctx context.Context, email string) (db.User, error)
+	GetUserByID(ctx context.Context, id uuid.UUID) (db.User, error)
+	GetAdminUserIDs(ctx context.Context) ([]uuid.UUID, error)
+	SetAdmin(ctx context.Context, arg db.SetAdminParams) (int64, error)
+	SetUserDisabled(ctx context.Context, arg db.SetUserDisabledParams) (int64, error)
+	RecordFailedLogin(ctx context.Context, id uuid.UUID) (int32, error)
+	LockUser(ctx context.Context, arg db.LockUserParams) error
+	ResetFailedLogins(ctx context.Context, id uuid.UUID) error
+	GetLockedUsers(ctx context.Context, lockedUntil sql.NullTime) ([]db.GetLockedUsersRow, error)
+	SetTOTPSecret(ctx context.Context, arg db.SetTOTPSecretParams) error
+	UseTOTPStep(ctx context.Context, arg db.UseTOTPStepParams) (int64, error)
+	DisableTOTP(ctx context.Context, email string) (int64, error)
+	// ConfirmTOTP replaces the user's recovery codes with codes and enables
+	// TOTP, all or nothing.
+	ConfirmTOTP(ctx context.Context, arg db.EnableTOTPParams, codes []db.CreateRecoveryCodeParams) error
+	UseRecoveryCode(ctx context.Context, arg db.UseRecoveryCodeParams) (int64, error)
+}
+
+type Appointments interface {
+	CreateAppointment(ctx context.Context, arg db.CreateAppointmentParams) (db.Appointment, error)
+	GetAppointmentsByID(ctx context.Context, id uuid.UUID) (db.Appointment, error)
+	GetAppointmentsForUser(ctx context.Context, userID uuid.NullUUID) ([]db.Appointment, error)
+	GetAppointmentsForDay(ctx context.Context, arg db.GetAppointmentsForDayParams) ([]db.GetAppointmentsForDayRow, error)
+	GetAllAppointments(ctx context.Context) ([]db.GetAllAppointmentsRow, error)
+	CountAppointmentsByStatus(ctx context.Context) ([]db.CountAppointmentsByStatusRow, error)
+	UpdateAppointmentStatus(ctx context.Context, arg db.UpdateAppointmentStatusParams) (int64, error)
+	UserUpdateAppointment(ctx context.Context, arg db.UserUpdateAppointmentParams) (int64, error)
+	DeleteAppointment(ctx context.Context, id uuid.UUID) error
+}
+
+// Messages is the conversation on each appointment.
+type Messages interface {
+	CreateMessage(ctx context.Context, arg db.CreateMessageParams) (db.Message, error)
+	GetMessagesForAppointment(ctx context.Context, appointmentID uuid.UUID) ([]db.Message, error)
+	MarkMessagesRead(ctx context.Context, arg db.MarkMessagesReadParams) (int64, error)
+}
+
+// Attachments is the metadata of uploaded files, the blobs themselves live
+// in storage.Storage.
+type Attachments interface {
+	CreateAttachment(ctx context.Context, arg db.CreateAttachmentParams) (db.Attachment, error)
+	GetAttachmentsForAppointment(ctx context.Context, appointmentID uuid.UUID) ([]db.Attachment, error)
+	GetAttachmentByID(ctx context.Context, id uuid.UUID) (db.Attachment, error)
+}
+
+// IdempotencyKeys remembers responses to requests sent with an
+// Idempotency-Key header for 24 hours.
+type IdempotencyKeys interface {
+	GetIdempotencyKey(ctx context.Context, arg db.GetIdempotencyKeyParams) (db.IdempotencyKey, error)
+	ClaimIdempotencyKey(ctx context.Context, arg db.ClaimIdempotencyKeyParams) (int64, error)
+	CompleteIdempotencyKey(ctx context.Context, arg db.CompleteIdempotencyKeyParams) error
+	DeleteIdempotencyKey(ctx context.Context, arg db.DeleteIdempotencyKeyParams) error
+	DeleteExpiredIdempotencyKeys(ctx context.Context) error
+}
+
+// Passkeys holds WebAuthn credentials and the state of ceremonies in
+// progress.
+type Passkeys interface {
+	CreateWebAuthnCredential(ctx context.Context, arg db.CreateWebAuthnCredentialParams) (db.WebauthnCredential, error)
+	GetWebAuthnCredentialsForUser(ctx context.Context, userID uuid.UUID) ([]db.WebauthnCredential, error)
+	UpdateWebAuthnCredentialUse(ctx context.Context, arg db.UpdateWebAuthnCredentialUseParams) error
+	DeleteWebAuthnCredential(ctx context.Context, arg db.DeleteWebAuthnCredentialParams) (int64, error)
+	CreateWebAuthnChallenge(ctx context.Context, arg db.CreateWebAuthnChallengeParams) error
+	TakeWebAuthnChallenge(ctx context.Context, challenge string) (db.WebauthnChallenge, error)
+	DeleteExpiredWebAuthnChallenges(ctx context.Context, expiresAt time.Time) error
+}
+
+// MagicLinks holds the hashed tokens behind emailed sign-in links.
+type MagicLinks interface {
+	CreateMagicLinkToken(ctx context.Context, arg db.CreateMagicLinkTokenParams) error
+	UseMagicLinkToken(ctx context.Context, arg db.UseMagicLinkTokenParams) (uuid.UUID, error)
+	DeleteExpiredMagicLinkTokens(ctx context.Context, expiresAt time.Time) error
+}
+
+// Identities links users to OpenID Connect accounts and holds sign-ins
+// waiting for the provider to redirect back.
+type Identities interface {
+	CreateUserIdentity(ctx context.Context, arg db.CreateUserIdentityParams) error
+	GetUserIdentity(ctx context.Context, arg db.GetUserIdentityParams) (db.UserIdentity, error)
+	TouchUserIdentity(ctx context.Context, arg db.TouchUserIdentityParams) error
+	CreateOIDCState(ctx context.Context, arg db.CreateOIDCStateParams) error
+	TakeOIDCState(ctx context.Context, state string) (db.OidcState, error)
+	DeleteExpiredOIDCStates(ctx context.Context, expiresAt time.Time) error
+}
+
+type Store interface {
+	Users
+	Appointments
+	Messages
+	Attachments
+	IdempotencyKeys
+	Passkeys
+	MagicLinks
+	Identities
+}
@@ -0,0 +1,46 @@
+package tracing
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+)
+
+func TestMiddlewareRecordsRouteAndStatus(t *testing.T) {
+	rec := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec)))
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /api/appointments/{id}", Route("GET /api/appointments/{id}",
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusBadGateway)
+		})))
+	Middleware(mux).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/appointments/42", nil))
+
+	spans := rec.Ended()
+	if len(spans) != 1 {
+		t.Fatalf("got %d spans, want 1", len(spans))
+	}
+	span := spans[0]
+	if span.Name() != "GET /api/appointments/{id}" {
+		t.Errorf("span name %q", span.Name())
+	}
+	if span.Status().Code != codes.Error {
+		t.Errorf("span status %v, want error for a 502", span.Status())
+	}
+	var status int64
+	for _, a := range span.Attributes() {
+		if a.Key == semconv.HTTPResponseStatusCodeKey {
+			status = a.Value.AsInt64()
+		}
+	}
+	if status != http.StatusBadGateway {
+		t.Errorf("status attribute %d, want 502", status)
+	}
+}
@@ -2,30 +2,126 @@
 package main
 
 import (
-	"log"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
-	"github.com/joho/godotenv"
+	"github.com/nickg76/garage-backend/internal/config"
 	"github.com/nickg76/garage-backend/internal/handlers"
 	"github.com/nickg76/garage-backend/internal/server"
+	"github.com/nickg76/garage-backend/internal/tracing"
 )
 
 func main() {
-	err := godotenv.Load()
+	// migrations run before the secrets the server needs are provisioned
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		cfg, err := config.LoadDatabase()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "config: %v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(runMigrate(cfg, os.Args[2:]))
+	}
+
+	cfg, err := config.Load()
 	if err != nil {
-		log.Println("No .env file found, relying on environment variables")
+		fmt.Fprintf(os.Stderr, "config: %v\n", err)
+		os.Exit(1)
 	}
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
+	// SetDefault also sends the standard log package through this handler
+	slog.SetDefault(newLogger(cfg.Log))
+	port := cfg.Port
+
+	if cfg.AutoMigrate {
+		if err := autoMigrate(context.Background(), cfg); err != nil {
+			slog.Error("auto-migrate", "err", err)
+			os.Exit(1)
+		}
 	}
 
-	srv := handlers.NewServer()
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.Tracing)
+	if err != nil {
+		slog.Error("tracing", "err", err)
+		os.Exit(1)
+	}
+	defer func() {
+		// flush spans buffered by the batcher
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			slog.Error("flushing traces", "err", err)
+		}
+	}()
+
+	srv, err := handlers.NewServer(cfg)
+	if err != nil {
+		slog.Error("starting server", "err", err)
+		os.Exit(1)
+	}
+	defer func() {
+		if err := srv.Close(); err != nil {
+			slog.Error("closing database", "err", err)
+		}
+	}()
 	mux := server.Routes(srv)
 
-	log.Printf("Listening on http://localhost:%s", port)
-	if err := http.ListenAndServe(":"+port, mux); err != nil {
-		log.Fatal(err)
+	httpSrv := &http.Server{
+		Addr:              ":" + port,
+		Handler:           mux,
+		ReadHeaderTimeout: cfg.HTTP.ReadHeaderTimeout,
+		ReadTimeout:       cfg.HTTP.ReadTimeout,
+		WriteTimeout:      cfg.HTTP.WriteTimeout,
+		IdleTimeout:       cfg.HTTP.IdleTimeout,
+	}
+	// tell SSE clients to reconnect elsewhere as soon as shutdown starts
+	httpSrv.RegisterOnShutdown(srv.Shutdown)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	errCh := make(chan error, 1)
+	go func() {
+		slog.Info("listening", "addr", httpSrv.Addr)
+		errCh <- httpSrv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errCh:
+		if !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("server error", "err", err)
+		}
+		return
+	case <-ctx.Done():
+	}
+	stop()
+
+	// fail readiness first so the load balancer stops routing to us
+	srv.BeginDrain()
+	time.Sleep(cfg.HTTP.ShutdownDelay)
+
+	drain := cfg.HTTP.ShutdownTimeout
+	slog.Info("shutting down", "drain", drain)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), drain)
+	defer cancel()
+	if err := httpSrv.Shutdown(shutdownCtx); err != nil {
+		slog.Error("shutdown", "err", err)
+	}
+}
+
+// newLogger builds the process-wide logger from the LOG_* settings.
+func newLogger(cfg config.LogConfig) *slog.Logger {
+	opts := &slog.HandlerOptions{Level: cfg.SlogLevel()}
+	var h slog.Handler
+	if cfg.Format == "text" {
+		h = slog.NewTextHandler(os.Stderr, opts)
+	} else {
+		h = slog.NewJSONHandler(os.Stderr, opts)
 	}
+	return slog.New(h)
 }
@@ -0,0 +1,61 @@
+// migrate.go
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+
+	_ "github.com/lib/pq"
+
+	"github.com/nickg76/garage-backend/internal/config"
+	"github.com/nickg76/garage-backend/internal/migrate"
+)
+
+const migrateUsage = `usage: garage-backend migrate <command>
+
+commands:
+  up                  apply all pending migrations
+  down                roll back the most recent migration
+  status              list migrations and when they were applied
+  baseline <version>  mark migrations up to version as applied without
+                      running them, for a database migrated by hand`
+
+// runMigrate implements "garage-backend migrate ..." and returns the exit
+// code.
+func runMigrate(cfg *config.Config, args []string) int {
+	conn, err := sql.Open("postgres", cfg.DatabaseURL)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "database: %v\n", err)
+		return 1
+	}
+	defer conn.Close()
+	err = migrate.RunCommand(context.Background(), conn, args, os.Stdout)
+	if errors.Is(err, migrate.ErrUsage) {
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "migrate: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// autoMigrate applies pending migrations before the server starts. Every
+// replica may do this at once, the advisory lock serialises them.
+func autoMigrate(ctx context.Context, cfg *config.Config) error {
+	conn, err := sql.Open("postgres", cfg.DatabaseURL)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	m, err := migrate.New(conn)
+	if err != nil {
+		return err
+	}
+	_, err = m.Up(ctx)
+	return err
+}
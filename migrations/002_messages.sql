@@ -0,0 +1,15 @@
+-- +goose Up
+CREATE TABLE messages (
+    id UUID PRIMARY KEY,
+    appointment_id UUID NOT NULL REFERENCES appointments(id) ON DELETE CASCADE,
+    sender_id UUID REFERENCES users(id) ON DELETE SET NULL,
+    sender_is_staff BOOLEAN NOT NULL DEFAULT FALSE,
+    body TEXT NOT NULL,
+    read_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT now()
+);
+
+CREATE INDEX messages_appointment_id_idx ON messages (appointment_id, created_at);
+
+-- +goose Down
+DROP TABLE messages;
@@ -0,0 +1,16 @@
+-- +goose Up
+CREATE TABLE idempotency_keys (
+    key TEXT NOT NULL,
+    user_id TEXT NOT NULL DEFAULT '', -- empty for unauthenticated requests
+    request_hash TEXT NOT NULL,
+    status_code INTEGER, -- NULL while the first request is still running
+    content_type TEXT,
+    response_body BYTEA,
+    created_at TIMESTAMP NOT NULL DEFAULT now(),
+    PRIMARY KEY (key, user_id)
+);
+
+CREATE INDEX idempotency_keys_created_at_idx ON idempotency_keys (created_at);
+
+-- +goose Down
+DROP TABLE idempotency_keys;
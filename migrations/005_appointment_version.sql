@@ -0,0 +1,5 @@
+-- +goose Up
+ALTER TABLE appointments ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+
+-- +goose Down
+ALTER TABLE appointments DROP COLUMN version;
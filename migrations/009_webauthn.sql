@@ -0,0 +1,30 @@
+-- +goose Up
+CREATE TABLE webauthn_credentials (
+    id UUID PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    credential_id BYTEA NOT NULL UNIQUE,
+    public_key BYTEA NOT NULL,
+    attestation_type TEXT NOT NULL,
+    transports TEXT NOT NULL DEFAULT '', -- comma separated
+    aaguid BYTEA NOT NULL,
+    sign_count BIGINT NOT NULL DEFAULT 0,
+    backup_eligible BOOLEAN NOT NULL DEFAULT false,
+    backup_state BOOLEAN NOT NULL DEFAULT false,
+    created_at TIMESTAMP NOT NULL DEFAULT now(),
+    last_used_at TIMESTAMP
+);
+
+CREATE INDEX webauthn_credentials_user_id_idx ON webauthn_credentials (user_id);
+
+-- Ceremony state between the begin and finish calls, keyed by the challenge
+-- and deleted when used.
+CREATE TABLE webauthn_challenges (
+    challenge TEXT PRIMARY KEY,
+    user_id UUID REFERENCES users(id) ON DELETE CASCADE, -- NULL for sign-in
+    session BYTEA NOT NULL,
+    expires_at TIMESTAMP NOT NULL
+);
+
+-- +goose Down
+DROP TABLE webauthn_challenges;
+DROP TABLE webauthn_credentials;
@@ -0,0 +1,16 @@
+-- +goose Up
+-- Passwordless sign-in links. Only a hash of the token is kept, and a link
+-- only works for the address it was sent to.
+CREATE TABLE magic_link_tokens (
+    token_hash TEXT PRIMARY KEY, -- hex SHA-256
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    email TEXT NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    used_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT now()
+);
+
+CREATE INDEX magic_link_tokens_expires_at_idx ON magic_link_tokens (expires_at);
+
+-- +goose Down
+DROP TABLE magic_link_tokens;
@@ -0,0 +1,28 @@
+-- +goose Up
+-- Accounts at external OpenID Connect providers, linked to a user by
+-- verified email the first time they sign in.
+CREATE TABLE user_identities (
+    provider TEXT NOT NULL,
+    subject TEXT NOT NULL, -- the provider's sub claim
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    email TEXT NOT NULL, -- as asserted when linked
+    created_at TIMESTAMP NOT NULL DEFAULT now(),
+    last_login_at TIMESTAMP,
+    PRIMARY KEY (provider, subject)
+);
+
+CREATE INDEX user_identities_user_id_idx ON user_identities (user_id);
+
+-- Sign-ins waiting for the provider to redirect back, keyed by the state
+-- parameter and deleted when used.
+CREATE TABLE oidc_states (
+    state TEXT PRIMARY KEY,
+    provider TEXT NOT NULL,
+    nonce TEXT NOT NULL,
+    code_verifier TEXT NOT NULL, -- PKCE
+    expires_at TIMESTAMP NOT NULL
+);
+
+-- +goose Down
+DROP TABLE oidc_states;
+DROP TABLE user_identities;
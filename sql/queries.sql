@@ -20,15 +20,22 @@ SELECT * FROM users WHERE id = $1;
 -- name: GetAppointmentsForUser :many
 SELECT * FROM appointments WHERE user_id = $1 ORDER BY created_at DESC;
 
--- name: UpdateAppointmentStatus :exec
-UPDATE appointments SET status = $2 WHERE id = $1;
+-- name: UpdateAppointmentStatus :execrows
+UPDATE appointments SET status = $2, version = version + 1 WHERE id = $1 AND version = $3;
 
--- name: UserUpdateAppointment :exec
-UPDATE appointments SET datetime = $2, title = $3, description = $4 WHERE user_id = $5 AND id = $1;
+-- name: UserUpdateAppointment :execrows
+UPDATE appointments SET datetime = $2, title = $3, description = $4, version = version + 1
+WHERE user_id = $5 AND id = $1 AND version = $6;
 
--- name: SetAdmin :exec
+-- name: SetAdmin :execrows
 UPDATE users SET is_admin = $2 WHERE email = $1;
 
+-- name: SetUserDisabled :execrows
+UPDATE users SET disabled_at = $2 WHERE email = $1;
+
+-- name: SetPasswordHash :execrows
+UPDATE users SET password_hash = $2, failed_logins = 0, locked_until = NULL WHERE email = $1;
+
 -- name: GetAllAppointments :many
 SELECT
   a.id,
@@ -38,6 +45,7 @@ SELECT
   a.description,
   a.status,
   a.created_at,
+  a.version,
   u.name AS user_name,
   u.email AS user_email,
   u.phone AS user_phone
@@ -45,7 +53,183 @@ FROM appointments a
 JOIN users u ON a.user_id = u.id
 ORDER BY a.created_at DESC;
 
+-- name: GetAppointmentsForDay :many
+SELECT
+  a.id,
+  a.user_id,
+  a.datetime,
+  a.title,
+  a.description,
+  a.status,
+  a.created_at,
+  a.version,
+  u.name AS user_name,
+  u.email AS user_email,
+  u.phone AS user_phone
+FROM appointments a
+JOIN users u ON a.user_id = u.id
+WHERE a.datetime >= sqlc.arg(day_start) AND a.datetime < sqlc.arg(day_end)
+ORDER BY a.datetime ASC;
+
 -- name: GetAppointmentsByID :one
-SELECT id, user_id, datetime, title, description, status, created_at
+SELECT id, user_id, datetime, title, description, status, created_at, version
 FROM appointments
 WHERE id = $1;
+
+-- name: CreateMessage :one
+INSERT INTO messages (id, appointment_id, sender_id, sender_is_staff, body)
+VALUES ($1, $2, $3, $4, $5)
+RETURNING *;
+
+-- name: GetMessagesForAppointment :many
+SELECT * FROM messages WHERE appointment_id = $1 ORDER BY created_at ASC;
+
+-- name: MarkMessagesRead :execrows
+UPDATE messages SET read_at = now()
+WHERE appointment_id = $1 AND sender_is_staff = $2 AND read_at IS NULL;
+
+-- name: GetAdminUserIDs :many
+SELECT id FROM users WHERE is_admin = TRUE;
+
+-- name: CreateAttachment :one
+INSERT INTO attachments (id, appointment_id, uploader_id, filename, content_type, size_bytes, storage_key, thumbnail_key)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+RETURNING *;
+
+-- name: GetAttachmentsForAppointment :many
+SELECT * FROM attachments WHERE appointment_id = $1 ORDER BY created_at ASC;
+
+-- name: GetAttachmentByID :one
+SELECT * FROM attachments WHERE id = $1;
+
+-- name: GetIdempotencyKey :one
+SELECT * FROM idempotency_keys
+WHERE key = $1 AND user_id = $2 AND created_at > now() - interval '24 hours';
+
+-- name: ClaimIdempotencyKey :execrows
+INSERT INTO idempotency_keys (key, user_id, request_hash)
+VALUES ($1, $2, $3)
+ON CONFLICT (key, user_id) DO UPDATE
+SET request_hash = EXCLUDED.request_hash, status_code = NULL, content_type = NULL, response_body = NULL, created_at = now()
+WHERE idempotency_keys.created_at <= now() - interval '24 hours';
+
+-- name: CompleteIdempotencyKey :exec
+UPDATE idempotency_keys SET status_code = $3, content_type = $4, response_body = $5
+WHERE key = $1 AND user_id = $2;
+
+-- name: DeleteIdempotencyKey :exec
+DELETE FROM idempotency_keys WHERE key = $1 AND user_id = $2;
+
+-- name: DeleteExpiredIdempotencyKeys :exec
+DELETE FROM idempotency_keys WHERE created_at <= now() - interval '24 hours';
+
+-- name: CountAppointmentsByStatus :many
+SELECT status, COUNT(*) AS count FROM appointments GROUP BY status;
+
+-- name: RecordFailedLogin :one
+UPDATE users SET failed_logins = failed_logins + 1 WHERE id = $1
+RETURNING failed_logins;
+
+-- name: LockUser :exec
+UPDATE users SET locked_until = $2 WHERE id = $1;
+
+-- name: ResetFailedLogins :exec
+UPDATE users SET failed_logins = 0, locked_until = NULL WHERE id = $1;
+
+-- name: GetLockedUsers :many
+SELECT id, name, email, failed_logins, locked_until FROM users
+WHERE locked_until > $1
+ORDER BY locked_until DESC;
+
+-- name: EnsureRateLimitBucket :exec
+INSERT INTO rate_limit_buckets (key, tokens, updated_at) VALUES ($1, $2, $3)
+ON CONFLICT (key) DO NOTHING;
+
+-- name: GetRateLimitBucketForUpdate :one
+SELECT * FROM rate_limit_buckets WHERE key = $1 FOR UPDATE;
+
+-- name: UpdateRateLimitBucket :exec
+UPDATE rate_limit_buckets SET tokens = $2, updated_at = $3 WHERE key = $1;
+
+-- name: DeleteStaleRateLimitBuckets :exec
+DELETE FROM rate_limit_buckets WHERE updated_at < $1;
+
+-- name: SetTOTPSecret :exec
+UPDATE users SET totp_secret = $2, totp_last_step = NULL WHERE id = $1;
+
+-- name: EnableTOTP :exec
+UPDATE users SET totp_enabled_at = now(), totp_last_step = $2 WHERE id = $1;
+
+-- name: UseTOTPStep :execrows
+UPDATE users SET totp_last_step = $2
+WHERE id = $1 AND (totp_last_step IS NULL OR totp_last_step < $2);
+
+-- name: DisableTOTP :execrows
+UPDATE users SET totp_secret = NULL, totp_enabled_at = NULL, totp_last_step = NULL
+WHERE email = $1;
+
+-- name: CreateRecoveryCode :exec
+INSERT INTO mfa_recovery_codes (id, user_id, code_hash) VALUES ($1, $2, $3);
+
+-- name: DeleteRecoveryCodes :exec
+DELETE FROM mfa_recovery_codes WHERE user_id = $1;
+
+-- name: UseRecoveryCode :execrows
+UPDATE mfa_recovery_codes SET used_at = now()
+WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL;
+
+-- name: CreateWebAuthnCredential :one
+INSERT INTO webauthn_credentials (id, user_id, credential_id, public_key, attestation_type, transports, aaguid, sign_count, backup_eligible, backup_state)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+RETURNING *;
+
+-- name: GetWebAuthnCredentialsForUser :many
+SELECT * FROM webauthn_credentials WHERE user_id = $1 ORDER BY created_at;
+
+-- name: UpdateWebAuthnCredentialUse :exec
+UPDATE webauthn_credentials SET sign_count = $2, backup_state = $3, last_used_at = now()
+WHERE credential_id = $1;
+
+-- name: DeleteWebAuthnCredential :execrows
+DELETE FROM webauthn_credentials WHERE id = $1 AND user_id = $2;
+
+-- name: CreateWebAuthnChallenge :exec
+INSERT INTO webauthn_challenges (challenge, user_id, session, expires_at) VALUES ($1, $2, $3, $4);
+
+-- name: TakeWebAuthnChallenge :one
+DELETE FROM webauthn_challenges WHERE challenge = $1
+RETURNING *;
+
+-- name: DeleteExpiredWebAuthnChallenges :exec
+DELETE FROM webauthn_challenges WHERE expires_at < $1;
+
+-- name: CreateMagicLinkToken :exec
+INSERT INTO magic_link_tokens (token_hash, user_id, email, expires_at) VALUES ($1, $2, $3, $4);
+
+-- name: UseMagicLinkToken :one
+UPDATE magic_link_tokens SET used_at = now()
+WHERE token_hash = $1 AND email = $2 AND used_at IS NULL AND expires_at > $3
+RETURNING user_id;
+
+-- name: DeleteExpiredMagicLinkTokens :exec
+DELETE FROM magic_link_tokens WHERE expires_at < $1;
+
+-- name: CreateUserIdentity :exec
+INSERT INTO user_identities (provider, subject, user_id, email) VALUES ($1, $2, $3, $4)
+ON CONFLICT (provider, subject) DO NOTHING;
+
+-- name: GetUserIdentity :one
+SELECT * FROM user_identities WHERE provider = $1 AND subject = $2;
+
+-- name: TouchUserIdentity :exec
+UPDATE user_identities SET last_login_at = now() WHERE provider = $1 AND subject = $2;
+
+-- name: CreateOIDCState :exec
+INSERT INTO oidc_states (state, provider, nonce, code_verifier, expires_at) VALUES ($1, $2, $3, $4, $5);
+
+-- name: TakeOIDCState :one
+DELETE FROM oidc_states WHERE state = $1
+RETURNING *;
+
+-- name: DeleteExpiredOIDCStates :exec
+DELETE FROM oidc_states WHERE expires_at < $1;